@@ -41,12 +41,17 @@ func Init(reload bool) {
 		users = databases.NewTable(databases.DefaultConnection(), "users", schema)
 	}
 
+	if services == nil { // defined in services.go
+		services = databases.NewTable(databases.DefaultConnection(), "service_accounts", serviceSchema)
+	}
+
 	config := LoadAuthConfig()
 	SECRET_HASH_KEY = config.SecretKey
 	allPerms := NewPermission()
 
 	if reload {
 		users.Reload()
+		services.Reload()
 		for _, admin := range config.Admins {
 			admin.convertPermissionsFromDB()
 
@@ -121,6 +126,12 @@ func AuthMiddleware(h http.Handler, ignorePaths []string) http.Handler {
 			}
 		}
 
+		if service := authenticateService(r); service != nil {
+			setServiceSession(r, service)
+			h.ServeHTTP(w, r)
+			return
+		}
+
 		if session.GetValueOrDefault(r, "auth", "logged_in", false).(bool) {
 			h.ServeHTTP(w, r)
 			return
@@ -179,4 +190,18 @@ func Handle(r *mux.Router) {
 	userRoute.HandleFunc("/{Email}", handleUpdateUser).Methods("PUT")
 
 	userRoute.HandleFunc("/create", handleCreateUser).Methods("POST")
+
+	serviceRoute := r.PathPrefix("/services").Subrouter()
+
+	serviceRoute.HandleFunc("/list", handleListServices).Methods("GET")
+
+	serviceRoute.HandleFunc("/create", handleCreateService).Methods("POST")
+
+	serviceRoute.HandleFunc("/rotate/{Name}", handleRotateServiceKey).Methods("PUT")
+
+	serviceRoute.HandleFunc("/{Name}", handleGetService).Methods("GET")
+
+	serviceRoute.HandleFunc("/{Name}", handleUpdateService).Methods("PUT")
+
+	serviceRoute.HandleFunc("/{Name}", handleDeleteService).Methods("DELETE")
 }
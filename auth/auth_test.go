@@ -158,6 +158,12 @@ func Test_Handle(t *testing.T) {
 		{"GET", "/users/TEST"},
 		{"PUT", "/users/TEST"},
 		{"POST", "/users/create"},
+		{"GET", "/services/list"},
+		{"POST", "/services/create"},
+		{"PUT", "/services/rotate/TEST"},
+		{"GET", "/services/TEST"},
+		{"PUT", "/services/TEST"},
+		{"DELETE", "/services/TEST"},
 	}
 
 	for _, route := range routes {
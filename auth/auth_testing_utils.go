@@ -20,12 +20,15 @@ import (
 
 func SetupCustomTestingTable(table *databases.MockTable) {
 	users = table
+	services = databases.CommonTestingTable(serviceSchema)
 }
 
 func SetupTestingTable() {
-	users = databases.CommonTestingTable(schema) // schema defined in users.go
+	users = databases.CommonTestingTable(schema)           // schema defined in users.go
+	services = databases.CommonTestingTable(serviceSchema) // defined in services.go
 }
 
 func TeardownTestingTable() {
 	users = nil
+	services = nil
 }
@@ -14,18 +14,33 @@
 
 package auth
 
+import (
+	"errors"
+	"strings"
+
+	"github.com/lighthouse/lighthouse/databases"
+)
+
 type Permission map[string]interface{}
 
 const (
 	AccessAuthLevel = 0
 	ModifyAuthLevel = 1
 	OwnerAuthLevel  = 2
+
+	PermissionWildcard = "*"
+)
+
+var (
+	InvalidPermissionKeyError = errors.New("Permission keys must be non-empty and may not contain '**'")
 )
 
 func NewPermission() Permission {
 	return Permission{
 		"Beacons":      make(map[string]interface{}),
 		"Applications": make(map[string]interface{}),
+		"Hosts":        make(map[string]interface{}),
+		"Registries":   make(map[string]interface{}),
 	}
 }
 
@@ -45,24 +60,151 @@ func (this *User) convertPermissionsFromDB() {
 	}
 }
 
+/*
+   Gets the user's level for the given key. Permission keys may be
+   glob patterns using '*' (e.g. "payments-*"), in which case the most
+   specific grant wins: an exact key always takes precedence, otherwise
+   the matching pattern with the most literal characters is used. Ties
+   between equally specific patterns go to the highest level.
+
+   RETURN: The auth level, -1 if nothing matches
+*/
 func (this *User) GetAuthLevel(field, key string) int {
-	permMap, ok := this.Permissions[field]
+	permMap, ok := this.Permissions[field].(map[string]interface{})
 	if !ok {
 		return -1
 	}
 
-	val, ok := permMap.(map[string]interface{})[key]
-	if !ok {
-		return -1
+	if val, ok := permMap[key]; ok {
+		return val.(int)
+	}
+
+	bestSpecificity, level := -1, -1
+
+	for pattern, val := range permMap {
+		if !IsPermissionPattern(pattern) || !MatchPermissionKey(pattern, key) {
+			continue
+		}
+
+		specificity := len(pattern) - strings.Count(pattern, PermissionWildcard)
+
+		if specificity > bestSpecificity ||
+			(specificity == bestSpecificity && val.(int) > level) {
+
+			bestSpecificity, level = specificity, val.(int)
+		}
+	}
+
+	return level
+}
+
+/*
+   Checks if the user is allowed to hand out the given level on key,
+   which may itself be a pattern. The user must be able to modify
+   everything the key covers, at no less than the given level.
+*/
+func (this *User) CanGrantAuthLevel(field, key string, level int) bool {
+	ownLevel := this.GetAuthLevel(field, key)
+	if ownLevel < ModifyAuthLevel || level > ownLevel {
+		return false
+	}
+
+	permMap, _ := this.Permissions[field].(map[string]interface{})
+
+	// Any of the user's grants which covers a key the pattern also
+	// covers may lower the user's level there
+	for ownKey, val := range permMap {
+		if val.(int) < level && PermissionKeysOverlap(key, ownKey) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+   Checks if some key is matched by both a and b, either of which may
+   be a pattern. e.g. "pay*" and "*-secret" overlap on "payments-secret".
+*/
+func PermissionKeysOverlap(a, b string) bool {
+	seen := make(map[[2]int]bool)
+
+	var overlap func(i, j int) bool
+	overlap = func(i, j int) bool {
+		if i == len(a) && j == len(b) {
+			return true
+		}
+
+		state := [2]int{i, j}
+		if seen[state] {
+			return false
+		}
+		seen[state] = true
+
+		// A wildcard matches nothing more, or takes the other's next character
+		if i < len(a) && a[i] == '*' {
+			return overlap(i+1, j) || (j < len(b) && overlap(i, j+1))
+		}
+
+		if j < len(b) && b[j] == '*' {
+			return overlap(i, j+1) || (i < len(a) && overlap(i+1, j))
+		}
+
+		return i < len(a) && j < len(b) && a[i] == b[j] && overlap(i+1, j+1)
+	}
+
+	return overlap(0, 0)
+}
+
+func IsPermissionPattern(key string) bool {
+	return strings.Contains(key, PermissionWildcard)
+}
+
+func ValidatePermissionKey(key string) error {
+	if key == "" || strings.Contains(key, PermissionWildcard+PermissionWildcard) {
+		return InvalidPermissionKeyError
+	}
+
+	return nil
+}
+
+/*
+   Matches key against a glob pattern where '*' matches any sequence
+   of characters, including none. A pattern without wildcards only
+   matches itself.
+*/
+func MatchPermissionKey(pattern, key string) bool {
+	parts := strings.Split(pattern, PermissionWildcard)
+
+	if len(parts) == 1 {
+		return pattern == key
+	}
+
+	first, last := parts[0], parts[len(parts)-1]
+
+	if !strings.HasPrefix(key, first) {
+		return false
 	}
+	key = key[len(first):]
 
-	return val.(int)
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(key, part)
+		if idx < 0 {
+			return false
+		}
+		key = key[idx+len(part):]
+	}
+
+	return strings.HasSuffix(key, last)
 }
 
 func (this *User) SetAuthLevel(field, key string, level int) {
 	fieldInter, ok := this.Permissions[field]
 	if !ok {
-		return
+		// Users created before a permission type existed lack it
+		if _, known := NewPermission()[field]; !known {
+			return
+		}
 	}
 
 	fieldVal, _ := fieldInter.(map[string]interface{})
@@ -101,11 +243,7 @@ func (this *User) CanModifyBeacon(beaconAddress string) bool {
 
 func SetUserBeaconAuthLevel(user *User, beacon string, level int) error {
 	user.SetAuthLevel("Beacons", beacon, level)
-
-	to := map[string]interface{}{"Permissions": user.Permissions}
-	where := map[string]interface{}{"Email": user.Email}
-
-	return users.Update(to, where)
+	return saveUserPermissions(user)
 }
 
 func (this *User) CanAccessApplication(name string) bool {
@@ -120,9 +258,111 @@ func (this *User) CanModifyApplication(name string) bool {
 
 func SetUserApplicationAuthLevel(user *User, name string, level int) error {
 	user.SetAuthLevel("Applications", name, level)
+	return saveUserPermissions(user)
+}
+
+/*
+   Host permissions cover Docker hosts which are proxied to directly,
+   rather than through a beacon.
+*/
+func (this *User) CanAccessHost(host string) bool {
+	level := this.GetAuthLevel("Hosts", host)
+	return level >= AccessAuthLevel
+}
+
+func (this *User) CanModifyHost(host string) bool {
+	level := this.GetAuthLevel("Hosts", host)
+	return level >= ModifyAuthLevel
+}
+
+func SetUserHostAuthLevel(user *User, host string, level int) error {
+	user.SetAuthLevel("Hosts", host, level)
+	return saveUserPermissions(user)
+}
+
+// Registry permissions are keyed by the name of a registry credential
+func (this *User) CanAccessRegistry(name string) bool {
+	level := this.GetAuthLevel("Registries", name)
+	return level >= AccessAuthLevel
+}
+
+func (this *User) CanModifyRegistry(name string) bool {
+	level := this.GetAuthLevel("Registries", name)
+	return level >= ModifyAuthLevel
+}
 
+func SetUserRegistryAuthLevel(user *User, name string, level int) error {
+	user.SetAuthLevel("Registries", name, level)
+	return saveUserPermissions(user)
+}
+
+/*
+   Removes the exact key (not patterns matching it) from the given
+   permission field of every user and service account. Used when the
+   thing the key refers to no longer exists.
+*/
+func RemovePermissionFromAll(field, key string) error {
+	holders := make([]*User, 0)
+
+	userRows, err := users.Select([]string{"Email", "Permissions"}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for userRows.Next() {
+		user := &User{}
+		if userRows.Scan(user) == nil {
+			holders = append(holders, user)
+		}
+	}
+
+	serviceRows, err := services.Select([]string{"Name", "Permissions"}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for serviceRows.Next() {
+		service := &ServiceAccount{}
+		if serviceRows.Scan(service) == nil {
+			holders = append(holders, service.asUser())
+		}
+	}
+
+	for _, user := range holders {
+		permMap, ok := user.Permissions[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if _, ok := permMap[key]; !ok {
+			continue
+		}
+
+		delete(permMap, key)
+
+		err = saveUserPermissions(user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+   Persists the permissions of the given user. Service accounts are
+   presented as Users, so fall back to the service account table
+   when no user has the given email.
+*/
+func saveUserPermissions(user *User) error {
 	to := map[string]interface{}{"Permissions": user.Permissions}
 	where := map[string]interface{}{"Email": user.Email}
 
-	return users.Update(to, where)
+	err := users.Update(to, where)
+	if err == databases.NoUpdateError {
+		where = map[string]interface{}{"Name": user.Email}
+		err = services.Update(to, where)
+	}
+
+	return err
 }
@@ -25,6 +25,12 @@ func Test_NewPermission(t *testing.T) {
 
 	_, beaconOK := permissions["Beacons"]
 	assert.True(t, beaconOK, "NewPermission should have 'Beacons'")
+
+	_, hostOK := permissions["Hosts"]
+	assert.True(t, hostOK, "NewPermission should have 'Hosts'")
+
+	_, registryOK := permissions["Registries"]
+	assert.True(t, registryOK, "NewPermission should have 'Registries'")
 }
 
 func Test_GetAuthLevel(t *testing.T) {
@@ -64,6 +70,39 @@ func Test_SetAuthLevel(t *testing.T) {
 
 	newPerms := user.Permissions["NEW TYPE"].(map[string]interface{})
 	assert.Equal(t, 1, newPerms["KEY"].(int))
+
+	// Known types missing from older users are filled in
+	delete(user.Permissions, "Hosts")
+	user.SetAuthLevel("Hosts", "HOST", 0)
+
+	hostPerms := user.Permissions["Hosts"].(map[string]interface{})
+	assert.Equal(t, 0, hostPerms["HOST"].(int))
+}
+
+func Test_CanAccessHost(t *testing.T) {
+	user := &User{Permissions: NewPermission()}
+
+	assert.False(t, user.CanAccessHost("HOST"))
+
+	user.SetAuthLevel("Hosts", "HOST", AccessAuthLevel)
+	assert.True(t, user.CanAccessHost("HOST"))
+	assert.False(t, user.CanModifyHost("HOST"))
+
+	user.SetAuthLevel("Hosts", "HOST", ModifyAuthLevel)
+	assert.True(t, user.CanModifyHost("HOST"))
+}
+
+func Test_CanAccessRegistry(t *testing.T) {
+	user := &User{Permissions: NewPermission()}
+
+	assert.False(t, user.CanAccessRegistry("CRED"))
+
+	user.SetAuthLevel("Registries", "team-*", AccessAuthLevel)
+	assert.True(t, user.CanAccessRegistry("team-pull"))
+	assert.False(t, user.CanModifyRegistry("team-pull"))
+
+	user.SetAuthLevel("Registries", "team-pull", ModifyAuthLevel)
+	assert.True(t, user.CanModifyRegistry("team-pull"))
 }
 
 func Test_CanViewUser(t *testing.T) {
@@ -163,3 +202,165 @@ func Test_SetUserResourceAuthLevel(t *testing.T) {
 		assert.Equal(t, keyPerms, user.Permissions[res])
 	}
 }
+
+func Test_MatchPermissionKey(t *testing.T) {
+	matches := [][2]string{
+		{"payments-*", "payments-api"},
+		{"payments-*", "payments-"},
+		{"*", "anything"},
+		{"*.us-east.*", "beacon.us-east.example.com"},
+		{"10.0.*:2375", "10.0.1.5:2375"},
+		{"exact", "exact"},
+	}
+
+	for _, pair := range matches {
+		assert.True(t, MatchPermissionKey(pair[0], pair[1]), pair[0]+" should match "+pair[1])
+	}
+
+	misses := [][2]string{
+		{"payments-*", "billing-api"},
+		{"payments-*", "payment"},
+		{"*.us-east.*", "beacon.us-west.example.com"},
+		{"10.0.*:2375", "10.0.1.5:2376"},
+		{"exact", "exactly"},
+	}
+
+	for _, pair := range misses {
+		assert.False(t, MatchPermissionKey(pair[0], pair[1]), pair[0]+" should not match "+pair[1])
+	}
+}
+
+func Test_GetAuthLevel_Patterns(t *testing.T) {
+	user := &User{}
+	user.Permissions = NewPermission()
+
+	user.Permissions["Applications"] = map[string]interface{}{
+		"*":                 AccessAuthLevel,
+		"payments-*":        OwnerAuthLevel,
+		"payments-ledger-*": ModifyAuthLevel,
+		"payments-ledger-1": AccessAuthLevel,
+	}
+
+	assert.Equal(t, AccessAuthLevel, user.GetAuthLevel("Applications", "billing"))
+	assert.Equal(t, OwnerAuthLevel, user.GetAuthLevel("Applications", "payments-api"))
+	assert.Equal(t, ModifyAuthLevel, user.GetAuthLevel("Applications", "payments-ledger-2"))
+
+	// Exact grants take precedence over every pattern
+	assert.Equal(t, AccessAuthLevel, user.GetAuthLevel("Applications", "payments-ledger-1"))
+
+	assert.True(t, user.CanModifyApplication("payments-api"))
+	assert.False(t, user.CanModifyApplication("billing"))
+	assert.Equal(t, -1, user.GetAuthLevel("Beacons", "payments-api"))
+}
+
+func Test_GetAuthLevel_PatternTie(t *testing.T) {
+	user := &User{}
+	user.Permissions = NewPermission()
+
+	user.Permissions["Beacons"] = map[string]interface{}{
+		"east-*": AccessAuthLevel,
+		"*-prod": ModifyAuthLevel,
+	}
+
+	assert.Equal(t, ModifyAuthLevel, user.GetAuthLevel("Beacons", "east-prod"))
+	assert.Equal(t, AccessAuthLevel, user.GetAuthLevel("Beacons", "east-test"))
+}
+
+func Test_CanGrantAuthLevel(t *testing.T) {
+	user := &User{}
+	user.Permissions = NewPermission()
+
+	user.Permissions["Beacons"] = map[string]interface{}{
+		"payments-*":      OwnerAuthLevel,
+		"payments-secret": AccessAuthLevel,
+		"billing":         ModifyAuthLevel,
+	}
+
+	assert.True(t, user.CanGrantAuthLevel("Beacons", "payments-api", OwnerAuthLevel))
+	assert.True(t, user.CanGrantAuthLevel("Beacons", "payments-api-*", ModifyAuthLevel))
+	assert.True(t, user.CanGrantAuthLevel("Beacons", "payments-*", AccessAuthLevel))
+	assert.True(t, user.CanGrantAuthLevel("Beacons", "billing", AccessAuthLevel))
+
+	// Would cover payments-secret, which the user can't modify
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "payments-*", ModifyAuthLevel))
+
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "payments-secret", AccessAuthLevel))
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "billing-*", AccessAuthLevel))
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "*", AccessAuthLevel))
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "billing", OwnerAuthLevel))
+
+	// Patterns which only overlap the lower grant still cover its keys
+	user.Permissions["Beacons"] = map[string]interface{}{
+		"pay*":     OwnerAuthLevel,
+		"*-secret": AccessAuthLevel,
+	}
+
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "payments-*", OwnerAuthLevel))
+	assert.False(t, user.CanGrantAuthLevel("Beacons", "pay*", ModifyAuthLevel))
+	assert.True(t, user.CanGrantAuthLevel("Beacons", "pay*-api", OwnerAuthLevel))
+	assert.True(t, user.CanGrantAuthLevel("Beacons", "payments-*", AccessAuthLevel))
+}
+
+func Test_PermissionKeysOverlap(t *testing.T) {
+	overlapping := [][2]string{
+		{"pay*", "*-secret"},
+		{"payments-*", "*-secret"},
+		{"*", "anything"},
+		{"a*c", "ab*"},
+		{"exact", "exact"},
+		{"*x*", "*y*"},
+	}
+
+	for _, keys := range overlapping {
+		assert.True(t, PermissionKeysOverlap(keys[0], keys[1]), "%v", keys)
+		assert.True(t, PermissionKeysOverlap(keys[1], keys[0]), "%v", keys)
+	}
+
+	disjoint := [][2]string{
+		{"payments-api-*", "payments-secret"},
+		{"a*", "b*"},
+		{"*a", "*b"},
+		{"exact", "other"},
+		{"ab*", "a"},
+	}
+
+	for _, keys := range disjoint {
+		assert.False(t, PermissionKeysOverlap(keys[0], keys[1]), "%v", keys)
+		assert.False(t, PermissionKeysOverlap(keys[1], keys[0]), "%v", keys)
+	}
+}
+
+func Test_ValidatePermissionKey(t *testing.T) {
+	assert.Nil(t, ValidatePermissionKey("payments-*"))
+	assert.Nil(t, ValidatePermissionKey("*"))
+	assert.Nil(t, ValidatePermissionKey("exact"))
+
+	assert.Equal(t, InvalidPermissionKeyError, ValidatePermissionKey(""))
+	assert.Equal(t, InvalidPermissionKeyError, ValidatePermissionKey("payments-**"))
+}
+
+func Test_RemovePermissionFromAll(t *testing.T) {
+	setup()
+	defer teardown()
+
+	CreateUser("USER", "", "")
+	user, _ := GetUser("USER")
+	SetUserBeaconAuthLevel(user, "GONE", OwnerAuthLevel)
+	SetUserBeaconAuthLevel(user, "GONE-*", AccessAuthLevel)
+	SetUserApplicationAuthLevel(user, "GONE", OwnerAuthLevel)
+
+	perms := NewPermission()
+	perms["Beacons"] = map[string]interface{}{"GONE": ModifyAuthLevel}
+	CreateServiceAccount("ci-bot", "USER", perms)
+
+	err := RemovePermissionFromAll("Beacons", "GONE")
+	assert.Nil(t, err)
+
+	user, _ = GetUser("USER")
+	assert.Equal(t, -1, user.GetAuthLevel("Beacons", "GONE"))
+	assert.Equal(t, AccessAuthLevel, user.GetAuthLevel("Beacons", "GONE-1"))
+	assert.Equal(t, OwnerAuthLevel, user.GetAuthLevel("Applications", "GONE"))
+
+	service, _ := GetServiceAccount("ci-bot")
+	assert.Equal(t, -1, service.asUser().GetAuthLevel("Beacons", "GONE"))
+}
@@ -0,0 +1,423 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+const (
+	HEADER_SERVICE_NAME = "Service-Account"
+	HEADER_SERVICE_KEY  = "Service-Key"
+)
+
+var (
+	ServiceAccessError     = errors.New("Service account does not exist or current permission too low")
+	ServiceNameError       = errors.New("Service account names may only contain letters, numbers, '.', '_' and '-'")
+	ServiceExistsError     = errors.New("Service account or user with that name already exists")
+	ServicePermissionError = errors.New("Cannot grant a service account more than your own permissions")
+)
+
+var validServiceName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+/*
+   A principal used by automation. Service accounts cannot log in
+   through /login, but authenticate every request with their name
+   and key headers and carry their own Permissions.
+*/
+type ServiceAccount struct {
+	Name        string
+	Salt        string
+	Key         string
+	Creator     string
+	Permissions Permission
+}
+
+var services databases.TableInterface
+
+var serviceSchema = databases.Schema{
+	"Name":        "text UNIQUE PRIMARY KEY",
+	"Salt":        "text",
+	"Key":         "text",
+	"Creator":     "text",
+	"Permissions": "json",
+}
+
+func (this *ServiceAccount) convertPermissionsFromDB() {
+	user := User{Permissions: this.Permissions}
+	user.convertPermissionsFromDB()
+}
+
+/*
+   Returns a User view of the service account so that all of the
+   existing permission checks can be applied to it. The account name
+   is used in place of the email, which is what shows up as the
+   Creator of anything the account does.
+*/
+func (this *ServiceAccount) asUser() *User {
+	return &User{
+		Email:       this.Name,
+		AuthLevel:   DefaultAuthLevel,
+		Permissions: this.Permissions,
+	}
+}
+
+func (this *User) CanModifyService(service *ServiceAccount) bool {
+	return this.Email == service.Creator ||
+		this.AuthLevel > DefaultAuthLevel
+}
+
+func GenerateServiceKey() string {
+	return GenerateSalt() + GenerateSalt()
+}
+
+func CreateServiceAccount(name, creator string, perms Permission) (string, error) {
+	if !validServiceName.MatchString(name) {
+		return "", ServiceNameError
+	}
+
+	if _, err := GetUser(name); err == nil {
+		return "", ServiceExistsError
+	}
+
+	key := GenerateServiceKey()
+	salt := GenerateSalt()
+
+	entry := map[string]interface{}{
+		"Name":        name,
+		"Salt":        salt,
+		"Key":         SaltPassword(key, salt),
+		"Creator":     creator,
+		"Permissions": perms,
+	}
+
+	err := services.Insert(entry)
+	if err == databases.DuplicateKeyError {
+		err = ServiceExistsError
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func GetServiceAccount(name string) (*ServiceAccount, error) {
+	service := &ServiceAccount{}
+	where := databases.Filter{"Name": name}
+	err := services.SelectRow(nil, where, nil, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	service.convertPermissionsFromDB()
+
+	return service, nil
+}
+
+/*
+   Replaces the key of the service account with a newly generated one.
+   The old key stops working immediately.
+
+   RETURN: The new (unsalted) key on success, an error otherwise
+*/
+func RotateServiceKey(name string) (string, error) {
+	key := GenerateServiceKey()
+	salt := GenerateSalt()
+
+	to := map[string]interface{}{
+		"Salt": salt,
+		"Key":  SaltPassword(key, salt),
+	}
+	where := databases.Filter{"Name": name}
+
+	err := services.Update(to, where)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func RemoveServiceAccount(name string) error {
+	where := databases.Filter{"Name": name}
+	return services.Delete(where)
+}
+
+/*
+   Checks the service account headers of the request.
+
+   RETURN: The service account on success, nil if the headers
+           are missing or the credentials are incorrect
+*/
+func authenticateService(r *http.Request) *ServiceAccount {
+	name := r.Header.Get(HEADER_SERVICE_NAME)
+	key := r.Header.Get(HEADER_SERVICE_KEY)
+
+	if name == "" || key == "" {
+		return nil
+	}
+
+	service, err := GetServiceAccount(name)
+	if err != nil {
+		return nil
+	}
+
+	if SaltPassword(key, service.Salt) != service.Key {
+		return nil
+	}
+
+	return service
+}
+
+/*
+   Stores the service account as the current principal for the
+   duration of the request. The session is never saved so no
+   cookie is handed back to the caller.
+*/
+func setServiceSession(r *http.Request, service *ServiceAccount) {
+	session.SetValue(r, "auth", "logged_in", true)
+	session.SetValue(r, "auth", "email", service.Name)
+	session.SetValue(r, "auth", "service", true)
+}
+
+func isServiceSession(r *http.Request) bool {
+	return session.GetValueOrDefault(r, "auth", "service", false).(bool)
+}
+
+func parseServicePermissions(curUser *User, requested map[string]map[string]int) (Permission, error) {
+	perms := NewPermission()
+	user := User{Permissions: perms}
+
+	for field, keys := range requested {
+		if _, ok := perms[field]; !ok {
+			return nil, ServicePermissionError
+		}
+
+		for key, level := range keys {
+			if err := ValidatePermissionKey(key); err != nil {
+				return nil, err
+			}
+
+			if !curUser.CanGrantAuthLevel(field, key, level) {
+				return nil, ServicePermissionError
+			}
+
+			user.SetAuthLevel(field, key, level)
+		}
+	}
+
+	return perms, nil
+}
+
+func writeServiceKey(w http.ResponseWriter, name, key string) {
+	keyJson, _ := json.Marshal(map[string]string{
+		"Name": name,
+		"Key":  key,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(keyJson))
+}
+
+func getModifiableService(w http.ResponseWriter, r *http.Request) *ServiceAccount {
+	service, err := GetServiceAccount(mux.Vars(r)["Name"])
+	currentUser := GetCurrentUser(r)
+
+	if err != nil || !currentUser.CanModifyService(service) {
+		writeResponse(w, http.StatusNotFound, ServiceAccessError)
+		return nil
+	}
+
+	return service
+}
+
+func handleListServices(w http.ResponseWriter, r *http.Request) {
+	currentUser := GetCurrentUser(r)
+
+	scanner, err := services.Select([]string{"Name", "Creator"}, nil, nil)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]string, 0)
+	var service ServiceAccount
+
+	for scanner.Next() {
+		err = scanner.Scan(&service)
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if currentUser.CanModifyService(&service) {
+			list = append(list, service.Name)
+		}
+	}
+
+	listJson, _ := json.Marshal(list)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(listJson))
+}
+
+func handleGetService(w http.ResponseWriter, r *http.Request) {
+	service := getModifiableService(w, r)
+	if service == nil {
+		return
+	}
+
+	serviceInfo := struct {
+		Name        string
+		Creator     string
+		Permissions Permission
+	}{
+		service.Name, service.Creator, service.Permissions,
+	}
+
+	serviceJson, _ := json.Marshal(serviceInfo)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(serviceJson))
+}
+
+func handleCreateService(w http.ResponseWriter, r *http.Request) {
+	currentUser := GetCurrentUser(r)
+
+	if currentUser.AuthLevel < CreateUserAuthLevel {
+		writeResponse(w, http.StatusForbidden, ServiceAccessError)
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var serviceInfo struct {
+		Name        string
+		Permissions map[string]map[string]int
+	}
+
+	err = json.Unmarshal(reqBody, &serviceInfo)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	perms, err := parseServicePermissions(currentUser, serviceInfo.Permissions)
+	if err == InvalidPermissionKeyError {
+		writeResponse(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeResponse(w, http.StatusForbidden, err)
+		return
+	}
+
+	key, err := CreateServiceAccount(serviceInfo.Name, currentUser.Email, perms)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeServiceKey(w, serviceInfo.Name, key)
+}
+
+func handleUpdateService(w http.ResponseWriter, r *http.Request) {
+	service := getModifiableService(w, r)
+	if service == nil {
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var update struct {
+		Permissions map[string]map[string]int
+	}
+
+	err = json.Unmarshal(reqBody, &update)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	perms, err := parseServicePermissions(GetCurrentUser(r), update.Permissions)
+	if err == InvalidPermissionKeyError {
+		writeResponse(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeResponse(w, http.StatusForbidden, err)
+		return
+	}
+
+	to := map[string]interface{}{"Permissions": perms}
+	where := databases.Filter{"Name": service.Name}
+
+	err = services.Update(to, where)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleRotateServiceKey(w http.ResponseWriter, r *http.Request) {
+	service := getModifiableService(w, r)
+	if service == nil {
+		return
+	}
+
+	key, err := RotateServiceKey(service.Name)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeServiceKey(w, service.Name, key)
+}
+
+func handleDeleteService(w http.ResponseWriter, r *http.Request) {
+	service := getModifiableService(w, r)
+	if service == nil {
+		return
+	}
+
+	err := RemoveServiceAccount(service.Name)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
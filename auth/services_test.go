@@ -0,0 +1,279 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"testing"
+
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func Test_CreateServiceAccount(t *testing.T) {
+	setup()
+	defer teardown()
+
+	perms := NewPermission()
+	perms["Beacons"] = map[string]interface{}{"BEACON": ModifyAuthLevel}
+
+	key, err := CreateServiceAccount("ci-bot", "ADMIN", perms)
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", key)
+
+	service, err := GetServiceAccount("ci-bot")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ADMIN", service.Creator)
+	assert.Equal(t, perms, service.Permissions)
+	assert.Equal(t, SaltPassword(key, service.Salt), service.Key)
+}
+
+func Test_CreateServiceAccount_Invalid(t *testing.T) {
+	setup()
+	defer teardown()
+
+	CreateUser("USER", "", "")
+
+	_, err := CreateServiceAccount("bot@example.com", "ADMIN", NewPermission())
+	assert.Equal(t, ServiceNameError, err)
+
+	_, err = CreateServiceAccount("USER", "ADMIN", NewPermission())
+	assert.Equal(t, ServiceExistsError, err)
+
+	CreateServiceAccount("ci-bot", "ADMIN", NewPermission())
+
+	_, err = CreateServiceAccount("ci-bot", "ADMIN", NewPermission())
+	assert.NotNil(t, err)
+}
+
+func Test_RotateServiceKey(t *testing.T) {
+	setup()
+	defer teardown()
+
+	oldKey, _ := CreateServiceAccount("ci-bot", "ADMIN", NewPermission())
+	newKey, err := RotateServiceKey("ci-bot")
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, oldKey, newKey)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set(HEADER_SERVICE_NAME, "ci-bot")
+
+	r.Header.Set(HEADER_SERVICE_KEY, oldKey)
+	assert.Nil(t, authenticateService(r))
+
+	r.Header.Set(HEADER_SERVICE_KEY, newKey)
+	assert.NotNil(t, authenticateService(r))
+}
+
+func Test_MiddlewareService(t *testing.T) {
+	setup()
+	defer teardown()
+
+	m := mux.NewRouter().PathPrefix("/api").Subrouter()
+	Handle(m)
+	r := AuthMiddleware(m, nil)
+
+	key, _ := CreateServiceAccount("ci-bot", "ADMIN", NewPermission())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/users/list", nil)
+	req.Header.Set(HEADER_SERVICE_NAME, "ci-bot")
+	req.Header.Set(HEADER_SERVICE_KEY, key)
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Set-Cookie"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/users/list", nil)
+	req.Header.Set(HEADER_SERVICE_NAME, "ci-bot")
+	req.Header.Set(HEADER_SERVICE_KEY, "WRONG")
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 401, w.Code)
+}
+
+func Test_LoginService(t *testing.T) {
+	setup()
+	defer teardown()
+
+	r := mux.NewRouter()
+	Handle(r)
+
+	key, _ := CreateServiceAccount("ci-bot", "ADMIN", NewPermission())
+
+	form := LoginForm{"ci-bot", key}
+	body, _ := json.Marshal(form)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBuffer(body))
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 401, w.Code)
+}
+
+func Test_GetCurrentUser_Service(t *testing.T) {
+	setup()
+	defer teardown()
+
+	perms := NewPermission()
+	perms["Applications"] = map[string]interface{}{"APP": OwnerAuthLevel}
+
+	CreateServiceAccount("ci-bot", "ADMIN", perms)
+	service, _ := GetServiceAccount("ci-bot")
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	setServiceSession(r, service)
+
+	user := GetCurrentUser(r)
+
+	assert.Equal(t, "ci-bot", user.Email)
+	assert.Equal(t, DefaultAuthLevel, user.AuthLevel)
+	assert.True(t, user.CanModifyApplication("APP"))
+
+	SetUserBeaconAuthLevel(user, "BEACON", OwnerAuthLevel)
+
+	service, _ = GetServiceAccount("ci-bot")
+	assert.Equal(t, OwnerAuthLevel, service.asUser().GetAuthLevel("Beacons", "BEACON"))
+}
+
+func Test_ParseServicePermissions(t *testing.T) {
+	curPerms := NewPermission()
+	curPerms["Beacons"] = map[string]interface{}{
+		"MODIFY": ModifyAuthLevel,
+		"ACCESS": AccessAuthLevel,
+	}
+
+	curUser := &User{Permissions: curPerms}
+
+	perms, err := parseServicePermissions(curUser, map[string]map[string]int{
+		"Beacons": {"MODIFY": AccessAuthLevel},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, AccessAuthLevel, perms["Beacons"].(map[string]interface{})["MODIFY"])
+
+	invalid := []map[string]map[string]int{
+		{"Beacons": {"MODIFY": OwnerAuthLevel}},
+		{"Beacons": {"ACCESS": AccessAuthLevel}},
+		{"Beacons": {"UNKNOWN": AccessAuthLevel}},
+		{"BAD TYPE": {"MODIFY": AccessAuthLevel}},
+	}
+
+	for _, req := range invalid {
+		perms, err = parseServicePermissions(curUser, req)
+
+		assert.Equal(t, ServicePermissionError, err)
+		assert.Nil(t, perms)
+	}
+}
+
+func Test_HandleCreateService(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addUsers(User{Email: "ADMIN", AuthLevel: 1, Permissions: NewPermission()})
+
+	addJSON, _ := json.Marshal(map[string]interface{}{"Name": "ci-bot"})
+
+	r, _ := http.NewRequest("POST", "/", bytes.NewBuffer(addJSON))
+	session.SetValue(r, "auth", "email", "ADMIN")
+
+	w := handleAndServe("/", handleCreateService, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	service, err := GetServiceAccount("ci-bot")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ci-bot", resp["Name"])
+	assert.Equal(t, SaltPassword(resp["Key"], service.Salt), service.Key)
+}
+
+func Test_HandleCreateService_Unauthorized(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addUsers(User{Email: "USER", AuthLevel: 0})
+
+	addJSON, _ := json.Marshal(map[string]interface{}{"Name": "ci-bot"})
+
+	r, _ := http.NewRequest("POST", "/", bytes.NewBuffer(addJSON))
+	session.SetValue(r, "auth", "email", "USER")
+
+	w := handleAndServe("/", handleCreateService, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func Test_HandleRotateServiceKey(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addUsers(
+		User{Email: "CREATOR", AuthLevel: 0},
+		User{Email: "OTHER", AuthLevel: 0},
+	)
+
+	CreateServiceAccount("ci-bot", "CREATOR", NewPermission())
+	before, _ := GetServiceAccount("ci-bot")
+
+	r, _ := http.NewRequest("PUT", "/ci-bot", nil)
+	session.SetValue(r, "auth", "email", "OTHER")
+
+	w := handleAndServe("/{Name}", handleRotateServiceKey, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	r, _ = http.NewRequest("PUT", "/ci-bot", nil)
+	session.SetValue(r, "auth", "email", "CREATOR")
+
+	w = handleAndServe("/{Name}", handleRotateServiceKey, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	after, _ := GetServiceAccount("ci-bot")
+	assert.NotEqual(t, before.Key, after.Key)
+}
+
+func Test_HandleDeleteService(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addUsers(User{Email: "CREATOR", AuthLevel: 0})
+	CreateServiceAccount("ci-bot", "CREATOR", NewPermission())
+
+	r, _ := http.NewRequest("DELETE", "/ci-bot", nil)
+	session.SetValue(r, "auth", "email", "CREATOR")
+
+	w := handleAndServe("/{Name}", handleDeleteService, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := GetServiceAccount("ci-bot")
+	assert.NotNil(t, err)
+}
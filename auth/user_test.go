@@ -629,3 +629,61 @@ func Test_HandleCreateUser_Exists(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func Test_ParseUserUpdateRequest_Beacons_Pattern(t *testing.T) {
+	curPerms := NewPermission()
+	curPerms["Beacons"] = map[string]interface{}{
+		"us-east-*": OwnerAuthLevel,
+	}
+
+	curUser := &User{Permissions: curPerms}
+
+	modUser := &User{Permissions: NewPermission()}
+	updateStr := fmt.Sprintf(`{"Beacons" : {"us-east-*" : %d}}`, ModifyAuthLevel)
+
+	vals, code := parseUserUpdateRequest(curUser, modUser, []byte(updateStr))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ModifyAuthLevel,
+		vals["Permissions"].(Permission)["Beacons"].(map[string]interface{})["us-east-*"])
+
+	modUser = &User{Permissions: NewPermission()}
+	updateStr = fmt.Sprintf(`{"Beacons" : {"us-*" : %d}}`, AccessAuthLevel)
+
+	vals, code = parseUserUpdateRequest(curUser, modUser, []byte(updateStr))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Nil(t, vals)
+
+	modUser = &User{Permissions: NewPermission()}
+	updateStr = fmt.Sprintf(`{"Beacons" : {"us-east-**" : %d}}`, AccessAuthLevel)
+
+	vals, code = parseUserUpdateRequest(curUser, modUser, []byte(updateStr))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Nil(t, vals)
+}
+
+func Test_ParseUserUpdateRequest_Hosts(t *testing.T) {
+	curPerms := NewPermission()
+	curPerms["Hosts"] = map[string]interface{}{
+		"docker-*.example.com": OwnerAuthLevel,
+	}
+
+	// Users saved before hosts existed have no "Hosts" permissions
+	modPerms := Permission{"Beacons": map[string]interface{}{}}
+
+	curUser := &User{Permissions: curPerms}
+	modUser := &User{Permissions: modPerms}
+
+	update := []byte(`{"Hosts" : {"docker-1.example.com" : 1}}`)
+
+	vals, code := parseUserUpdateRequest(curUser, modUser, update)
+	assert.Equal(t, http.StatusOK, code)
+
+	perms := vals["Permissions"].(Permission)
+	assert.Equal(t, map[string]interface{}{"docker-1.example.com": ModifyAuthLevel}, perms["Hosts"])
+
+	update = []byte(`{"Hosts" : {"10.0.0.1:2375" : 0}}`)
+
+	vals, code = parseUserUpdateRequest(curUser, modUser, update)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Nil(t, vals)
+}
@@ -92,6 +92,15 @@ func GetUser(email string) (*User, error) {
 
 func GetCurrentUser(r *http.Request) *User {
 	email := session.GetValueOrDefault(r, "auth", "email", "").(string)
+
+	if isServiceSession(r) {
+		service, err := GetServiceAccount(email)
+		if err != nil {
+			return nil
+		}
+		return service.asUser()
+	}
+
 	user, _ := GetUser(email)
 	return user
 }
@@ -225,6 +234,11 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := GetServiceAccount(userInfo.Email); err == nil {
+		writeResponse(w, http.StatusBadRequest, ServiceExistsError)
+		return
+	}
+
 	salt := GenerateSalt()
 	saltedPassword := SaltPassword(userInfo.Password, salt)
 
@@ -266,9 +280,11 @@ func getAllUsers(currentUser *User) ([]string, error) {
 func parseUserUpdateRequest(curUser, modUser *User, updateJSON []byte) (map[string]interface{}, int) {
 
 	updates := struct {
-		AuthLevel int            `json:omitempty`
-		Password  string         `json:omitempty`
-		Beacons   map[string]int `json:omitempty`
+		AuthLevel  int            `json:",omitempty"`
+		Password   string         `json:",omitempty"`
+		Beacons    map[string]int `json:",omitempty"`
+		Hosts      map[string]int `json:",omitempty"`
+		Registries map[string]int `json:",omitempty"`
 	}{
 		AuthLevel: modUser.AuthLevel,
 		Password:  modUser.Password,
@@ -299,14 +315,26 @@ func parseUserUpdateRequest(curUser, modUser *User, updateJSON []byte) (map[stri
 
 	updateValues["Permissions"] = modUser.Permissions
 
-	if updates.Beacons != nil {
-		for beacon, level := range updates.Beacons {
+	grants := []struct {
+		field  string
+		levels map[string]int
+	}{
+		{"Beacons", updates.Beacons},
+		{"Hosts", updates.Hosts},
+		{"Registries", updates.Registries},
+	}
+
+	for _, grant := range grants {
+		for key, level := range grant.levels {
+
+			if ValidatePermissionKey(key) != nil {
+				return nil, http.StatusBadRequest
+			}
 
-			permitted := curUser.CanModifyBeacon(beacon) &&
-				level <= curUser.GetAuthLevel("Beacons", beacon)
+			permitted := curUser.CanGrantAuthLevel(grant.field, key, level)
 
 			if permitted {
-				modUser.SetAuthLevel("Beacons", beacon, level)
+				modUser.SetAuthLevel(grant.field, key, level)
 			} else {
 				return nil, http.StatusForbidden
 			}
@@ -15,23 +15,93 @@
 package aliases
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
+	"strings"
 
 	"encoding/json"
 
 	"github.com/gorilla/mux"
 
+	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/databases"
 	"github.com/lighthouse/lighthouse/handlers"
 )
 
+var (
+	InvalidAliasError    = errors.New("aliases: alias may only contain letters, digits and . _ - : @")
+	AliasConflictError   = errors.New("aliases: alias is already used by another address")
+	UnknownAliasError    = errors.New("aliases: unknown alias")
+	AliasPermissionError = errors.New("aliases: user not permitted to modify alias")
+)
+
+// Aliases appear in Docker proxy paths, so they must not contain '/'
+var validAlias = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:@-]*$`)
+
 var aliases databases.TableInterface
 
 var schema = databases.Schema{
-	"Alias":   "text",
-	"Address": "text UNIQUE PRIMARY KEY",
+	"Alias":     "text UNIQUE",
+	"Address":   "text UNIQUE PRIMARY KEY",
+	"Name":      "text",
+	"Namespace": "text",
+}
+
+/*
+   Decides whether the user may see (modify false) or change
+   (modify true) the alias of the address. Set by the packages which
+   own the addresses, e.g. beacons, since they cannot be imported here.
+*/
+type PermissionFunc func(user *auth.User, address string, modify bool) bool
+
+// By default anyone may see aliases but only admins may change them
+func defaultPermissionFunc(user *auth.User, address string, modify bool) bool {
+	return !modify || user.AuthLevel >= auth.CreateUserAuthLevel
+}
+
+var permissionFunc PermissionFunc = defaultPermissionFunc
+
+func SetPermissionFunc(f PermissionFunc) {
+	permissionFunc = f
+}
+
+/*
+   Called with the address whose alias was added, changed or removed,
+   so that packages which keep aliases around, e.g. in a cache, can
+   drop them.
+*/
+type ChangeFunc func(address string)
+
+var changeFunc ChangeFunc = func(address string) {}
+
+func SetChangeFunc(f ChangeFunc) {
+	changeFunc = f
+}
+
+func canAccessAlias(user *auth.User, address string, modify bool) bool {
+	return user != nil && permissionFunc(user, address, modify)
+}
+
+func ValidateAlias(alias string) error {
+	if !validAlias.MatchString(alias) {
+		return InvalidAliasError
+	}
+	return nil
+}
+
+/*
+   RETURN: AliasConflictError if the alias belongs to another address
+*/
+func checkAliasConflict(alias, address string) error {
+	owner, err := GetAddressOf(alias)
+	if err == nil && owner != address {
+		return AliasConflictError
+	}
+	return nil
 }
 
 type Alias struct {
@@ -47,30 +117,104 @@ func Init(reload bool) {
 	if reload {
 		aliases.Reload()
 		LoadAliases()
+	} else if err := aliases.Migrate(); err != nil {
+		// Tables from before namespaces lack their columns
+		panic("aliases: could not migrate table: " + err.Error())
 	}
 }
 
 func AddAlias(alias, address string) error {
+	if err := checkAliasConflict(alias, address); err != nil {
+		return err
+	}
+
+	members, err := checkNamespaceRename(address, alias+NAMESPACE_DELIM)
+	if err != nil {
+		return err
+	}
+
 	entry := map[string]interface{}{
 		"Alias":   alias,
 		"Address": address,
 	}
 
-	err := aliases.Insert(entry)
+	err = aliases.Insert(entry)
+	if err != nil {
+		return err
+	}
 
-	return err
+	// Take the alias back if its namespace could not follow it
+	if err = renameNamespace(members, alias+NAMESPACE_DELIM, nil); err != nil {
+		aliases.Delete(databases.Filter{"Address": address})
+		return err
+	}
+
+	changeFunc(address)
+	return nil
 }
 
 func RemoveAlias(address string) error {
-	where := databases.Filter{"Address": address}
-	return aliases.Delete(where)
+	current, err := getAliasData(address)
+	if err == databases.NoRowsError {
+		return databases.NoUpdateError
+	} else if err != nil {
+		return err
+	}
+
+	members, err := checkNamespaceRename(address, address+NAMESPACE_DELIM)
+	if err != nil {
+		return err
+	}
+
+	err = aliases.Delete(databases.Filter{"Address": address})
+	if err != nil {
+		return err
+	}
+
+	// Restore the alias if its namespace could not follow it
+	if err = renameNamespace(members, address+NAMESPACE_DELIM, nil); err != nil {
+		aliases.Insert(map[string]interface{}{
+			"Alias":     current.Alias,
+			"Address":   current.Address,
+			"Name":      current.Name,
+			"Namespace": current.Namespace,
+		})
+		return err
+	}
+
+	changeFunc(address)
+	return nil
 }
 
 func UpdateAlias(alias, address string) error {
-	to := databases.Filter{"Alias": alias}
-	where := databases.Filter{"Address": address}
+	if err := checkAliasConflict(alias, address); err != nil {
+		return err
+	}
+
+	current, err := getAliasData(address)
+	if err == databases.NoRowsError {
+		return databases.NoUpdateError
+	} else if err != nil {
+		return err
+	}
+
+	members, err := checkNamespaceRename(address, alias+NAMESPACE_DELIM)
+	if err != nil {
+		return err
+	}
 
-	return aliases.Update(to, where)
+	// The alias and those in its namespace change together
+	change := databases.Change{
+		To:    namespacedValues(alias, current),
+		Where: databases.Filter{"Address": address},
+	}
+
+	err = renameNamespace(members, alias+NAMESPACE_DELIM, &change)
+	if err == nil {
+		changeFunc(address)
+	}
+
+	return err
 }
 
 func SetAlias(alias, address string) error {
@@ -113,6 +257,47 @@ func GetAliasOf(address string) (string, error) {
 	return val.Alias, nil
 }
 
+/*
+   RETURN: A map from every aliased address to its alias, read with a
+           single query
+*/
+func GetAllAliases() (map[string]string, error) {
+	return selectAliases(nil)
+}
+
+/*
+   RETURN: A map from each of the addresses which has an alias to its
+           alias, read with a single query
+*/
+func GetAliasesOf(addresses []string) (map[string]string, error) {
+	in := make(databases.In, len(addresses))
+	for i, address := range addresses {
+		in[i] = address
+	}
+
+	return selectAliases(databases.Filter{"Address": in})
+}
+
+func selectAliases(where databases.Filter) (map[string]string, error) {
+	cols := []string{"Alias", "Address"}
+	scanner, err := aliases.Select(cols, where, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	found := make(map[string]string)
+
+	for scanner.Next() {
+		var val Alias
+		scanner.Scan(&val)
+		found[val.Address] = val.Alias
+	}
+
+	return found, nil
+}
+
 func LoadAliases() {
 	var fileName string
 	if _, err := os.Stat("./config/aliases.json.dev"); !os.IsNotExist(err) {
@@ -135,7 +320,123 @@ func LoadAliases() {
 }
 
 func Handle(r *mux.Router) {
+	r.HandleFunc("", handleListAliases).Methods("GET")
+
+	r.HandleFunc("/resolve/{Alias}", handleResolveAlias).Methods("GET")
+
 	r.HandleFunc("/{Address:.*}", handleUpdateAlias).Methods("PUT")
+
+	r.HandleFunc("/{Address:.*}", handleRemoveAlias).Methods("DELETE")
+}
+
+func writeResponse(w http.ResponseWriter, err error) {
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+
+	case InvalidAliasError:
+		handlers.WriteError(w, http.StatusBadRequest, "aliases", err.Error())
+
+	case AliasPermissionError:
+		handlers.WriteError(w, http.StatusForbidden, "aliases", err.Error())
+
+	case UnknownAliasError, databases.NoRowsError, databases.NoUpdateError:
+		handlers.WriteError(w, http.StatusNotFound, "aliases", err.Error())
+
+	case AliasConflictError:
+		handlers.WriteError(w, http.StatusConflict, "aliases", err.Error())
+
+	default:
+		handlers.WriteError(w, http.StatusInternalServerError, "aliases", err.Error())
+	}
+}
+
+/*
+   Lists the aliases the user can see, optionally filtered by the
+   alias and address substrings given as query parameters.
+*/
+func getAliasList(user *auth.User, aliasFilter, addressFilter string) ([]Alias, error) {
+	cols := []string{"Alias", "Address"}
+	opts := databases.SelectOptions{OrderBy: []string{"Alias"}}
+
+	scanner, err := aliases.Select(cols, nil, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	list := make([]Alias, 0)
+
+	for scanner.Next() {
+		var val Alias
+		scanner.Scan(&val)
+
+		if !strings.Contains(val.Alias, aliasFilter) ||
+			!strings.Contains(val.Address, addressFilter) {
+			continue
+		}
+
+		if canAccessAlias(user, val.Address, false) {
+			list = append(list, val)
+		}
+	}
+
+	return list, nil
+}
+
+func handleListAliases(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	user := auth.GetCurrentUser(r)
+
+	list, err := getAliasList(user, query.Get("alias"), query.Get("address"))
+
+	var output []byte
+	if err == nil {
+		output, err = json.Marshal(list)
+	}
+
+	if err != nil {
+		writeResponse(w, err)
+	} else {
+		fmt.Fprint(w, string(output))
+	}
+}
+
+func handleResolveAlias(w http.ResponseWriter, r *http.Request) {
+	alias := mux.Vars(r)["Alias"]
+	user := auth.GetCurrentUser(r)
+
+	address, err := ResolveAlias(alias, r.URL.Query().Get("in"))
+	if err != nil || !canAccessAlias(user, address, false) {
+		writeResponse(w, UnknownAliasError)
+		return
+	}
+
+	output, err := json.Marshal(Alias{Alias: alias, Address: address})
+	if err != nil {
+		writeResponse(w, err)
+		return
+	}
+
+	fmt.Fprint(w, string(output))
+}
+
+func handleRemoveAlias(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["Address"]
+	user := auth.GetCurrentUser(r)
+
+	if _, err := GetAliasOf(address); err != nil {
+		writeResponse(w, UnknownAliasError)
+		return
+	}
+
+	if !canAccessAlias(user, address, true) {
+		writeResponse(w, AliasPermissionError)
+		return
+	}
+
+	writeResponse(w, RemoveAlias(address))
 }
 
 func handleUpdateAlias(w http.ResponseWriter, r *http.Request) {
@@ -165,11 +466,17 @@ func handleUpdateAlias(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if alias == "" {
+	err = ValidateAlias(alias)
+	if err != nil {
 		code = http.StatusBadRequest
 		return
 	}
 
+	if !canAccessAlias(auth.GetCurrentUser(r), address, true) {
+		code, err = http.StatusForbidden, AliasPermissionError
+		return
+	}
+
 	_, res := GetAliasOf(address)
 	if res == databases.NoRowsError {
 		err = AddAlias(alias, address)
@@ -177,6 +484,11 @@ func handleUpdateAlias(w http.ResponseWriter, r *http.Request) {
 		err = UpdateAlias(alias, address)
 	}
 
+	if err == AliasConflictError {
+		code = http.StatusConflict
+		return
+	}
+
 	if err != nil {
 		code = http.StatusInternalServerError
 		return
@@ -21,21 +21,65 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
 	"github.com/gorilla/mux"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/session"
 )
 
 func setup() (table databases.TableInterface, teardown func()) {
 	SetupTestingTable()
+	auth.SetupTestingTable()
+
 	table = aliases
-	teardown = TeardownTestingTable
+	teardown = func() {
+		TeardownTestingTable()
+		auth.TeardownTestingTable()
+	}
 	return
 }
 
+// Only lets USER see and change aliases of addresses starting with ALLOWED
+func allowUser() {
+	SetPermissionFunc(func(user *auth.User, address string, modify bool) bool {
+		return user.Email == "USER" && strings.HasPrefix(address, "ALLOWED")
+	})
+}
+
+func runHandlerTest(
+	method, endpoint string,
+	body interface{},
+	route string,
+	handler func(w http.ResponseWriter, r *http.Request),
+) *httptest.ResponseRecorder {
+
+	w := httptest.NewRecorder()
+
+	var buff *bytes.Buffer
+	if body == nil {
+		buff = bytes.NewBuffer([]byte{})
+	} else {
+		jsonBuff, _ := json.Marshal(body)
+		buff = bytes.NewBuffer(jsonBuff)
+	}
+
+	r, _ := http.NewRequest(method, endpoint, buff)
+
+	auth.CreateUser("USER", "", "")
+	session.SetValue(r, "auth", "email", "USER")
+
+	m := mux.NewRouter()
+	m.HandleFunc(route, handler)
+	m.ServeHTTP(w, r)
+
+	return w
+}
+
 func Test_AddAlias(t *testing.T) {
 	table, teardown := setup()
 	defer teardown()
@@ -54,6 +98,41 @@ func Test_AddAlias(t *testing.T) {
 	assert.Equal(t, keyAddress, real.Address)
 }
 
+func Test_GetAllAliases(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	all, err := GetAllAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{}, all)
+
+	AddAlias("ALIAS 1", "ADDRESS 1")
+	AddAlias("ALIAS 2", "ADDRESS 2")
+
+	all, err = GetAllAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"ADDRESS 1": "ALIAS 1",
+		"ADDRESS 2": "ALIAS 2",
+	}, all)
+}
+
+func Test_GetAliasesOf(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	AddAlias("ALIAS 1", "ADDRESS 1")
+	AddAlias("ALIAS 2", "ADDRESS 2")
+
+	found, err := GetAliasesOf([]string{"ADDRESS 2", "ADDRESS 3"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"ADDRESS 2": "ALIAS 2"}, found)
+
+	found, err = GetAliasesOf([]string{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{}, found)
+}
+
 func Test_UpdateAlias(t *testing.T) {
 	table, teardown := setup()
 	defer teardown()
@@ -178,22 +257,18 @@ func Test_HandleUpdateAlias_Existing(t *testing.T) {
 	table, teardown := setup()
 	defer teardown()
 
+	allowUser()
+
 	alias := map[string]interface{}{
 		"Alias":   "ALIAS_FAIL",
-		"Address": "ADDRESS",
+		"Address": "ALLOWED",
 	}
 
 	table.Insert(alias)
 
 	keyAlias := "ALIAS_PASS"
-	aliasJSON, _ := json.Marshal(keyAlias)
 
-	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("PUT", "/ADDRESS", bytes.NewBuffer(aliasJSON))
-
-	m := mux.NewRouter()
-	m.HandleFunc("/{Address:.*}", handleUpdateAlias)
-	m.ServeHTTP(w, r)
+	w := runHandlerTest("PUT", "/ALLOWED", keyAlias, "/{Address:.*}", handleUpdateAlias)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -209,15 +284,11 @@ func Test_HandleUpdateAlias_New(t *testing.T) {
 	table, teardown := setup()
 	defer teardown()
 
-	keyAlias := "ALIAS_PASS"
-	aliasJSON, _ := json.Marshal(keyAlias)
+	allowUser()
 
-	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("PUT", "/ADDRESS", bytes.NewBuffer(aliasJSON))
+	keyAlias := "ALIAS_PASS"
 
-	m := mux.NewRouter()
-	m.HandleFunc("/{Address:.*}", handleUpdateAlias)
-	m.ServeHTTP(w, r)
+	w := runHandlerTest("PUT", "/ALLOWED", keyAlias, "/{Address:.*}", handleUpdateAlias)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -229,6 +300,37 @@ func Test_HandleUpdateAlias_New(t *testing.T) {
 	assert.Equal(t, keyAlias, real.Alias)
 }
 
+func Test_HandleUpdateAlias_Forbidden(t *testing.T) {
+	table, teardown := setup()
+	defer teardown()
+
+	w := runHandlerTest("PUT", "/ADDRESS", "ALIAS", "/{Address:.*}", handleUpdateAlias)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	allowUser()
+
+	w = runHandlerTest("PUT", "/ADDRESS", "ALIAS", "/{Address:.*}", handleUpdateAlias)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var real Alias
+	err := table.SelectRow(nil, nil, nil, &real)
+	assert.Equal(t, databases.NoRowsError, err)
+}
+
+func Test_HandleUpdateAlias_Conflict(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	allowUser()
+	AddAlias("TAKEN", "ALLOWED_OTHER")
+
+	w := runHandlerTest("PUT", "/ALLOWED", "TAKEN", "/{Address:.*}", handleUpdateAlias)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = runHandlerTest("PUT", "/ALLOWED", "bad/alias", "/{Address:.*}", handleUpdateAlias)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func Test_HandleUpdateAlias_Invalid(t *testing.T) {
 	_, teardown := setup()
 	defer teardown()
@@ -261,6 +363,109 @@ func Test_HandleUpdateAlias_BadJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func Test_AliasConflict(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	assert.Nil(t, AddAlias("ALIAS", "ADDRESS 1"))
+	assert.Equal(t, AliasConflictError, AddAlias("ALIAS", "ADDRESS 2"))
+
+	assert.Nil(t, AddAlias("OTHER", "ADDRESS 2"))
+	assert.Equal(t, AliasConflictError, UpdateAlias("ALIAS", "ADDRESS 2"))
+	assert.Equal(t, AliasConflictError, SetAlias("ALIAS", "ADDRESS 3"))
+
+	// Setting an address to its current alias is not a conflict
+	assert.Nil(t, UpdateAlias("ALIAS", "ADDRESS 1"))
+
+	address, _ := GetAddressOf("ALIAS")
+	assert.Equal(t, "ADDRESS 1", address)
+}
+
+func Test_ValidateAlias(t *testing.T) {
+	valid := []string{"web", "web-1.prod", "beacon_2", "10.0.0.1:2375", "me@host"}
+	invalid := []string{"", "a/b", "/", "has space", ".hidden", "-flag", "a?b"}
+
+	for _, alias := range valid {
+		assert.Nil(t, ValidateAlias(alias), alias)
+	}
+
+	for _, alias := range invalid {
+		assert.Equal(t, InvalidAliasError, ValidateAlias(alias), alias)
+	}
+}
+
+func Test_HandleListAliases(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	allowUser()
+
+	AddAlias("web-1", "ALLOWED_1")
+	AddAlias("db-1", "ALLOWED_2")
+	AddAlias("web-2", "HIDDEN")
+
+	w := runHandlerTest("GET", "/aliases", nil, "/aliases", handleListAliases)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list []Alias
+	json.Unmarshal(w.Body.Bytes(), &list)
+
+	assert.Equal(t, []Alias{{"db-1", "ALLOWED_2"}, {"web-1", "ALLOWED_1"}}, list)
+
+	w = runHandlerTest("GET", "/aliases?alias=web", nil, "/aliases", handleListAliases)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, []Alias{{"web-1", "ALLOWED_1"}}, list)
+
+	w = runHandlerTest("GET", "/aliases?address=_2", nil, "/aliases", handleListAliases)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, []Alias{{"db-1", "ALLOWED_2"}}, list)
+}
+
+func Test_HandleResolveAlias(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	allowUser()
+
+	AddAlias("web", "ALLOWED")
+	AddAlias("secret", "HIDDEN")
+
+	w := runHandlerTest("GET", "/resolve/web", nil, "/resolve/{Alias}", handleResolveAlias)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var alias Alias
+	json.Unmarshal(w.Body.Bytes(), &alias)
+	assert.Equal(t, Alias{"web", "ALLOWED"}, alias)
+
+	w = runHandlerTest("GET", "/resolve/secret", nil, "/resolve/{Alias}", handleResolveAlias)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = runHandlerTest("GET", "/resolve/missing", nil, "/resolve/{Alias}", handleResolveAlias)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_HandleRemoveAlias(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	allowUser()
+
+	AddAlias("web", "ALLOWED")
+	AddAlias("secret", "HIDDEN")
+
+	w := runHandlerTest("DELETE", "/HIDDEN", nil, "/{Address:.*}", handleRemoveAlias)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = runHandlerTest("DELETE", "/ALLOWED", nil, "/{Address:.*}", handleRemoveAlias)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := GetAliasOf("ALLOWED")
+	assert.NotNil(t, err)
+
+	w = runHandlerTest("DELETE", "/ALLOWED", nil, "/{Address:.*}", handleRemoveAlias)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func tryHandleTest(t *testing.T, r *http.Request, m *mux.Router) {
 	defer func() { recover() }()
 
@@ -276,13 +481,16 @@ func tryHandleTest(t *testing.T, r *http.Request, m *mux.Router) {
 
 func Test_Handle(t *testing.T) {
 	r := mux.NewRouter()
-	Handle(r)
+	Handle(r.PathPrefix("/aliases").Subrouter())
 
 	routes := []struct {
 		Method   string
 		Endpoint string
 	}{
-		{"PUT", "/ADDR"},
+		{"GET", "/aliases"},
+		{"GET", "/aliases/resolve/ALIAS"},
+		{"PUT", "/aliases/ADDR"},
+		{"DELETE", "/aliases/ADDR"},
 	}
 
 	for _, route := range routes {
@@ -24,4 +24,6 @@ func SetupTestingTable() {
 
 func TeardownTestingTable() {
 	aliases = nil
+	permissionFunc = defaultPermissionFunc
+	changeFunc = func(address string) {}
 }
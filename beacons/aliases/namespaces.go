@@ -0,0 +1,206 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aliases
+
+import (
+	"strings"
+
+	"github.com/lighthouse/lighthouse/databases"
+)
+
+/*
+   An address may act as a namespace for other aliases, e.g. a beacon
+   for its instances. An alias in a namespace has a Name relative to
+   it, and its full alias is the alias of the namespace (or its address
+   if it has none) followed by NAMESPACE_DELIM and the Name. Renaming
+   the namespace renames every alias in it.
+*/
+
+const NAMESPACE_DELIM = "."
+
+type aliasData struct {
+	Alias     string
+	Address   string
+	Name      string
+	Namespace string
+}
+
+func getAliasData(address string) (aliasData, error) {
+	var data aliasData
+	where := databases.Filter{"Address": address}
+
+	err := aliases.SelectRow(nil, where, nil, &data)
+	return data, err
+}
+
+func namespacePrefix(namespace string) string {
+	parent, err := GetAliasOf(namespace)
+	if err != nil {
+		parent = namespace
+	}
+
+	return parent + NAMESPACE_DELIM
+}
+
+/*
+   Sets the alias of the address to name within the namespace, which
+   is the address of the alias' parent.
+*/
+func SetNamespacedAlias(name, address, namespace string) error {
+	alias := namespacePrefix(namespace) + name
+
+	if err := checkAliasConflict(alias, address); err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{
+		"Alias":     alias,
+		"Name":      name,
+		"Namespace": namespace,
+	}
+
+	err := aliases.Update(values, databases.Filter{"Address": address})
+	if err == databases.NoUpdateError {
+		values["Address"] = address
+		err = aliases.Insert(values)
+	}
+
+	if err == nil {
+		changeFunc(address)
+	}
+
+	return err
+}
+
+func getNamespaceMembers(namespace string) ([]aliasData, error) {
+	where := databases.Filter{"Namespace": namespace}
+
+	scanner, err := aliases.Select(nil, where, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	members := make([]aliasData, 0)
+
+	for scanner.Next() {
+		var member aliasData
+		scanner.Scan(&member)
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+/*
+   Checks that every alias in the namespace can be moved under the new
+   prefix without taking another address' alias.
+
+   RETURN: The members of the namespace to pass to renameNamespace
+*/
+func checkNamespaceRename(namespace, prefix string) ([]aliasData, error) {
+	members, err := getNamespaceMembers(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		if err := checkAliasConflict(prefix+member.Name, member.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	return members, nil
+}
+
+/*
+   Moves the members of a namespace under the new prefix in a single
+   transaction, along with the change to the namespace's own alias if
+   one is given.
+*/
+func renameNamespace(members []aliasData, prefix string, change *databases.Change) error {
+	changes := make([]databases.Change, 0, len(members)+1)
+
+	if change != nil {
+		changes = append(changes, *change)
+	}
+
+	for _, member := range members {
+		changes = append(changes, databases.Change{
+			To:    map[string]interface{}{"Alias": prefix + member.Name},
+			Where: databases.Filter{"Address": member.Address},
+		})
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := aliases.UpdateAll(changes); err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		changeFunc(member.Address)
+	}
+
+	return nil
+}
+
+/*
+   Resolves name relative to the namespace, given as either its alias
+   or its address, falling back to resolving name as a full alias.
+*/
+func ResolveAlias(name, namespace string) (string, error) {
+	if namespace != "" {
+		namespaceAddress, err := GetAddressOf(namespace)
+		if err != nil {
+			namespaceAddress = namespace
+		}
+
+		var val aliasData
+		cols := []string{"Address"}
+		where := databases.Filter{"Namespace": namespaceAddress, "Name": name}
+
+		if aliases.SelectRow(cols, where, nil, &val) == nil {
+			return val.Address, nil
+		}
+	}
+
+	return GetAddressOf(name)
+}
+
+/*
+   RETURN: The values to store for the address' new alias, keeping it
+           in its namespace only if the alias is still under it
+*/
+func namespacedValues(alias string, current aliasData) map[string]interface{} {
+	values := map[string]interface{}{"Alias": alias}
+
+	if current.Namespace == "" {
+		return values
+	}
+
+	prefix := namespacePrefix(current.Namespace)
+	if strings.HasPrefix(alias, prefix) && len(alias) > len(prefix) {
+		values["Name"] = strings.TrimPrefix(alias, prefix)
+	} else {
+		values["Name"] = ""
+		values["Namespace"] = ""
+	}
+
+	return values
+}
@@ -0,0 +1,203 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aliases
+
+import (
+	"testing"
+
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/databases"
+)
+
+func Test_SetNamespacedAlias(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	// Without an alias the namespace's address is used
+	assert.Nil(t, SetNamespacedAlias("vm", "INST", "BEACON"))
+
+	alias, _ := GetAliasOf("INST")
+	assert.Equal(t, "BEACON.vm", alias)
+
+	AddAlias("beacon", "BEACON")
+	alias, _ = GetAliasOf("INST")
+	assert.Equal(t, "beacon.vm", alias)
+
+	assert.Nil(t, SetNamespacedAlias("renamed", "INST", "BEACON"))
+	alias, _ = GetAliasOf("INST")
+	assert.Equal(t, "beacon.renamed", alias)
+}
+
+func Test_RenameNamespace(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	AddAlias("beacon", "BEACON")
+	SetNamespacedAlias("vm1", "INST 1", "BEACON")
+	SetNamespacedAlias("vm2", "INST 2", "BEACON")
+
+	assert.Nil(t, UpdateAlias("renamed", "BEACON"))
+
+	all, _ := GetAllAliases()
+	assert.Equal(t, map[string]string{
+		"BEACON": "renamed",
+		"INST 1": "renamed.vm1",
+		"INST 2": "renamed.vm2",
+	}, all)
+
+	assert.Nil(t, RemoveAlias("BEACON"))
+
+	all, _ = GetAllAliases()
+	assert.Equal(t, map[string]string{
+		"INST 1": "BEACON.vm1",
+		"INST 2": "BEACON.vm2",
+	}, all)
+
+	assert.Nil(t, AddAlias("added", "BEACON"))
+
+	all, _ = GetAllAliases()
+	assert.Equal(t, "added.vm1", all["INST 1"])
+	assert.Equal(t, "added.vm2", all["INST 2"])
+}
+
+func Test_RenameNamespace_Conflict(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	AddAlias("beacon", "BEACON")
+	SetNamespacedAlias("vm", "INST", "BEACON")
+
+	// A beacon may not take an instance's alias
+	assert.Equal(t, AliasConflictError, AddAlias("beacon.vm", "OTHER"))
+
+	// Renames which would move an instance onto a taken alias are rejected
+	AddAlias("other.vm", "OTHER")
+	assert.Equal(t, AliasConflictError, UpdateAlias("other", "BEACON"))
+
+	all, _ := GetAllAliases()
+	assert.Equal(t, "beacon", all["BEACON"])
+	assert.Equal(t, "beacon.vm", all["INST"])
+
+	// An instance may not take a beacon's alias
+	AddAlias("OTHER_BEACON.vm", "TAKEN")
+	assert.Equal(t, AliasConflictError, SetNamespacedAlias("vm", "INST 2", "OTHER_BEACON"))
+}
+
+func Test_RenameNamespace_Fails(t *testing.T) {
+	table, teardown := setup()
+	defer teardown()
+
+	AddAlias("beacon", "BEACON")
+	SetNamespacedAlias("vm", "INST", "BEACON")
+	SetNamespacedAlias("vm", "OTHER_INST", "OTHER")
+
+	mock := table.(*databases.MockTable)
+	mock.MockUpdateAll = func([]databases.Change) error { return errors.New("FAILED") }
+
+	changed := make([]string, 0)
+	SetChangeFunc(func(address string) { changed = append(changed, address) })
+
+	// Neither the namespace nor its members are renamed
+	assert.NotNil(t, UpdateAlias("renamed", "BEACON"))
+	assert.NotNil(t, RemoveAlias("BEACON"))
+	assert.NotNil(t, AddAlias("other", "OTHER"))
+
+	all, _ := GetAllAliases()
+	assert.Equal(t, map[string]string{
+		"BEACON":     "beacon",
+		"INST":       "beacon.vm",
+		"OTHER_INST": "OTHER.vm",
+	}, all)
+
+	data, _ := getAliasData("INST")
+	assert.Equal(t, aliasData{"beacon.vm", "INST", "vm", "BEACON"}, data)
+
+	assert.Equal(t, []string{}, changed)
+}
+
+func Test_UpdateAlias_Namespaced(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	AddAlias("beacon", "BEACON")
+	SetNamespacedAlias("vm", "INST", "BEACON")
+
+	// Aliases still under the namespace keep following it
+	assert.Nil(t, UpdateAlias("beacon.web", "INST"))
+	UpdateAlias("renamed", "BEACON")
+
+	alias, _ := GetAliasOf("INST")
+	assert.Equal(t, "renamed.web", alias)
+
+	// Others leave it
+	assert.Nil(t, UpdateAlias("standalone", "INST"))
+	UpdateAlias("beacon", "BEACON")
+
+	alias, _ = GetAliasOf("INST")
+	assert.Equal(t, "standalone", alias)
+}
+
+func Test_ResolveAlias(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	AddAlias("beacon", "BEACON")
+	SetNamespacedAlias("vm", "INST", "BEACON")
+	AddAlias("vm", "TOP_LEVEL")
+
+	tests := []struct {
+		Name      string
+		Namespace string
+		Address   string
+	}{
+		{"vm", "beacon", "INST"},
+		{"vm", "BEACON", "INST"},
+		{"vm", "", "TOP_LEVEL"},
+		{"beacon.vm", "", "INST"},
+		{"vm", "UNKNOWN", "TOP_LEVEL"},
+	}
+
+	for _, test := range tests {
+		address, err := ResolveAlias(test.Name, test.Namespace)
+
+		assert.Nil(t, err)
+		assert.Equal(t, test.Address, address, test.Name+" in "+test.Namespace)
+	}
+
+	_, err := ResolveAlias("missing", "beacon")
+	assert.NotNil(t, err)
+}
+
+func Test_HandleResolveAlias_Relative(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	allowUser()
+
+	AddAlias("beacon", "ALLOWED_BEACON")
+	SetNamespacedAlias("vm", "ALLOWED_INST", "ALLOWED_BEACON")
+
+	w := runHandlerTest("GET", "/resolve/vm?in=beacon", nil, "/resolve/{Alias}", handleResolveAlias)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var alias Alias
+	json.Unmarshal(w.Body.Bytes(), &alias)
+	assert.Equal(t, Alias{"vm", "ALLOWED_INST"}, alias)
+}
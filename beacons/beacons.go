@@ -23,26 +23,39 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
 	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/transport"
 )
 
 const (
 	HEADER_TOKEN_KEY     = "Token"
-	INSTANCE_ALIAS_DELIM = "."
+	INSTANCE_ALIAS_DELIM = aliases.NAMESPACE_DELIM
 )
 
 var (
 	TokenPermissionError     = errors.New("beacons: user not permitted to access token")
 	NotEnoughParametersError = errors.New("beacons: not enough or invalid parameters given")
 	DuplicateBeaconError     = errors.New("beacons: tried to add an beacon which already exists")
+	BeaconPermissionError    = errors.New("beacons: user not permitted to access beacon")
+	UnknownBeaconError       = errors.New("beacons: unknown beacon")
+	BeaconInUseError         = errors.New("beacons: beacon instances are still used by applications")
 )
 
 var beacons databases.TableInterface
 var instances databases.TableInterface
 
 var beaconSchema = databases.Schema{
-	"Address": "text UNIQUE PRIMARY KEY",
-	"Token":   "text",
+	"Address":    "text UNIQUE PRIMARY KEY",
+	"Token":      "text",
+	"UseTLS":     "boolean",
+	"CACert":     "text",
+	"ClientCert": "text",
+	"ClientKey":  "text",
+	"ServerName": "text",
+
+	"PendingToken":   "text",
+	"TokenRotatedAt": "bigint",
 }
 
 var instanceSchema = databases.Schema{
@@ -50,18 +63,38 @@ var instanceSchema = databases.Schema{
 	"Name":            "text",
 	"CanAccessDocker": "boolean",
 	"BeaconAddress":   "text",
+	"StaleSince":      "bigint",
 }
 
+/*
+   PendingToken is the next token during a rotation, see rotation.go.
+   TokenRotatedAt is the unix time the token last changed.
+*/
 type beaconData struct {
 	Address string
 	Token   string
+	transport.TLSSettings
+
+	PendingToken   string
+	TokenRotatedAt int64
 }
 
+/*
+   StaleSince is the unix time at which the instance was first missing
+   from its beacon's VM list, or 0 if the beacon still reports it.
+*/
 type instanceData struct {
 	InstanceAddress string
 	Name            string
 	CanAccessDocker bool
 	BeaconAddress   string
+	StaleSince      int64
+}
+
+type DeleteReport struct {
+	Beacon       string
+	Instances    []string
+	Applications []string
 }
 
 func Init(reload bool) {
@@ -73,11 +106,34 @@ func Init(reload bool) {
 		instances = databases.NewTable(nil, "instances", instanceSchema)
 	}
 
+	if hosts == nil {
+		hosts = databases.NewTable(nil, "docker_hosts", hostSchema)
+	}
+
+	if allowlist == nil {
+		allowlist = databases.NewTable(nil, "host_allowlist", allowlistSchema)
+	}
+
+	if labels == nil {
+		labels = databases.NewTable(nil, "instance_labels", labelSchema)
+	}
+
+	if enrollments == nil {
+		enrollments = databases.NewTable(nil, "beacon_enrollments", enrollmentSchema)
+	}
+
 	if reload {
 		beacons.Reload()
 		instances.Reload()
+		hosts.Reload()
+		allowlist.Reload()
+		labels.Reload()
+		enrollments.Reload()
 		LoadBeacons()
 	}
+
+	aliases.SetPermissionFunc(canAccessAliasOf)
+	aliases.SetChangeFunc(invalidateListingsOf)
 }
 
 func GetBeaconAddress(instance string) (string, error) {
@@ -144,11 +200,51 @@ func LoadBeacons() {
 func Handle(r *mux.Router) {
 	r.HandleFunc("/token/{Endpoint:.*}", handleUpdateBeaconToken).Methods("PUT")
 
+	r.HandleFunc("/rotate/{Beacon:.*}", handleStartTokenRotation).Methods("PUT")
+
+	r.HandleFunc("/rotate/{Beacon:.*}", handleCancelTokenRotation).Methods("DELETE")
+
+	r.HandleFunc("/promote/{Beacon:.*}", handlePromoteToken).Methods("PUT")
+
 	r.HandleFunc("/create", handleBeaconCreate).Methods("POST")
 
 	r.HandleFunc("/list", handleListBeacons).Methods("GET")
 
 	r.HandleFunc("/list/{Beacon:.*}", handleListInstances).Methods("GET")
 
+	r.HandleFunc("/events", handleBeaconEvents).Methods("GET")
+
+	r.HandleFunc("/refresh", handleRefreshBeacons).Methods("PUT")
+
 	r.HandleFunc("/refresh/{Beacon:.*}", handleRefreshBeacon).Methods("PUT")
+
+	r.HandleFunc("/status/{Beacon:.*}", handleBeaconStatus).Methods("GET")
+
+	r.HandleFunc("/instances", handleFindInstances).Methods("GET")
+
+	r.HandleFunc("/labels/{Instance:.*}", handleSetInstanceLabels).Methods("PUT")
+
+	r.HandleFunc("/tls/{Beacon:.*}", handleUpdateBeaconTLS).Methods("PUT")
+
+	r.HandleFunc("/hosts", handleListHosts).Methods("GET")
+
+	r.HandleFunc("/hosts/{Host:.*}", handleUpdateHostTLS).Methods("PUT")
+
+	r.HandleFunc("/hosts/{Host:.*}", handleRemoveHost).Methods("DELETE")
+
+	r.HandleFunc("/allowlist", handleListAllowlist).Methods("GET")
+
+	r.HandleFunc("/allowlist/{Entry:.*}", handleAddAllowlistEntry).Methods("PUT")
+
+	r.HandleFunc("/allowlist/{Entry:.*}", handleRemoveAllowlistEntry).Methods("DELETE")
+
+	r.HandleFunc("/register", handleRegisterBeacon).Methods("POST")
+
+	r.HandleFunc("/enrollments", handleListEnrollments).Methods("GET")
+
+	r.HandleFunc("/enrollments", handleCreateEnrollment).Methods("POST")
+
+	r.HandleFunc("/enrollments/{Id}", handleRevokeEnrollment).Methods("DELETE")
+
+	r.HandleFunc("/{Beacon:.*}", handleDeleteBeacon).Methods("DELETE")
 }
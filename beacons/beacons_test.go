@@ -147,10 +147,29 @@ func Test_Handle(t *testing.T) {
 		Endpoint string
 	}{
 		{"PUT", "/token/TEST"},
+		{"PUT", "/rotate/TEST"},
+		{"DELETE", "/rotate/TEST"},
+		{"PUT", "/promote/TEST"},
 		{"POST", "/create"},
 		{"GET", "/list"},
 		{"GET", "/list/TEST"},
+		{"PUT", "/refresh"},
 		{"PUT", "/refresh/TEST"},
+		{"GET", "/status/TEST"},
+		{"GET", "/instances"},
+		{"PUT", "/labels/TEST"},
+		{"PUT", "/tls/TEST"},
+		{"GET", "/hosts"},
+		{"PUT", "/hosts/TEST"},
+		{"DELETE", "/hosts/TEST"},
+		{"GET", "/allowlist"},
+		{"PUT", "/allowlist/10.0.0.0/8"},
+		{"DELETE", "/allowlist/10.0.0.0/8"},
+		{"POST", "/register"},
+		{"GET", "/enrollments"},
+		{"POST", "/enrollments"},
+		{"DELETE", "/enrollments/TEST"},
+		{"DELETE", "/TEST"},
 	}
 
 	for _, route := range routes {
@@ -162,6 +181,14 @@ func Test_Handle(t *testing.T) {
 	}
 }
 
+func Test_HandleInstances(t *testing.T) {
+	r := mux.NewRouter()
+	HandleInstances(r)
+
+	req, _ := http.NewRequest("GET", "/instances", bytes.NewBuffer([]byte("")))
+	tryHandleTest(t, req, r)
+}
+
 func Test_Init(t *testing.T) {
 	databases.SetupTestingDefaultConnection()
 	defer databases.TeardownTestingDefaultConnection()
@@ -22,17 +22,42 @@ import (
 	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/beacons/aliases"
 	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/transport"
 )
 
 func SetupTestingTable() {
 	// schemas defined in beacons.go
 	beacons = databases.CommonTestingTable(beaconSchema)
 	instances = databases.CommonTestingTable(instanceSchema)
+	hosts = databases.CommonTestingTable(hostSchema)
+	allowlist = databases.CommonTestingTable(allowlistSchema)
+	labels = databases.CommonTestingTable(labelSchema)
+	enrollments = databases.CommonTestingTable(enrollmentSchema)
+
+	// Tests often write to the tables directly, which the cache would not notice
+	ListingCacheTTL = 0
 }
 
 func TeardownTestingTable() {
 	beacons = nil
 	instances = nil
+	hosts = nil
+	allowlist = nil
+	labels = nil
+	enrollments = nil
+
+	statusesLock.Lock()
+	statuses = make(map[string]BeaconStatus) // defined in monitor.go
+	statusesLock.Unlock()
+
+	instanceReferenceFuncs = []InstanceReferenceFunc{} // defined in refresh.go
+
+	clearListingCache() // defined in cache.go
+
+	subscribersLock.Lock()
+	subscribers = make(map[*eventSubscriber]bool) // defined in events.go
+	subscribersLock.Unlock()
+	ListingCacheTTL = DefaultListingCacheTTL
 }
 
 func setup() {
@@ -65,3 +90,26 @@ func setupServer(f *func(http.ResponseWriter, *http.Request)) *httptest.Server {
 
 	return server
 }
+
+/*
+   Adds an instance with the given user labels for tests outside of
+   this package which need instances to exist.
+*/
+func AddTestingInstance(instance, beacon string, values map[string]string) {
+	addInstance(instanceData{InstanceAddress: instance, CanAccessDocker: true, BeaconAddress: beacon})
+	setInstanceLabels(instance, LabelSourceUser, values)
+}
+
+func AddTestingBeacon(address, token, pendingToken string) {
+	addBeacon(beaconData{Address: address, Token: token, PendingToken: pendingToken})
+}
+
+/*
+   Registers a direct Docker host and allows its hostname, for tests
+   outside of this package which send Docker requests to it. Users
+   still need to be given access to the host.
+*/
+func AddTestingHost(address string) {
+	SetHostTLS(address, transport.TLSSettings{})
+	allowlist.Insert(map[string]interface{}{"Entry": hostHostname(address), "Creator": "TEST"})
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+   How long a beacon's instance listings are served from memory before
+   they are read from the database again. Listings are also dropped
+   whenever the beacon is refreshed or the alias of one of its
+   instances changes. A non-positive TTL disables the cache.
+*/
+const DefaultListingCacheTTL = 30 * time.Second
+
+var ListingCacheTTL = DefaultListingCacheTTL
+
+type listingCacheEntry struct {
+	listings []map[string]interface{}
+	expires  time.Time
+}
+
+var (
+	listingCache     = make(map[string]listingCacheEntry)
+	listingCacheLock = sync.RWMutex{}
+)
+
+func getCachedListings(beacon string) ([]map[string]interface{}, bool) {
+	listingCacheLock.RLock()
+	defer listingCacheLock.RUnlock()
+
+	entry, ok := listingCache[beacon]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.listings, true
+}
+
+func cacheListings(beacon string, listings []map[string]interface{}) {
+	if ListingCacheTTL <= 0 {
+		return
+	}
+
+	listingCacheLock.Lock()
+	defer listingCacheLock.Unlock()
+
+	listingCache[beacon] = listingCacheEntry{listings, time.Now().Add(ListingCacheTTL)}
+}
+
+func invalidateListings(beacon string) {
+	listingCacheLock.Lock()
+	defer listingCacheLock.Unlock()
+
+	delete(listingCache, beacon)
+}
+
+// Drops the listings of the beacon which has the instance, if any
+func invalidateListingsOf(instance string) {
+	if beacon, err := GetBeaconAddress(instance); err == nil {
+		invalidateListings(beacon)
+	}
+}
+
+func clearListingCache() {
+	listingCacheLock.Lock()
+	defer listingCacheLock.Unlock()
+
+	listingCache = make(map[string]listingCacheEntry)
+}
+
+/*
+   Coalesces concurrent syncs of the same beacon so that a burst of
+   refresh requests results in a single request to the beacon, with
+   every caller receiving its result.
+*/
+type syncGroup struct {
+	lock     sync.Mutex
+	inFlight map[string]*syncCall
+}
+
+type syncCall struct {
+	done  chan struct{}
+	stale []string
+	err   error
+}
+
+var syncs = syncGroup{inFlight: make(map[string]*syncCall)}
+
+func (this *syncGroup) do(beacon string, f func() ([]string, error)) ([]string, error) {
+	this.lock.Lock()
+
+	if call, ok := this.inFlight[beacon]; ok {
+		this.lock.Unlock()
+		<-call.done
+		return call.stale, call.err
+	}
+
+	call := &syncCall{done: make(chan struct{})}
+	this.inFlight[beacon] = call
+	this.lock.Unlock()
+
+	call.stale, call.err = f()
+
+	this.lock.Lock()
+	delete(this.inFlight, beacon)
+	this.lock.Unlock()
+
+	close(call.done)
+
+	return call.stale, call.err
+}
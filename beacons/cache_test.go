@@ -0,0 +1,269 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lighthouse/beacon/structs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+)
+
+func Test_GetInstancesList_Cached(t *testing.T) {
+	setup()
+	defer teardown()
+
+	ListingCacheTTL = time.Minute
+
+	f := setupVMServer([]structs.VM{
+		{Name: "VM", Address: "VM_ADDR", Port: "1234", Version: "v1.12"},
+	})
+	defer setupServer(&f).Close()
+
+	addBeacon(beaconData{Address: "localhost:8080", Token: "TOKEN"})
+	setupBeaconPermissions("localhost:8080", auth.AccessAuthLevel)
+	user, _ := auth.GetUser("USER")
+
+	list, _ := getInstancesList("localhost:8080", user, false)
+	assert.Equal(t, 0, len(list))
+
+	// Served from the cache until the beacon is refreshed
+	addInstance(instanceData{"OTHER", "OTHER", true, "localhost:8080", 0})
+
+	list, _ = getInstancesList("localhost:8080", user, false)
+	assert.Equal(t, 0, len(list))
+
+	list, _ = getInstancesList("localhost:8080", user, true)
+	assert.Equal(t, 2, len(list))
+
+	list, _ = getInstancesList("localhost:8080", user, false)
+	assert.Equal(t, 2, len(list))
+
+	invalidateListings("localhost:8080")
+	ListingCacheTTL = 0
+
+	instances.Delete(map[string]interface{}{"InstanceAddress": "OTHER"})
+
+	list, _ = getInstancesList("localhost:8080", user, false)
+	assert.Equal(t, 1, len(list))
+}
+
+func Test_GetInstancesList_AliasChanged(t *testing.T) {
+	setup()
+	defer teardown()
+
+	ListingCacheTTL = time.Minute
+	aliases.SetChangeFunc(invalidateListingsOf)
+
+	addBeacon(beaconData{Address: "BEACON", Token: "TOKEN"})
+	addInstance(instanceData{"INSTANCE", "INSTANCE", true, "BEACON", 0})
+	setupBeaconPermissions("BEACON", auth.AccessAuthLevel)
+	user, _ := auth.GetUser("USER")
+
+	alias := func() interface{} {
+		list, _ := getInstancesList("BEACON", user, false)
+		return list[0]["Alias"]
+	}
+
+	aliases.SetAlias("FIRST", "INSTANCE")
+	assert.Equal(t, "FIRST", alias())
+
+	aliases.SetAlias("SECOND", "INSTANCE")
+	assert.Equal(t, "SECOND", alias())
+
+	aliases.RemoveAlias("INSTANCE")
+	assert.Equal(t, "", alias())
+}
+
+func Test_ListingCache_Expires(t *testing.T) {
+	defer clearListingCache()
+
+	ListingCacheTTL = time.Minute
+	defer func() { ListingCacheTTL = DefaultListingCacheTTL }()
+
+	cacheListings("BEACON", []map[string]interface{}{})
+
+	_, ok := getCachedListings("BEACON")
+	assert.True(t, ok)
+
+	listingCacheLock.Lock()
+	entry := listingCache["BEACON"]
+	entry.expires = time.Now().Add(-time.Second)
+	listingCache["BEACON"] = entry
+	listingCacheLock.Unlock()
+
+	_, ok = getCachedListings("BEACON")
+	assert.False(t, ok)
+}
+
+func Test_SyncGroup_Coalesces(t *testing.T) {
+	group := syncGroup{inFlight: make(map[string]*syncCall)}
+
+	var calls int32
+	release := make(chan struct{})
+
+	f := func() ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []string{"STALE"}, nil
+	}
+
+	wait := sync.WaitGroup{}
+	wait.Add(3)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wait.Done()
+
+			stale, err := group.do("BEACON", f)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"STALE"}, stale)
+		}()
+	}
+
+	// Wait for the first call to start before letting it finish
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	wait.Wait()
+
+	assert.Equal(t, int32(1), calls)
+
+	// Later calls are not coalesced with finished ones
+	group.do("BEACON", func() ([]string, error) {
+		return nil, errors.New("ERROR")
+	})
+	_, err := group.do("BEACON", f)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), calls)
+}
+
+func Test_GetAllInstanceLabels(t *testing.T) {
+	setup()
+	defer teardown()
+
+	setInstanceLabels("A", LabelSourceBeacon, map[string]string{"env": "dev", "zone": "1"})
+	setInstanceLabels("A", LabelSourceUser, map[string]string{"env": "prod"})
+	setInstanceLabels("B", LabelSourceUser, map[string]string{"env": "dev"})
+
+	all, err := getAllInstanceLabels()
+	assert.Nil(t, err)
+
+	expectedA, _ := GetInstanceLabels("A")
+	assert.Equal(t, expectedA, all["A"])
+	assert.Equal(t, map[string]string{"env": "prod", "zone": "1"}, all["A"])
+	assert.Equal(t, map[string]string{"env": "dev"}, all["B"])
+}
+
+func Test_GetLabelsOfInstances(t *testing.T) {
+	setup()
+	defer teardown()
+
+	setInstanceLabels("A", LabelSourceBeacon, map[string]string{"env": "dev"})
+	setInstanceLabels("A", LabelSourceUser, map[string]string{"env": "prod"})
+	setInstanceLabels("B", LabelSourceUser, map[string]string{"env": "dev"})
+
+	found, err := getLabelsOfInstances([]string{"A", "C"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]string{"A": {"env": "prod"}}, found)
+}
+
+func Test_AcquireRefreshSlot(t *testing.T) {
+	RefreshWorkers = 1
+	defer func() { RefreshWorkers = 8 }()
+
+	release := acquireRefreshSlot()
+
+	acquired := make(chan bool)
+	go func() {
+		acquireRefreshSlot()()
+		acquired <- true
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("a second refresh should wait for the first")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	<-acquired
+}
+
+func Test_RefreshBeacons(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := setupVMServer([]structs.VM{})
+	defer setupServer(&f).Close()
+
+	list := []beaconData{
+		{Address: "localhost:8080", Token: "TOKEN"},
+		{Address: "localhost:1", Token: "TOKEN"},
+		{Address: "localhost:8080", Token: "TOKEN"},
+	}
+
+	RefreshWorkers = 2
+	defer func() { RefreshWorkers = 8 }()
+
+	reports := RefreshBeacons(list, time.Hour)
+
+	assert.Equal(t, 3, len(reports))
+	assert.Equal(t, "localhost:8080", reports[0].Beacon)
+	assert.Equal(t, "", reports[0].Error)
+	assert.Equal(t, "localhost:1", reports[1].Beacon)
+	assert.NotEqual(t, "", reports[1].Error)
+	assert.Equal(t, "", reports[2].Error)
+
+	assert.Equal(t, 0, len(RefreshBeacons([]beaconData{}, time.Hour)))
+}
+
+func Test_ForEachBeacon(t *testing.T) {
+	RefreshWorkers = 2
+	defer func() { RefreshWorkers = 8 }()
+
+	list := make([]beaconData, 5)
+	done := make([]bool, len(list))
+
+	var running, most int32
+
+	forEachBeacon(list, func(i int) {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		if now > atomic.LoadInt32(&most) {
+			atomic.StoreInt32(&most, now)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		done[i] = true
+	})
+
+	assert.Equal(t, []bool{true, true, true, true, true}, done)
+	assert.True(t, atomic.LoadInt32(&most) <= 2)
+
+	forEachBeacon([]beaconData{}, func(int) { t.Fatal("no beacons to work on") })
+}
@@ -0,0 +1,387 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/logging"
+	"github.com/lighthouse/lighthouse/transport"
+)
+
+/*
+   Beacons may register themselves through /register by presenting
+   an enrollment secret. Secrets are issued by admins, may only be
+   used once, expire, and only allow addresses matching their Scope
+   (a pattern as in auth.MatchPermissionKey). The admin who issued
+   the secret becomes the owner of the registered beacon.
+*/
+
+const (
+	ENROLLMENT_SECRET_DELIM  = "."
+	DefaultEnrollmentExpiry  = 24 * time.Hour
+	EnrollmentAdminAuthLevel = auth.CreateUserAuthLevel
+)
+
+var (
+	EnrollmentPermissionError = errors.New("beacons: user not permitted to manage enrollment secrets")
+	InvalidEnrollmentError    = errors.New("beacons: enrollment secret is invalid, expired or already used")
+	EnrollmentScopeError      = errors.New("beacons: enrollment secret does not allow this address")
+	UnknownEnrollmentError    = errors.New("beacons: unknown enrollment secret")
+)
+
+var enrollments databases.TableInterface
+
+var enrollmentSchema = databases.Schema{
+	"Id":        "text UNIQUE PRIMARY KEY",
+	"Salt":      "text",
+	"Key":       "text",
+	"Creator":   "text",
+	"Scope":     "text",
+	"ExpiresAt": "bigint",
+}
+
+type enrollmentData struct {
+	Id        string
+	Salt      string
+	Key       string
+	Creator   string
+	Scope     string
+	ExpiresAt int64
+}
+
+// Keeps two beacons from registering with the same secret at once
+var registerLock = sync.Mutex{}
+
+/*
+   Issues a new enrollment secret. The secret is only ever returned
+   here, only its salted hash is stored.
+
+   RETURN: The stored enrollment and the secret to hand to the beacon
+*/
+func createEnrollment(creator, scope string, expiry time.Duration) (enrollmentData, string, error) {
+	if err := auth.ValidatePermissionKey(scope); err != nil {
+		return enrollmentData{}, "", err
+	}
+
+	key := GenerateBeaconToken()
+
+	enrollment := enrollmentData{
+		Id:        auth.GenerateSalt(),
+		Salt:      auth.GenerateSalt(),
+		Creator:   creator,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(expiry).Unix(),
+	}
+	enrollment.Key = auth.SaltPassword(key, enrollment.Salt)
+
+	err := enrollments.Insert(map[string]interface{}{
+		"Id":        enrollment.Id,
+		"Salt":      enrollment.Salt,
+		"Key":       enrollment.Key,
+		"Creator":   enrollment.Creator,
+		"Scope":     enrollment.Scope,
+		"ExpiresAt": enrollment.ExpiresAt,
+	})
+
+	return enrollment, enrollment.Id + ENROLLMENT_SECRET_DELIM + key, err
+}
+
+func getEnrollment(id string) (enrollmentData, error) {
+	var enrollment enrollmentData
+	where := databases.Filter{"Id": id}
+
+	err := enrollments.SelectRow(nil, where, nil, &enrollment)
+	if err == databases.NoRowsError {
+		err = UnknownEnrollmentError
+	}
+
+	return enrollment, err
+}
+
+func revokeEnrollment(id string) error {
+	err := enrollments.Delete(databases.Filter{"Id": id})
+	if err == databases.NoUpdateError {
+		err = UnknownEnrollmentError
+	}
+
+	return err
+}
+
+/*
+   RETURN: The enrollment the secret belongs to if it is valid and
+           has not expired
+*/
+func checkEnrollmentSecret(secret string) (enrollmentData, error) {
+	parts := strings.SplitN(secret, ENROLLMENT_SECRET_DELIM, 2)
+	if len(parts) != 2 {
+		return enrollmentData{}, InvalidEnrollmentError
+	}
+
+	enrollment, err := getEnrollment(parts[0])
+	if err != nil {
+		return enrollmentData{}, InvalidEnrollmentError
+	}
+
+	if auth.SaltPassword(parts[1], enrollment.Salt) != enrollment.Key ||
+		time.Now().Unix() >= enrollment.ExpiresAt {
+
+		return enrollmentData{}, InvalidEnrollmentError
+	}
+
+	return enrollment, nil
+}
+
+/*
+   Lists all unexpired enrollments, removing expired ones as it goes.
+*/
+func getEnrollmentList() ([]enrollmentData, error) {
+	scanner, err := enrollments.Select(nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]enrollmentData, 0)
+	expired := make([]string, 0)
+	now := time.Now().Unix()
+
+	for scanner.Next() {
+		var enrollment enrollmentData
+		scanner.Scan(&enrollment)
+
+		if now >= enrollment.ExpiresAt {
+			expired = append(expired, enrollment.Id)
+		} else {
+			list = append(list, enrollment)
+		}
+	}
+
+	for _, id := range expired {
+		revokeEnrollment(id)
+	}
+
+	return list, nil
+}
+
+/*
+   Adds the beacon described by the registration after checking its
+   enrollment secret, then uses up the secret.
+*/
+func registerBeacon(secret, alias string, beacon beaconData) error {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+
+	enrollment, err := checkEnrollmentSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	if !auth.MatchPermissionKey(enrollment.Scope, beacon.Address) {
+		return EnrollmentScopeError
+	}
+
+	if alias == "" {
+		alias = beacon.Address
+	}
+
+	err = addBeacon(beacon)
+	if err == databases.DuplicateKeyError {
+		return DuplicateBeaconError
+	} else if err != nil {
+		return err
+	}
+
+	err = aliases.AddAlias(alias, beacon.Address)
+	if err == nil {
+		err = refreshVMListOf(beacon)
+		if err != nil {
+			aliases.RemoveAlias(beacon.Address)
+		}
+	}
+
+	if err != nil {
+		removeBeacon(beacon.Address)
+		return err
+	}
+
+	revokeEnrollment(enrollment.Id)
+
+	if owner, err := auth.GetUser(enrollment.Creator); err == nil {
+		auth.SetUserBeaconAuthLevel(owner, beacon.Address, auth.OwnerAuthLevel)
+	}
+
+	logging.Info(fmt.Sprintf("beacon %s registered using enrollment %s of %s",
+		beacon.Address, enrollment.Id, enrollment.Creator))
+
+	return nil
+}
+
+func writeEnrollmentJSON(w http.ResponseWriter, v interface{}) {
+	output, err := json.Marshal(v)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	fmt.Fprint(w, string(output))
+}
+
+func enrollmentInfo(enrollment enrollmentData) map[string]interface{} {
+	return map[string]interface{}{
+		"Id":        enrollment.Id,
+		"Creator":   enrollment.Creator,
+		"Scope":     enrollment.Scope,
+		"ExpiresAt": enrollment.ExpiresAt,
+	}
+}
+
+func handleCreateEnrollment(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	if user.AuthLevel < EnrollmentAdminAuthLevel {
+		writeResponse(EnrollmentPermissionError, w)
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	var request struct {
+		Scope     string
+		ExpiresIn string
+	}
+
+	err = json.Unmarshal(reqBody, &request)
+	if err != nil {
+		writeResponse(NotEnoughParametersError, w)
+		return
+	}
+
+	expiry := DefaultEnrollmentExpiry
+	if request.ExpiresIn != "" {
+		expiry, err = time.ParseDuration(request.ExpiresIn)
+		if err != nil || expiry <= 0 {
+			writeResponse(NotEnoughParametersError, w)
+			return
+		}
+	}
+
+	enrollment, secret, err := createEnrollment(user.Email, request.Scope, expiry)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	info := enrollmentInfo(enrollment)
+	info["Secret"] = secret
+
+	writeEnrollmentJSON(w, info)
+}
+
+func handleListEnrollments(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	if user.AuthLevel < EnrollmentAdminAuthLevel {
+		writeResponse(EnrollmentPermissionError, w)
+		return
+	}
+
+	list, err := getEnrollmentList()
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	infos := make([]map[string]interface{}, 0, len(list))
+	for _, enrollment := range list {
+		infos = append(infos, enrollmentInfo(enrollment))
+	}
+
+	writeEnrollmentJSON(w, infos)
+}
+
+func handleRevokeEnrollment(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	if user.AuthLevel < EnrollmentAdminAuthLevel {
+		writeResponse(EnrollmentPermissionError, w)
+		return
+	}
+
+	writeResponse(revokeEnrollment(mux.Vars(r)["Id"]), w)
+}
+
+/*
+   Called by beacons themselves, so this is not behind a login.
+   The enrollment secret is the only credential.
+*/
+func handleRegisterBeacon(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	var registration struct {
+		Secret  string
+		Address string
+		Token   string
+		Alias   string
+		TLS     transport.TLSSettings
+	}
+
+	err = json.Unmarshal(reqBody, &registration)
+	if err != nil || registration.Secret == "" || registration.Address == "" {
+		writeResponse(NotEnoughParametersError, w)
+		return
+	}
+
+	err = registration.TLS.Validate()
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	if registration.Alias != "" {
+		err = aliases.ValidateAlias(registration.Alias)
+		if err != nil {
+			writeResponse(err, w)
+			return
+		}
+	}
+
+	beacon := beaconData{
+		Address:     registration.Address,
+		Token:       registration.Token,
+		TLSSettings: registration.TLS,
+	}
+
+	writeResponse(registerBeacon(registration.Secret, registration.Alias, beacon), w)
+}
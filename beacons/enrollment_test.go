@@ -0,0 +1,161 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+)
+
+func Test_CheckEnrollmentSecret(t *testing.T) {
+	setup()
+	defer teardown()
+
+	enrollment, secret, err := createEnrollment("ADMIN", "*", time.Hour)
+	assert.Nil(t, err)
+
+	found, err := checkEnrollmentSecret(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, enrollment.Id, found.Id)
+
+	_, err = checkEnrollmentSecret(enrollment.Id + ENROLLMENT_SECRET_DELIM + "WRONG")
+	assert.Equal(t, InvalidEnrollmentError, err)
+
+	_, err = checkEnrollmentSecret("NODELIM")
+	assert.Equal(t, InvalidEnrollmentError, err)
+
+	_, expired, _ := createEnrollment("ADMIN", "*", -time.Second)
+	_, err = checkEnrollmentSecret(expired)
+	assert.Equal(t, InvalidEnrollmentError, err)
+}
+
+func Test_GetEnrollmentList(t *testing.T) {
+	setup()
+	defer teardown()
+
+	active, _, _ := createEnrollment("ADMIN", "10.0.*", time.Hour)
+	createEnrollment("ADMIN", "*", -time.Second)
+
+	list, err := getEnrollmentList()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, active.Id, list[0].Id)
+
+	// Expired enrollments are removed while listing
+	list, _ = getEnrollmentList()
+	assert.Equal(t, 1, len(list))
+
+	assert.Nil(t, revokeEnrollment(active.Id))
+	assert.Equal(t, UnknownEnrollmentError, revokeEnrollment(active.Id))
+
+	list, _ = getEnrollmentList()
+	assert.Equal(t, 0, len(list))
+}
+
+func Test_RegisterBeacon(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "[]")
+	}
+	defer setupServer(&f).Close()
+
+	auth.CreateUser("ADMIN", "", "")
+	_, secret, _ := createEnrollment("ADMIN", "localhost:*", time.Hour)
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+
+	err := registerBeacon(secret, "NewBeacon", beacon)
+	assert.Nil(t, err)
+
+	data, err := getBeaconData("localhost:8080")
+	assert.Nil(t, err)
+	assert.Equal(t, "TOKEN", data.Token)
+
+	alias, _ := aliases.GetAliasOf("localhost:8080")
+	assert.Equal(t, "NewBeacon", alias)
+
+	admin, _ := auth.GetUser("ADMIN")
+	assert.Equal(t, auth.OwnerAuthLevel, admin.GetAuthLevel("Beacons", "localhost:8080"))
+
+	// Secrets may only be used once
+	removeBeacon("localhost:8080")
+	assert.Equal(t, InvalidEnrollmentError, registerBeacon(secret, "", beacon))
+}
+
+func Test_RegisterBeacon_Invalid(t *testing.T) {
+	setup()
+	defer teardown()
+
+	_, secret, _ := createEnrollment("ADMIN", "10.0.*", time.Hour)
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+
+	assert.Equal(t, EnrollmentScopeError, registerBeacon(secret, "", beacon))
+	assert.Equal(t, InvalidEnrollmentError, registerBeacon("BAD", "", beacon))
+
+	// Beacons which cannot be reached are not added
+	_, secret, _ = createEnrollment("ADMIN", "*", time.Hour)
+	assert.NotNil(t, registerBeacon(secret, "", beacon))
+	assert.False(t, beaconExists("localhost:8080"))
+
+	_, err := aliases.GetAddressOf("localhost:8080")
+	assert.NotNil(t, err)
+
+	// A failed registration does not use up the secret
+	_, err = checkEnrollmentSecret(secret)
+	assert.Nil(t, err)
+}
+
+func Test_HandleRegisterBeacon_Invalid(t *testing.T) {
+	setup()
+	defer teardown()
+
+	w := runHandlerTest("POST", "/register", map[string]interface{}{
+		"Address": "localhost:8080",
+	}, "/register", handleRegisterBeacon)
+	assert.Equal(t, 400, w.Code)
+
+	w = runHandlerTest("POST", "/register", map[string]interface{}{
+		"Secret":  "BAD",
+		"Address": "localhost:8080",
+	}, "/register", handleRegisterBeacon)
+	assert.Equal(t, 403, w.Code)
+}
+
+func Test_HandleEnrollments_Permissions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	w := runHandlerTest("POST", "/enrollments", map[string]interface{}{
+		"Scope": "*",
+	}, "/enrollments", handleCreateEnrollment)
+	assert.Equal(t, 403, w.Code)
+
+	w = runHandlerTest("GET", "/enrollments", nil, "/enrollments", handleListEnrollments)
+	assert.Equal(t, 403, w.Code)
+
+	w = runHandlerTest("DELETE", "/enrollments/ID", nil, "/enrollments/{Id}", handleRevokeEnrollment)
+	assert.Equal(t, 403, w.Code)
+}
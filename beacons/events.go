@@ -0,0 +1,185 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lighthouse/lighthouse/auth"
+)
+
+const (
+	EventInstanceAdded   = "instance_added"
+	EventInstanceUpdated = "instance_updated"
+	EventInstanceRemoved = "instance_removed"
+	EventBeaconUp        = "beacon_up"
+	EventBeaconDown      = "beacon_down"
+	EventTokenChanged    = "token_changed"
+)
+
+// Events buffered per subscriber, further events are dropped until it catches up
+const EventBufferSize = 64
+
+// How often an idle event stream is sent a comment to keep it open
+var EventKeepAlive = 15 * time.Second
+
+var StreamingUnsupportedError = errors.New("beacons: response does not support streaming")
+
+/*
+   Something that happened to a beacon or one of its instances.
+   Instance is only set for instance events, and Data holds the
+   instance itself for added and updated events.
+*/
+type Event struct {
+	Type     string
+	Beacon   string
+	Instance string `json:",omitempty"`
+	Time     time.Time
+	Data     interface{} `json:",omitempty"`
+}
+
+type eventSubscriber struct {
+	events chan Event
+	filter func(Event) bool
+}
+
+var (
+	subscribers     = make(map[*eventSubscriber]bool)
+	subscribersLock = sync.RWMutex{}
+)
+
+/*
+   Starts receiving every published event for which filter returns
+   true. The returned function must be called to stop receiving.
+*/
+func SubscribeEvents(filter func(Event) bool) (<-chan Event, func()) {
+	sub := &eventSubscriber{make(chan Event, EventBufferSize), filter}
+
+	subscribersLock.Lock()
+	subscribers[sub] = true
+	subscribersLock.Unlock()
+
+	cancel := func() {
+		subscribersLock.Lock()
+		defer subscribersLock.Unlock()
+
+		if subscribers[sub] {
+			delete(subscribers, sub)
+			close(sub.events)
+		}
+	}
+
+	return sub.events, cancel
+}
+
+/*
+   Sends the event to all interested subscribers without blocking.
+   Subscribers which are too far behind miss the event.
+*/
+func publishEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	subscribersLock.RLock()
+	defer subscribersLock.RUnlock()
+
+	for sub := range subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+func publishInstanceEvent(eventType string, instance instanceData) {
+	event := Event{
+		Type:     eventType,
+		Beacon:   instance.BeaconAddress,
+		Instance: instance.InstanceAddress,
+	}
+
+	if eventType != EventInstanceRemoved {
+		event.Data = instance
+	}
+
+	publishEvent(event)
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+/*
+   Streams events as server-sent events. Each subscriber only receives
+   events for beacons it could access when it subscribed.
+*/
+func handleBeaconEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeResponse(StreamingUnsupportedError, w)
+		return
+	}
+
+	user := auth.GetCurrentUser(r)
+
+	events, cancel := SubscribeEvents(func(event Event) bool {
+		return user != nil && user.CanAccessBeacon(event.Beacon)
+	})
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(EventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-events:
+			if !ok || writeEvent(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/lighthouse/beacon/structs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+// Collects all events currently buffered for the subscriber
+func drainEvents(events <-chan Event) []Event {
+	list := make([]Event, 0)
+
+	for {
+		select {
+		case event := <-events:
+			list = append(list, event)
+		default:
+			return list
+		}
+	}
+}
+
+func eventTypes(list []Event) []string {
+	types := make([]string, len(list))
+	for i, event := range list {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func Test_SubscribeEvents(t *testing.T) {
+	setup()
+	defer teardown()
+
+	all, cancelAll := SubscribeEvents(nil)
+	filtered, cancelFiltered := SubscribeEvents(func(event Event) bool {
+		return event.Beacon == "WANTED"
+	})
+	defer cancelFiltered()
+
+	publishEvent(Event{Type: EventBeaconUp, Beacon: "WANTED"})
+	publishEvent(Event{Type: EventBeaconUp, Beacon: "OTHER"})
+
+	assert.Equal(t, 2, len(drainEvents(all)))
+
+	list := drainEvents(filtered)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "WANTED", list[0].Beacon)
+	assert.False(t, list[0].Time.IsZero())
+
+	cancelAll()
+	cancelAll()
+
+	_, open := <-all
+	assert.False(t, open)
+}
+
+func Test_PublishEvent_SlowSubscriber(t *testing.T) {
+	setup()
+	defer teardown()
+
+	events, cancel := SubscribeEvents(nil)
+	defer cancel()
+
+	for i := 0; i < EventBufferSize+10; i++ {
+		publishEvent(Event{Type: EventBeaconUp, Beacon: "BEACON"})
+	}
+
+	assert.Equal(t, EventBufferSize, len(drainEvents(events)))
+}
+
+func Test_Events_InstanceLifecycle(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vms := []structs.VM{
+		{Name: "VM", Address: "VM_ADDR", Port: "1234", Version: "v1.12"},
+	}
+	f := func(w http.ResponseWriter, r *http.Request) {
+		setupVMServer(vms)(w, r)
+	}
+	defer setupServer(&f).Close()
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+	addBeacon(beacon)
+
+	events, cancel := SubscribeEvents(nil)
+	defer cancel()
+
+	syncVMListOf(beacon)
+
+	list := drainEvents(events)
+	assert.Equal(t, []string{EventBeaconUp, EventInstanceAdded}, eventTypes(list))
+	assert.Equal(t, "VM_ADDR:1234/v1.12", list[1].Instance)
+	assert.Equal(t, "localhost:8080", list[1].Beacon)
+
+	// Unchanged instances are not reported again
+	syncVMListOf(beacon)
+	assert.Equal(t, 0, len(drainEvents(events)))
+
+	vms[0].Name = "RENAMED"
+	syncVMListOf(beacon)
+	assert.Equal(t, []string{EventInstanceUpdated}, eventTypes(drainEvents(events)))
+
+	vms = []structs.VM{}
+	syncVMListOf(beacon)
+	assert.Equal(t, []string{EventInstanceUpdated}, eventTypes(drainEvents(events)))
+
+	pruneStaleInstances(beacon.Address, -time.Hour)
+
+	list = drainEvents(events)
+	assert.Equal(t, []string{EventInstanceRemoved}, eventTypes(list))
+	assert.Nil(t, list[0].Data)
+}
+
+func Test_Events_BeaconStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	events, cancel := SubscribeEvents(nil)
+	defer cancel()
+
+	recordBeaconStatus("BEACON", time.Millisecond, nil)
+	recordBeaconStatus("BEACON", time.Millisecond, nil)
+	recordBeaconStatus("BEACON", time.Millisecond, errors.New("unreachable"))
+
+	list := drainEvents(events)
+	assert.Equal(t, []string{EventBeaconUp, EventBeaconDown}, eventTypes(list))
+	assert.Equal(t, "unreachable", list[1].Data.(BeaconStatus).LastError)
+}
+
+func Test_Events_TokenChanged(t *testing.T) {
+	setup()
+	defer teardown()
+
+	AddTestingBeacon("BEACON", "OLD", "NEW")
+
+	events, cancel := SubscribeEvents(nil)
+	defer cancel()
+
+	promotePendingToken("BEACON", "NEW")
+
+	list := drainEvents(events)
+	assert.Equal(t, []string{EventTokenChanged}, eventTypes(list))
+	assert.Equal(t, "BEACON", list[0].Beacon)
+}
+
+/*
+   Runs the event stream of the user or service account while the
+   events are published, returning what was streamed.
+*/
+func runBeaconEventsTest(email string, service bool, publish ...Event) *httptest.ResponseRecorder {
+	ctx, stop := context.WithCancel(context.Background())
+	r, _ := http.NewRequest("GET", "/events", nil)
+	r = r.WithContext(ctx)
+	session.SetValue(r, "auth", "email", email)
+	if service {
+		session.SetValue(r, "auth", "service", true)
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan bool)
+
+	go func() {
+		handleBeaconEvents(w, r)
+		done <- true
+	}()
+
+	// Wait for the handler to subscribe
+	for {
+		subscribersLock.RLock()
+		count := len(subscribers)
+		subscribersLock.RUnlock()
+
+		if count > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, event := range publish {
+		publishEvent(event)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	<-done
+
+	return w
+}
+
+func Test_HandleBeaconEvents(t *testing.T) {
+	setup()
+	defer teardown()
+
+	setupBeaconPermissions("ALLOWED", auth.AccessAuthLevel)
+
+	w := runBeaconEventsTest("USER", false,
+		Event{Type: EventBeaconDown, Beacon: "HIDDEN"},
+		Event{Type: EventBeaconUp, Beacon: "ALLOWED"},
+	)
+
+	body := w.Body.String()
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(body, "event: beacon_up\ndata: {"))
+	assert.True(t, strings.Contains(body, `"Beacon":"ALLOWED"`))
+	assert.False(t, strings.Contains(body, "HIDDEN"))
+
+	subscribersLock.RLock()
+	assert.Equal(t, 0, len(subscribers))
+	subscribersLock.RUnlock()
+}
+
+func Test_HandleBeaconEvents_ServiceAccount(t *testing.T) {
+	setup()
+	defer teardown()
+
+	perms := auth.NewPermission()
+	perms["Beacons"].(map[string]interface{})["ALLOWED"] = auth.AccessAuthLevel
+	auth.CreateServiceAccount("ci-bot", "ADMIN", perms)
+
+	w := runBeaconEventsTest("ci-bot", true,
+		Event{Type: EventBeaconDown, Beacon: "HIDDEN"},
+		Event{Type: EventBeaconUp, Beacon: "ALLOWED"},
+	)
+
+	body := w.Body.String()
+	assert.True(t, strings.Contains(body, `"Beacon":"ALLOWED"`))
+	assert.False(t, strings.Contains(body, "HIDDEN"))
+}
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -27,6 +28,7 @@ import (
 	"github.com/lighthouse/lighthouse/beacons/aliases"
 	"github.com/lighthouse/lighthouse/databases"
 	"github.com/lighthouse/lighthouse/handlers"
+	"github.com/lighthouse/lighthouse/transport"
 )
 
 func getAddressOf(alias string) string {
@@ -47,12 +49,28 @@ func writeResponse(err error, w http.ResponseWriter) {
 		databases.NoUpdateError, databases.EmptyKeyError:
 		handlers.WriteError(w, http.StatusBadRequest, "beacons", err.Error())
 
-	case TokenPermissionError:
+	case TokenPermissionError, BeaconPermissionError, EnrollmentPermissionError,
+		InvalidEnrollmentError, EnrollmentScopeError, HostPermissionError,
+		HostNotAllowedError:
 		handlers.WriteError(w, http.StatusForbidden, "beacons", err.Error())
 
-	case NotEnoughParametersError, DuplicateBeaconError:
+	case UnknownBeaconError, UnknownInstanceError, UnknownEnrollmentError,
+		UnknownHostError:
+		handlers.WriteError(w, http.StatusNotFound, "beacons", err.Error())
+
+	case BeaconInUseError, NoPendingTokenError, TokenVerificationError,
+		aliases.AliasConflictError:
+		handlers.WriteError(w, http.StatusConflict, "beacons", err.Error())
+
+	case NotEnoughParametersError, DuplicateBeaconError,
+		InvalidLabelError, InvalidSelectorError, auth.InvalidPermissionKeyError,
+		InvalidSearchError, aliases.InvalidAliasError, InvalidAllowlistEntryError,
+		transport.InvalidCACertError, transport.InvalidClientCertError:
 		handlers.WriteError(w, http.StatusBadRequest, "beacons", err.Error())
 
+	case StreamingUnsupportedError:
+		handlers.WriteError(w, http.StatusNotImplemented, "beacons", err.Error())
+
 	default:
 		handlers.WriteError(w, http.StatusInternalServerError, "beacons", err.Error())
 	}
@@ -91,9 +109,7 @@ func handleUpdateBeaconToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	to := map[string]interface{}{"Token": token}
-	where := map[string]interface{}{"Address": beacon}
-	err = beacons.Update(to, where)
+	err = setBeaconToken(beacon, token)
 
 	return
 }
@@ -111,6 +127,7 @@ func handleBeaconCreate(w http.ResponseWriter, r *http.Request) {
 		Address string
 		Token   string
 		Alias   string
+		TLS     transport.TLSSettings
 	}
 
 	err = json.Unmarshal(reqBody, &beaconInfo)
@@ -124,10 +141,21 @@ func handleBeaconCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	beacon := beaconData{beaconInfo.Address, beaconInfo.Token}
+	err = aliases.ValidateAlias(beaconInfo.Alias)
+	if err != nil {
+		return
+	}
 
-	currentUser := auth.GetCurrentUser(r)
-	auth.SetUserBeaconAuthLevel(currentUser, beacon.Address, auth.OwnerAuthLevel)
+	err = beaconInfo.TLS.Validate()
+	if err != nil {
+		return
+	}
+
+	beacon := beaconData{
+		Address:     beaconInfo.Address,
+		Token:       beaconInfo.Token,
+		TLSSettings: beaconInfo.TLS,
+	}
 
 	err = aliases.AddAlias(beaconInfo.Alias, beaconInfo.Address)
 	if err != nil {
@@ -151,7 +179,9 @@ func handleBeaconCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	return
+	// Only the user who added the beacon owns it
+	currentUser := auth.GetCurrentUser(r)
+	auth.SetUserBeaconAuthLevel(currentUser, beacon.Address, auth.OwnerAuthLevel)
 }
 
 func handleListBeacons(w http.ResponseWriter, r *http.Request) {
@@ -160,7 +190,7 @@ func handleListBeacons(w http.ResponseWriter, r *http.Request) {
 
 	var output []byte
 	if err == nil {
-		output, err = json.Marshal(beacons)
+		output, err = json.Marshal(withBeaconStatus(beacons))
 	}
 
 	if err != nil {
@@ -191,13 +221,123 @@ func handleListInstances(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/*
+   Refreshing may remove instances, so it needs modify permission.
+*/
 func handleRefreshBeacon(w http.ResponseWriter, r *http.Request) {
-	beacon := mux.Vars(r)["Beacon"]
+	beacon := getAddressOf(mux.Vars(r)["Beacon"])
+	user := auth.GetCurrentUser(r)
+
+	if !user.CanModifyBeacon(beacon) {
+		writeResponse(BeaconPermissionError, w)
+		return
+	}
+
 	data, err := getBeaconData(beacon)
 
+	var report RefreshReport
+	if err == nil {
+		report, err = RefreshBeacon(data, StaleInstanceGrace)
+	}
+
+	var output []byte
 	if err == nil {
-		err = refreshVMListOf(data)
+		output, err = json.Marshal(report)
 	}
 
-	writeResponse(err, w)
+	if err != nil {
+		writeResponse(err, w)
+	} else {
+		logRefreshReport(report)
+		fmt.Fprint(w, string(output))
+	}
+}
+
+/*
+   Refreshes every beacon the user can modify concurrently.
+*/
+func handleRefreshBeacons(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+	list, err := getAllBeaconData()
+
+	var output []byte
+	if err == nil {
+		modifiable := make([]beaconData, 0, len(list))
+		for _, beacon := range list {
+			if user.CanModifyBeacon(beacon.Address) {
+				modifiable = append(modifiable, beacon)
+			}
+		}
+
+		reports := RefreshBeacons(modifiable, StaleInstanceGrace)
+		for _, report := range reports {
+			logRefreshReport(report)
+		}
+
+		output, err = json.Marshal(reports)
+	}
+
+	if err != nil {
+		writeResponse(err, w)
+	} else {
+		fmt.Fprint(w, string(output))
+	}
+}
+
+func handleBeaconStatus(w http.ResponseWriter, r *http.Request) {
+	beacon := getAddressOf(mux.Vars(r)["Beacon"])
+	user := auth.GetCurrentUser(r)
+
+	if !user.CanAccessBeacon(beacon) {
+		writeResponse(BeaconPermissionError, w)
+		return
+	}
+
+	if !beaconExists(beacon) {
+		writeResponse(UnknownBeaconError, w)
+		return
+	}
+
+	output, err := json.Marshal(GetBeaconStatus(beacon))
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	fmt.Fprint(w, string(output))
+}
+
+func handleDeleteBeacon(w http.ResponseWriter, r *http.Request) {
+	beacon := getAddressOf(mux.Vars(r)["Beacon"])
+	user := auth.GetCurrentUser(r)
+
+	if user.GetAuthLevel("Beacons", beacon) < auth.OwnerAuthLevel {
+		writeResponse(BeaconPermissionError, w)
+		return
+	}
+
+	if !beaconExists(beacon) {
+		writeResponse(UnknownBeaconError, w)
+		return
+	}
+
+	force, err := strconv.ParseBool(r.URL.Query().Get("force"))
+	report, err := deleteBeacon(beacon, force && err == nil)
+
+	if err == BeaconInUseError {
+		handlers.WriteError(w, http.StatusConflict, "beacons", fmt.Sprintf(
+			"%s [%s]", err.Error(), strings.Join(report.Applications, ", ")))
+		return
+	}
+
+	var output []byte
+	if err == nil {
+		output, err = json.Marshal(report)
+	}
+
+	if err != nil {
+		writeResponse(err, w)
+	} else {
+		fmt.Fprint(w, string(output))
+	}
 }
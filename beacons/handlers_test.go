@@ -147,6 +147,9 @@ func Test_HandleBeaconCreate(t *testing.T) {
 	beacons.SelectRow(nil, nil, nil, &beacon)
 	assert.Equal(t, "localhost:8080", beacon.Address)
 	assert.Equal(t, "TOKEN_PASS", beacon.Token)
+
+	user, _ := auth.GetUser("USER")
+	assert.Equal(t, auth.OwnerAuthLevel, user.GetAuthLevel("Beacons", "localhost:8080"))
 }
 
 func Test_HandleBeaconCreate_Invalid(t *testing.T) {
@@ -187,6 +190,11 @@ func Test_HandleBeaconCreate_Invalid(t *testing.T) {
 
 	w = runHandlerTest("POST", "/", body, "/", handleBeaconCreate)
 	assert.Equal(t, 400, w.Code)
+
+	// Failing to add a beacon does not make the user its owner
+	user, _ := auth.GetUser("USER")
+	assert.Equal(t, -1, user.GetAuthLevel("Beacons", "ADDR"))
+	assert.Equal(t, -1, user.GetAuthLevel("Beacons", "localhost:8080"))
 }
 
 func Test_HandleListBeacons(t *testing.T) {
@@ -206,3 +214,52 @@ func Test_HandleListInstances(t *testing.T) {
 	w := runHandlerTest("GET", "/ADDR", nil, "/{Endpoint}", handleListInstances)
 	assert.Equal(t, 200, w.Code)
 }
+
+func Test_HandleDeleteBeacon(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addBeacon(beaconData{Address: "ADDR", Token: "TOKEN"})
+	addInstance(instanceData{"INST", "VM", true, "ADDR", 0})
+	aliases.AddAlias("BEACON", "ADDR")
+
+	AddInstanceReferenceFunc(func([]string) []string {
+		return []string{"APP"}
+	})
+
+	// Modify is not enough
+	setupBeaconPermissions("ADDR", auth.ModifyAuthLevel)
+	w := runHandlerTest("DELETE", "/BEACON", nil, "/{Beacon}", handleDeleteBeacon)
+	assert.Equal(t, 403, w.Code)
+
+	setupBeaconPermissions("ADDR", auth.OwnerAuthLevel)
+
+	// Still used by an application
+	w = runHandlerTest("DELETE", "/BEACON", nil, "/{Beacon}", handleDeleteBeacon)
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), "APP")
+	assert.True(t, beaconExists("ADDR"))
+
+	w = runHandlerTest("DELETE", "/BEACON?force=true", nil, "/{Beacon}", handleDeleteBeacon)
+	assert.Equal(t, 200, w.Code)
+
+	var report DeleteReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+
+	assert.Equal(t, DeleteReport{"ADDR", []string{"INST"}, []string{"APP"}}, report)
+	assert.False(t, beaconExists("ADDR"))
+
+	// Gone along with the owner's permission
+	w = runHandlerTest("DELETE", "/ADDR", nil, "/{Beacon}", handleDeleteBeacon)
+	assert.Equal(t, 403, w.Code)
+}
+
+func Test_HandleDeleteBeacon_Unknown(t *testing.T) {
+	setup()
+	defer teardown()
+
+	setupBeaconPermissions("ADDR", auth.OwnerAuthLevel)
+
+	w := runHandlerTest("DELETE", "/ADDR", nil, "/{Beacon}", handleDeleteBeacon)
+	assert.Equal(t, 404, w.Code)
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/beacons/aliases"
 	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/transport"
 )
 
 func beaconExists(beacon string) bool {
@@ -44,12 +45,35 @@ func instanceExists(instance string) bool {
 	return err != databases.NoRowsError
 }
 
-func addBeacon(beacon beaconData) error {
-	entry := map[string]interface{}{
-		"Address": beacon.Address,
-		"Token":   beacon.Token,
+/*
+   Aliases of beacons and their instances follow the permissions of
+   the beacon. Any other address, e.g. a direct Docker host, keeps the
+   default of only letting admins change its alias.
+*/
+func canAccessAliasOf(user *auth.User, address string, modify bool) bool {
+	beacon := address
+
+	if !beaconExists(address) {
+		instance, err := getInstanceData(address)
+		if err != nil {
+			return !modify || user.AuthLevel >= auth.CreateUserAuthLevel
+		}
+		beacon = instance.BeaconAddress
 	}
 
+	if modify {
+		return user.CanModifyBeacon(beacon)
+	}
+	return user.CanAccessBeacon(beacon)
+}
+
+func addBeacon(beacon beaconData) error {
+	entry := tlsEntry(beacon.TLSSettings)
+	entry["Address"] = beacon.Address
+	entry["Token"] = beacon.Token
+	entry["PendingToken"] = beacon.PendingToken
+	entry["TokenRotatedAt"] = beacon.TokenRotatedAt
+
 	err := beacons.Insert(entry)
 	return err
 }
@@ -65,6 +89,7 @@ func addInstance(instance instanceData) error {
 		"Name":            instance.Name,
 		"CanAccessDocker": instance.CanAccessDocker,
 		"BeaconAddress":   instance.BeaconAddress,
+		"StaleSince":      instance.StaleSince,
 	}
 
 	err := instances.Insert(entry)
@@ -76,6 +101,7 @@ func updateInstance(instance instanceData) error {
 		"Name":            instance.Name,
 		"CanAccessDocker": instance.CanAccessDocker,
 		"BeaconAddress":   instance.BeaconAddress,
+		"StaleSince":      instance.StaleSince,
 	}
 
 	where := map[string]interface{}{"InstanceAddress": instance.InstanceAddress}
@@ -90,6 +116,14 @@ func updateBeaconField(field string, val interface{}, beacon string) error {
 	return beacons.Update(to, where)
 }
 
+func getInstanceData(instance string) (instanceData, error) {
+	var data instanceData
+	where := databases.Filter{"InstanceAddress": instance}
+
+	err := instances.SelectRow(nil, where, nil, &data)
+	return data, err
+}
+
 func getBeaconData(beacon string) (beaconData, error) {
 	var data beaconData
 	where := databases.Filter{"Address": beacon}
@@ -111,7 +145,9 @@ func getBeaconsList(user *auth.User) ([]aliases.Alias, error) {
 		return nil, err
 	}
 
-	beacons := make([]aliases.Alias, 0)
+	defer scanner.Close()
+
+	addresses := make([]string, 0)
 	seenBeacons := make(map[string]bool)
 	var beacon beaconData
 
@@ -119,21 +155,48 @@ func getBeaconsList(user *auth.User) ([]aliases.Alias, error) {
 		scanner.Scan(&beacon)
 
 		if user.CanAccessBeacon(beacon.Address) {
-			address := beacon.Address
-			alias, _ := aliases.GetAliasOf(address)
-
-			data := aliases.Alias{Alias: alias, Address: address}
-
-			if _, found := seenBeacons[address]; !found {
-				beacons = append(beacons, data)
-				seenBeacons[address] = true
+			if _, found := seenBeacons[beacon.Address]; !found {
+				addresses = append(addresses, beacon.Address)
+				seenBeacons[beacon.Address] = true
 			}
 		}
 	}
 
+	aliasOf, err := aliases.GetAliasesOf(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	beacons := make([]aliases.Alias, len(addresses))
+
+	for i, address := range addresses {
+		beacons[i] = aliases.Alias{Alias: aliasOf[address], Address: address}
+	}
+
 	return beacons, nil
 }
 
+type beaconListing struct {
+	aliases.Alias
+	Health BeaconStatus
+}
+
+func withBeaconStatus(list []aliases.Alias) []beaconListing {
+	listings := make([]beaconListing, len(list))
+
+	for i, beacon := range list {
+		listings[i] = beaconListing{beacon, GetBeaconStatus(beacon.Address)}
+	}
+
+	return listings
+}
+
+/*
+   Listings are served from the listing cache when possible. With
+   refresh the beacon is synced first, waiting for a refresh slot like
+   RefreshBeacons does. The returned listings are shared with the cache
+   and must not be modified.
+*/
 func getInstancesList(beacon string, user *auth.User, refresh bool) ([]map[string]interface{}, error) {
 	if !user.CanAccessBeacon(beacon) {
 		return make([]map[string]interface{}, 0), nil
@@ -145,7 +208,11 @@ func getInstancesList(beacon string, user *auth.User, refresh bool) ([]map[strin
 	}
 
 	if refresh {
+		release := acquireRefreshSlot()
 		refreshVMListOf(data)
+		release()
+	} else if cached, ok := getCachedListings(beacon); ok {
+		return cached, nil
 	}
 
 	opts := databases.SelectOptions{Distinct: true}
@@ -158,7 +225,7 @@ func getInstancesList(beacon string, user *auth.User, refresh bool) ([]map[strin
 
 	defer scanner.Close()
 
-	instances := make([]map[string]interface{}, 0)
+	list := make([]instanceData, 0)
 	InstanceAddress := make(map[string]bool)
 
 	for scanner.Next() {
@@ -166,81 +233,339 @@ func getInstancesList(beacon string, user *auth.User, refresh bool) ([]map[strin
 		scanner.Scan(&instance)
 
 		address := instance.InstanceAddress
-		alias, _ := aliases.GetAliasOf(address)
 
 		if _, found := InstanceAddress[address]; !found {
-
-			instances = append(instances, map[string]interface{}{
-				"Alias":           alias,
-				"InstanceAddress": instance.InstanceAddress,
-				"Name":            instance.Name,
-				"CanAccessDocker": instance.CanAccessDocker,
-				"BeaconAddress":   instance.BeaconAddress,
-			})
-
+			list = append(list, instance)
 			InstanceAddress[address] = true
 		}
 	}
 
+	listing, err := loadListingData(list)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]map[string]interface{}, len(list))
+
+	for i, instance := range list {
+		instances[i] = listing.of(instance)
+	}
+
+	cacheListings(beacon, instances)
+
 	return instances, nil
 }
 
-func refreshVMListOf(beacon beaconData) error {
-	vmsTarget := fmt.Sprintf("http://%s/vms", beacon.Address)
+/*
+   The aliases and labels of the instances being listed, loaded up
+   front so that listing many instances does not query them once per
+   instance.
+*/
+type listingData struct {
+	aliases map[string]string
+	labels  map[string]map[string]string
+}
+
+func loadListingData(list []instanceData) (listingData, error) {
+	addresses := make([]string, len(list))
+	for i, instance := range list {
+		addresses[i] = instance.InstanceAddress
+	}
+
+	aliasOf, err := aliases.GetAliasesOf(addresses)
+	if err != nil {
+		return listingData{}, err
+	}
+
+	labelsOf, err := getLabelsOfInstances(addresses)
+	if err != nil {
+		return listingData{}, err
+	}
+
+	return listingData{aliasOf, labelsOf}, nil
+}
+
+func (this listingData) of(instance instanceData) map[string]interface{} {
+	labels, ok := this.labels[instance.InstanceAddress]
+	if !ok || labels == nil {
+		labels = map[string]string{}
+	}
+
+	return map[string]interface{}{
+		"Alias":           this.aliases[instance.InstanceAddress],
+		"InstanceAddress": instance.InstanceAddress,
+		"Name":            instance.Name,
+		"CanAccessDocker": instance.CanAccessDocker,
+		"BeaconAddress":   instance.BeaconAddress,
+		"StaleSince":      instance.StaleSince,
+		"Labels":          labels,
+	}
+}
+
+/*
+   A VM as reported by a beacon's /vms endpoint. Beacons may attach
+   Labels to each VM, which become the instance's beacon labels.
+*/
+type vmListing struct {
+	structs.VM
+	Labels map[string]string
+}
+
+/*
+   Requests the list of VMs from the beacon's /vms endpoint. The outcome
+   of the request is recorded as the beacon's current health.
+
+   During a token rotation the pending token is tried when the current
+   one is rejected, and promoted if the beacon accepts it.
+*/
+func requestVMList(beacon beaconData) ([]vmListing, error) {
+	start := time.Now()
+
+	vms, err := doVMListRequest(beacon, beacon.Token)
+
+	if err == TokenRejectedError && beacon.PendingToken != "" {
+		vms, err = doVMListRequest(beacon, beacon.PendingToken)
+
+		if err == nil {
+			promotePendingToken(beacon.Address, beacon.PendingToken)
+		}
+	}
+
+	recordBeaconStatus(beacon.Address, time.Since(start), err)
+
+	return vms, err
+}
+
+func doVMListRequest(beacon beaconData, token string) ([]vmListing, error) {
+	vmsTarget := fmt.Sprintf("%s://%s/vms", beacon.Scheme(), beacon.Address)
 
 	req, err := http.NewRequest("GET", vmsTarget, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Assuming user has permission to access token since they provided it
-	req.Header.Set(HEADER_TOKEN_KEY, beacon.Token)
+	req.Header.Set(HEADER_TOKEN_KEY, token)
 
-	client := http.Client{
-		Timeout: time.Duration(15 * time.Second),
+	client, err := transport.NewClient(beacon.TLSSettings, 15*time.Second)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
 	vmsBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if IsTokenRejection(resp.StatusCode) {
+		return nil, TokenRejectedError
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		err = errors.New(string(vmsBody))
-		return err
+		return nil, errors.New(string(vmsBody))
 	}
 
-	var vms []structs.VM
+	var vms []vmListing
 
 	err = json.Unmarshal(vmsBody, &vms)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	beaconName, _ := aliases.GetAliasOf(beacon.Address)
+	return vms, nil
+}
+
+func refreshVMListOf(beacon beaconData) error {
+	_, err := syncVMListOf(beacon)
+	return err
+}
+
+/*
+   Updates the instances of the beacon to match its VM list. Instances
+   which the beacon no longer reports are marked as stale rather than
+   removed, see pruneStaleInstances.
+
+   Concurrent syncs of the same beacon share a single request to it.
+
+   RETURN: The addresses of all stale instances of the beacon
+*/
+func syncVMListOf(beacon beaconData) ([]string, error) {
+	stale, err := syncs.do(beacon.Address, func() ([]string, error) {
+		return doSyncVMListOf(beacon)
+	})
+
+	invalidateListings(beacon.Address)
+
+	return stale, err
+}
+
+func doSyncVMListOf(beacon beaconData) ([]string, error) {
+	vms, err := requestVMList(beacon)
+	if err != nil {
+		return nil, err
+	}
+
+	reported := make(map[string]bool)
 
 	for _, vm := range vms {
 		instanceAddr := fmt.Sprintf("%s:%s/%s", vm.Address, vm.Port, vm.Version)
-		instance := instanceData{instanceAddr, vm.Name, vm.CanAccessDocker, beacon.Address}
+		instance := instanceData{instanceAddr, vm.Name, vm.CanAccessDocker, beacon.Address, 0}
+
+		existing, err := getInstanceData(instance.InstanceAddress)
+		if err == databases.NoRowsError {
+			if addInstance(instance) == nil {
+				publishInstanceEvent(EventInstanceAdded, instance)
+			}
+		} else if existing != instance {
+			if updateInstance(instance) == nil {
+				publishInstanceEvent(EventInstanceUpdated, instance)
+			}
+		}
+
+		aliases.SetNamespacedAlias(vm.Name, instance.InstanceAddress, beacon.Address)
+
+		setInstanceLabels(instanceAddr, LabelSourceBeacon, vm.Labels)
+
+		reported[instanceAddr] = true
+	}
+
+	known, err := getInstancesOf(beacon.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := make([]string, 0)
+	now := time.Now().Unix()
+
+	for _, instance := range known {
+		if reported[instance.InstanceAddress] {
+			continue
+		}
+
+		if instance.StaleSince == 0 {
+			instance.StaleSince = now
+			if updateInstance(instance) == nil {
+				publishInstanceEvent(EventInstanceUpdated, instance)
+			}
+		}
+
+		stale = append(stale, instance.InstanceAddress)
+	}
+
+	return stale, nil
+}
+
+func getInstancesOf(beacon string) ([]instanceData, error) {
+	where := databases.Filter{"BeaconAddress": beacon}
+
+	scanner, err := instances.Select(nil, where, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]instanceData, 0)
+
+	for scanner.Next() {
+		var instance instanceData
+		scanner.Scan(&instance)
+		list = append(list, instance)
+	}
+
+	return list, nil
+}
+
+/*
+   Deletes the instances of the beacon which have been stale for at
+   least the grace period, along with their aliases.
 
-		if !instanceExists(instance.InstanceAddress) {
-			addInstance(instance)
-		} else {
-			updateInstance(instance)
+   RETURN: The addresses of the deleted instances
+*/
+func pruneStaleInstances(beacon string, grace time.Duration) ([]string, error) {
+	known, err := getInstancesOf(beacon)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	cutoff := time.Now().Add(-grace).Unix()
+
+	for _, instance := range known {
+		if instance.StaleSince == 0 || instance.StaleSince > cutoff {
+			continue
 		}
 
-		aliases.SetAlias(
-			fmt.Sprintf("%s%s%s", beaconName, INSTANCE_ALIAS_DELIM, vm.Name),
-			instance.InstanceAddress,
-		)
+		where := databases.Filter{"InstanceAddress": instance.InstanceAddress}
+		if err := instances.Delete(where); err != nil {
+			continue
+		}
+
+		aliases.RemoveAlias(instance.InstanceAddress)
+		removeInstanceLabels(instance.InstanceAddress)
+		removed = append(removed, instance.InstanceAddress)
+
+		publishInstanceEvent(EventInstanceRemoved, instance)
+	}
+
+	if len(removed) > 0 {
+		invalidateListings(beacon)
 	}
 
-	return nil
+	return removed, nil
+}
+
+/*
+   Removes the beacon along with all of its instances, their aliases,
+   and every permission entry for it. If any application still uses
+   one of its instances, nothing is removed unless force is set.
+
+   RETURN: A report of the removed instances and the applications
+           which referenced them
+*/
+func deleteBeacon(address string, force bool) (DeleteReport, error) {
+	report := DeleteReport{Beacon: address}
+
+	known, err := getInstancesOf(address)
+	if err != nil {
+		return report, err
+	}
+
+	report.Instances = make([]string, 0, len(known))
+	for _, instance := range known {
+		report.Instances = append(report.Instances, instance.InstanceAddress)
+	}
+
+	report.Applications = findInstanceReferences(report.Instances)
+
+	if len(report.Applications) > 0 && !force {
+		return report, BeaconInUseError
+	}
+
+	err = instances.Delete(databases.Filter{"BeaconAddress": address})
+	if err != nil && err != databases.NoUpdateError {
+		return report, err
+	}
+
+	for _, instance := range known {
+		aliases.RemoveAlias(instance.InstanceAddress)
+		removeInstanceLabels(instance.InstanceAddress)
+		publishInstanceEvent(EventInstanceRemoved, instance)
+	}
+
+	err = removeBeacon(address)
+	if err != nil {
+		return report, err
+	}
+
+	aliases.RemoveAlias(address)
+	forgetBeaconStatus(address)
+	invalidateListings(address)
+
+	return report, auth.RemovePermissionFromAll("Beacons", address)
 }
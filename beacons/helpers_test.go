@@ -80,7 +80,7 @@ func Test_AddBeaconData_New(t *testing.T) {
 	defer teardown()
 
 	testBeaconData := beaconData{
-		"BEACON_ADDR", "TOKEN",
+		Address: "BEACON_ADDR", Token: "TOKEN",
 	}
 
 	addBeacon(testBeaconData)
@@ -96,7 +96,7 @@ func Test_AddBeaconData_Dup(t *testing.T) {
 	defer teardown()
 
 	testBeaconData := beaconData{
-		"BEACON_ADDR", "TOKEN",
+		Address: "BEACON_ADDR", Token: "TOKEN",
 	}
 
 	addBeacon(testBeaconData)
@@ -192,7 +192,7 @@ func Test_UpdateInstanceData(t *testing.T) {
 	instances.Insert(testInstanceData)
 
 	keyInstance := instanceData{
-		"INST_ADDR", "NAME_PASS", true, "BEACON_PASS",
+		"INST_ADDR", "NAME_PASS", true, "BEACON_PASS", 0,
 	}
 
 	var result instanceData
@@ -217,7 +217,7 @@ func Test_GetBeaconData_Found(t *testing.T) {
 
 	assert.Nil(t, err, "getBeaconData should not return error beacon was found")
 
-	key := beaconData{"BEACON_ADDR", "TOKEN"}
+	key := beaconData{Address: "BEACON_ADDR", Token: "TOKEN"}
 	assert.Equal(t, key, res,
 		"getBeaconData should give correct beaconData")
 }
@@ -328,6 +328,8 @@ func Test_ListInstances_ValidUser(t *testing.T) {
 		instances.Insert(newInstance)
 
 		newInstance["Alias"] = ""
+		newInstance["StaleSince"] = int64(0)
+		newInstance["Labels"] = map[string]string{}
 		keyList = append(keyList, newInstance)
 	}
 
@@ -418,6 +420,7 @@ func Test_RefreshVMListOf_Valid(t *testing.T) {
 		vm.Name,
 		vm.CanAccessDocker,
 		data.Address,
+		0,
 	}
 
 	var inst instanceData
@@ -509,6 +512,7 @@ func Test_RefreshVMListOf_Update(t *testing.T) {
 		vm.Name,
 		vm.CanAccessDocker,
 		data.Address,
+		0,
 	}
 
 	var inst instanceData
@@ -516,3 +520,98 @@ func Test_RefreshVMListOf_Update(t *testing.T) {
 
 	assert.Equal(t, key, inst)
 }
+
+func Test_DeleteBeacon(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addBeacon(beaconData{Address: "ADDR", Token: "TOKEN"})
+	addBeacon(beaconData{Address: "OTHER", Token: "TOKEN"})
+	addInstance(instanceData{"INST", "VM", true, "ADDR", 0})
+	addInstance(instanceData{"OTHER_INST", "VM", true, "OTHER", 0})
+
+	aliases.AddAlias("BEACON", "ADDR")
+	aliases.AddAlias("BEACON.VM", "INST")
+	recordBeaconStatus("ADDR", 0, nil)
+
+	auth.CreateUser("OWNER", "", "")
+	owner, _ := auth.GetUser("OWNER")
+	auth.SetUserBeaconAuthLevel(owner, "ADDR", auth.OwnerAuthLevel)
+	auth.SetUserBeaconAuthLevel(owner, "OTHER", auth.OwnerAuthLevel)
+
+	report, err := deleteBeacon("ADDR", false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"INST"}, report.Instances)
+	assert.Equal(t, []string{}, report.Applications)
+
+	assert.False(t, beaconExists("ADDR"))
+	assert.False(t, instanceExists("INST"))
+	assert.True(t, beaconExists("OTHER"))
+	assert.True(t, instanceExists("OTHER_INST"))
+
+	_, err = aliases.GetAddressOf("BEACON")
+	assert.NotNil(t, err)
+	_, err = aliases.GetAddressOf("BEACON.VM")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, BeaconStatusUnknown, GetBeaconStatus("ADDR").Status)
+
+	owner, _ = auth.GetUser("OWNER")
+	assert.Equal(t, -1, owner.GetAuthLevel("Beacons", "ADDR"))
+	assert.Equal(t, auth.OwnerAuthLevel, owner.GetAuthLevel("Beacons", "OTHER"))
+}
+
+func Test_DeleteBeacon_InUse(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addBeacon(beaconData{Address: "ADDR", Token: "TOKEN"})
+	addInstance(instanceData{"INST", "VM", true, "ADDR", 0})
+
+	AddInstanceReferenceFunc(func(instances []string) []string {
+		return []string{"APP"}
+	})
+
+	report, err := deleteBeacon("ADDR", false)
+
+	assert.Equal(t, BeaconInUseError, err)
+	assert.Equal(t, []string{"APP"}, report.Applications)
+	assert.True(t, beaconExists("ADDR"))
+	assert.True(t, instanceExists("INST"))
+
+	_, err = deleteBeacon("ADDR", true)
+
+	assert.Nil(t, err)
+	assert.False(t, beaconExists("ADDR"))
+	assert.False(t, instanceExists("INST"))
+}
+
+func Test_CanAccessAliasOf(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addBeacon(beaconData{Address: "BEACON", Token: "TOKEN"})
+	addInstance(instanceData{"INST", "VM", true, "BEACON", 0})
+
+	setupBeaconPermissions("BEACON", auth.AccessAuthLevel)
+	user, _ := auth.GetUser("USER")
+
+	assert.True(t, canAccessAliasOf(user, "BEACON", false))
+	assert.False(t, canAccessAliasOf(user, "BEACON", true))
+	assert.True(t, canAccessAliasOf(user, "INST", false))
+	assert.False(t, canAccessAliasOf(user, "INST", true))
+
+	setupBeaconPermissions("BEACON", auth.ModifyAuthLevel)
+	user, _ = auth.GetUser("USER")
+
+	assert.True(t, canAccessAliasOf(user, "BEACON", true))
+	assert.True(t, canAccessAliasOf(user, "INST", true))
+
+	// Other addresses may only be changed by admins
+	assert.True(t, canAccessAliasOf(user, "DOCKER_HOST", false))
+	assert.False(t, canAccessAliasOf(user, "DOCKER_HOST", true))
+
+	user.AuthLevel = auth.CreateUserAuthLevel
+	assert.True(t, canAccessAliasOf(user, "DOCKER_HOST", true))
+}
@@ -0,0 +1,304 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/transport"
+)
+
+var (
+	UnknownHostError           = errors.New("beacons: unknown Docker host")
+	HostPermissionError        = errors.New("beacons: user not permitted to access Docker host")
+	HostNotAllowedError        = errors.New("beacons: Docker host is not in the allowlist")
+	InvalidAllowlistEntryError = errors.New("beacons: allowlist entries must be an IP, a CIDR or a hostname")
+)
+
+/*
+   Direct Docker hosts may only be reached at addresses an admin has
+   allowed. Entries are IPs, CIDRs (e.g. "10.1.0.0/16") or hostnames,
+   which may use '*' as in permission keys (e.g. "*.docker.internal").
+   A host allowed by name is trusted to resolve somewhere safe, any
+   other host is only reached if every address it resolves to is
+   allowed.
+*/
+var allowlist databases.TableInterface
+
+var allowlistSchema = databases.Schema{
+	"Entry":   "text UNIQUE PRIMARY KEY",
+	"Creator": "text",
+}
+
+type allowlistEntry struct {
+	Entry   string
+	Creator string
+}
+
+var validHostnameEntry = regexp.MustCompile(`^[A-Za-z0-9*.-]+$`)
+
+func validateAllowlistEntry(entry string) error {
+	if net.ParseIP(entry) != nil {
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return nil
+	}
+
+	if !validHostnameEntry.MatchString(entry) || auth.ValidatePermissionKey(entry) != nil {
+		return InvalidAllowlistEntryError
+	}
+
+	return nil
+}
+
+func getAllowlist() ([]allowlistEntry, error) {
+	scanner, err := allowlist.Select(nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	entries := make([]allowlistEntry, 0)
+
+	for scanner.Next() {
+		var entry allowlistEntry
+		scanner.Scan(&entry)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+/*
+   Checks the host part of a direct host's address against the
+   allowlist, resolving it if it is not allowed by name.
+
+   RETURN: The addresses the host may be reached at, empty if it was
+           allowed by name and should be dialed as is
+*/
+func checkAllowlist(ctx context.Context, host string) ([]net.IP, error) {
+	entries, err := getAllowlist()
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make([]*net.IPNet, 0)
+
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry.Entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else if _, network, err := net.ParseCIDR(entry.Entry); err == nil {
+			networks = append(networks, network)
+		} else if net.ParseIP(host) == nil &&
+			auth.MatchPermissionKey(strings.ToLower(entry.Entry), strings.ToLower(host)) {
+			return nil, nil
+		}
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		allowed := false
+		for _, network := range networks {
+			allowed = allowed || network.Contains(ip)
+		}
+
+		if !allowed {
+			return nil, HostNotAllowedError
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, HostNotAllowedError
+	}
+
+	return ips, nil
+}
+
+/*
+   Opens the connections of direct Docker hosts, checking the allowlist
+   on every dial so that a host cannot later be pointed elsewhere. A
+   host allowed by its addresses is dialed at the first of those which
+   were checked rather than being resolved again.
+*/
+func dialDirectHost(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := checkAllowlist(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+
+	if len(ips) == 0 {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+}
+
+var directHostDialer = transport.NewDialer(dialDirectHost)
+
+func hostHostname(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func hostRegistered(address string) bool {
+	var host hostData
+	return hosts.SelectRow([]string{"Address"}, databases.Filter{"Address": address}, nil, &host) == nil
+}
+
+/*
+   Checks that the user may send Docker requests directly to the given
+   host. Only registered hosts may be used, the allowlist is checked
+   when connecting.
+*/
+func CheckDirectHost(user *auth.User, address string) error {
+	if !hostRegistered(address) {
+		return UnknownHostError
+	}
+
+	if user == nil || !user.CanAccessHost(address) {
+		return HostPermissionError
+	}
+
+	return nil
+}
+
+/*
+   RETURN: The user's level on the beacon the Docker host is behind, or
+           on the host itself if it is reached directly
+*/
+func GetDockerAuthLevel(user *auth.User, host string) int {
+	if beacon, err := GetBeaconAddress(host); err == nil {
+		return user.GetAuthLevel("Beacons", beacon)
+	}
+
+	return user.GetAuthLevel("Hosts", host)
+}
+
+func handleListHosts(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	scanner, err := hosts.Select([]string{"Address"}, nil, nil)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	defer scanner.Close()
+
+	list := make([]string, 0)
+
+	for scanner.Next() {
+		var host hostData
+		scanner.Scan(&host)
+
+		if user.CanAccessHost(host.Address) {
+			list = append(list, host.Address)
+		}
+	}
+
+	output, _ := json.Marshal(list)
+	fmt.Fprint(w, string(output))
+}
+
+func handleListAllowlist(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	if user.AuthLevel < auth.CreateUserAuthLevel {
+		writeResponse(HostPermissionError, w)
+		return
+	}
+
+	entries, err := getAllowlist()
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	output, _ := json.Marshal(entries)
+	fmt.Fprint(w, string(output))
+}
+
+func addAllowlistEntry(user *auth.User, entry string) error {
+	if user.AuthLevel < auth.CreateUserAuthLevel {
+		return HostPermissionError
+	}
+
+	if err := validateAllowlistEntry(entry); err != nil {
+		return err
+	}
+
+	return allowlist.Insert(map[string]interface{}{
+		"Entry":   entry,
+		"Creator": user.Email,
+	})
+}
+
+func handleAddAllowlistEntry(w http.ResponseWriter, r *http.Request) {
+	entry := mux.Vars(r)["Entry"]
+	user := auth.GetCurrentUser(r)
+
+	writeResponse(addAllowlistEntry(user, entry), w)
+}
+
+func handleRemoveAllowlistEntry(w http.ResponseWriter, r *http.Request) {
+	entry := mux.Vars(r)["Entry"]
+	user := auth.GetCurrentUser(r)
+
+	if user.AuthLevel < auth.CreateUserAuthLevel {
+		writeResponse(HostPermissionError, w)
+		return
+	}
+
+	writeResponse(allowlist.Delete(databases.Filter{"Entry": entry}), w)
+}
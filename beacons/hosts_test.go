@@ -0,0 +1,232 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/transport"
+)
+
+func setupAdmin() *auth.User {
+	auth.CreateUser("ADMIN", "", "")
+	admin, _ := auth.GetUser("ADMIN")
+	admin.AuthLevel = auth.CreateUserAuthLevel
+	return admin
+}
+
+func Test_ValidateAllowlistEntry(t *testing.T) {
+	valid := []string{"10.0.0.1", "10.0.0.0/8", "fd00::/8", "docker.internal", "*.example.com"}
+	for _, entry := range valid {
+		assert.Nil(t, validateAllowlistEntry(entry), entry)
+	}
+
+	invalid := []string{"", "10.0.0.0/33", "host:2375", "**.example.com", "http://host"}
+	for _, entry := range invalid {
+		assert.Equal(t, InvalidAllowlistEntryError, validateAllowlistEntry(entry), entry)
+	}
+}
+
+func Test_CheckAllowlist(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := setupAdmin()
+
+	ctx := context.Background()
+
+	_, err := checkAllowlist(ctx, "127.0.0.1")
+	assert.Equal(t, HostNotAllowedError, err)
+
+	addAllowlistEntry(admin, "10.0.0.0/8")
+	addAllowlistEntry(admin, "127.0.0.1")
+	addAllowlistEntry(admin, "*.Docker.Example.com")
+
+	ips, err := checkAllowlist(ctx, "10.1.2.3")
+	assert.Nil(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.1.2.3")}, ips)
+
+	ips, err = checkAllowlist(ctx, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ips))
+
+	_, err = checkAllowlist(ctx, "127.0.0.2")
+	assert.Equal(t, HostNotAllowedError, err)
+
+	_, err = checkAllowlist(ctx, "::1")
+	assert.Equal(t, HostNotAllowedError, err)
+
+	// Hostnames allowed by name are not resolved
+	ips, err = checkAllowlist(ctx, "a.docker.example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, ips)
+
+	entries, _ := getAllowlist()
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "ADMIN", entries[0].Creator)
+}
+
+func Test_AddAllowlistEntry_Unauthorized(t *testing.T) {
+	setup()
+	defer teardown()
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+
+	assert.Equal(t, HostPermissionError, addAllowlistEntry(user, "0.0.0.0/0"))
+
+	w := runHandlerTest("GET", "/allowlist", nil, "/allowlist", handleListAllowlist)
+	assert.Equal(t, 403, w.Code)
+
+	w = runHandlerTest("DELETE", "/allowlist/10.0.0.0/8", nil, "/allowlist/{Entry:.*}", handleRemoveAllowlistEntry)
+	assert.Equal(t, 403, w.Code)
+}
+
+func Test_CheckDirectHost(t *testing.T) {
+	setup()
+	defer teardown()
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+
+	assert.Equal(t, UnknownHostError, CheckDirectHost(user, "HOST:2375"))
+
+	SetHostTLS("HOST:2375", transport.TLSSettings{})
+	assert.Equal(t, HostPermissionError, CheckDirectHost(user, "HOST:2375"))
+	assert.Equal(t, HostPermissionError, CheckDirectHost(nil, "HOST:2375"))
+
+	auth.SetUserHostAuthLevel(user, "HOST:*", auth.AccessAuthLevel)
+	assert.Nil(t, CheckDirectHost(user, "HOST:2375"))
+}
+
+func Test_GetDockerAuthLevel(t *testing.T) {
+	setup()
+	defer teardown()
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+
+	addInstance(instanceData{InstanceAddress: "INST", BeaconAddress: "BEACON"})
+	auth.SetUserBeaconAuthLevel(user, "BEACON", auth.ModifyAuthLevel)
+	auth.SetUserHostAuthLevel(user, "HOST", auth.OwnerAuthLevel)
+
+	assert.Equal(t, auth.ModifyAuthLevel, GetDockerAuthLevel(user, "INST"))
+	assert.Equal(t, auth.OwnerAuthLevel, GetDockerAuthLevel(user, "HOST"))
+	assert.Equal(t, -1, GetDockerAuthLevel(user, "OTHER"))
+}
+
+func Test_UpdateHost(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := setupAdmin()
+	ctx := context.Background()
+	settings := transport.TLSSettings{UseTLS: true, ServerName: "NAME"}
+
+	// Hosts can only be registered at allowed addresses
+	err := updateHost(ctx, admin, "10.0.0.1:2375", settings)
+	assert.Equal(t, HostNotAllowedError, err)
+	assert.False(t, hostRegistered("10.0.0.1:2375"))
+
+	addAllowlistEntry(admin, "10.0.0.0/8")
+
+	assert.Nil(t, updateHost(ctx, admin, "10.0.0.1:2375", settings))
+	assert.Equal(t, settings, GetTLSSettings("10.0.0.1:2375"))
+	assert.Equal(t, auth.OwnerAuthLevel, admin.GetAuthLevel("Hosts", "10.0.0.1:2375"))
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+
+	assert.Equal(t, HostPermissionError, updateHost(ctx, user, "10.0.0.2:2375", settings))
+	assert.Equal(t, HostPermissionError, updateHost(ctx, user, "10.0.0.1:2375", transport.TLSSettings{}))
+
+	// Users who may modify a registered host may change how it is reached
+	auth.SetUserHostAuthLevel(user, "10.0.0.1:2375", auth.ModifyAuthLevel)
+
+	assert.Nil(t, updateHost(ctx, user, "10.0.0.1:2375", transport.TLSSettings{}))
+	assert.Equal(t, transport.TLSSettings{}, GetTLSSettings("10.0.0.1:2375"))
+}
+
+func Test_RemoveHost(t *testing.T) {
+	setup()
+	defer teardown()
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+
+	SetHostTLS("HOST", transport.TLSSettings{})
+	auth.SetUserHostAuthLevel(user, "HOST", auth.ModifyAuthLevel)
+
+	assert.Equal(t, HostPermissionError, removeHost(user, "HOST"))
+
+	auth.SetUserHostAuthLevel(user, "HOST", auth.OwnerAuthLevel)
+
+	assert.Nil(t, removeHost(user, "HOST"))
+	assert.False(t, hostRegistered("HOST"))
+
+	user, _ = auth.GetUser("USER")
+	assert.Equal(t, -1, user.GetAuthLevel("Hosts", "HOST"))
+}
+
+func Test_HandleListHosts(t *testing.T) {
+	setup()
+	defer teardown()
+
+	SetHostTLS("HOST1", transport.TLSSettings{})
+	SetHostTLS("HOST2", transport.TLSSettings{})
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+	auth.SetUserHostAuthLevel(user, "HOST2", auth.AccessAuthLevel)
+
+	w := runHandlerTest("GET", "/hosts", nil, "/hosts", handleListHosts)
+	assert.Equal(t, 200, w.Code)
+
+	var list []string
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, []string{"HOST2"}, list)
+}
+
+func Test_HTTPClientFor_DirectHost(t *testing.T) {
+	setup()
+	defer teardown()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	address := strings.TrimPrefix(s.URL, "http://")
+
+	client, _ := HTTPClientFor(address, 0)
+
+	_, err := client.Get(s.URL)
+	assert.True(t, errors.Is(err, HostNotAllowedError))
+
+	AddTestingHost(address)
+
+	resp, err := client.Get(s.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
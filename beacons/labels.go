@@ -0,0 +1,414 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+)
+
+const (
+	LabelSourceBeacon = "beacon"
+	LabelSourceUser   = "user"
+)
+
+var (
+	InvalidLabelError    = errors.New("beacons: label keys and values may only contain letters, numbers, '.', '_', '/' and '-'")
+	InvalidSelectorError = errors.New("beacons: invalid label selector")
+	UnknownInstanceError = errors.New("beacons: unknown instance")
+)
+
+var (
+	validLabelKey   = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	validLabelValue = regexp.MustCompile(`^[A-Za-z0-9._/-]*$`)
+)
+
+var labels databases.TableInterface
+
+/*
+   Labels are kept per source so that refreshing a beacon only
+   replaces the labels it reported, never those set by users.
+*/
+var labelSchema = databases.Schema{
+	"InstanceAddress": "text",
+	"Key":             "text",
+	"Value":           "text",
+	"Source":          "text",
+}
+
+type labelData struct {
+	InstanceAddress string
+	Key             string
+	Value           string
+	Source          string
+}
+
+/*
+   A parsed label selector such as "env=prod,region!=us-east,gpu".
+   Terms are ANDed together. Each term is one of key=value,
+   key!=value, key (the label exists) or !key (it does not).
+*/
+type Selector []selectorTerm
+
+type selectorTerm struct {
+	Key    string
+	Value  string
+	Negate bool
+	Exists bool
+}
+
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !validLabelKey.MatchString(key) || !validLabelValue.MatchString(value) {
+			return InvalidLabelError
+		}
+	}
+
+	return nil
+}
+
+/*
+   Gets the labels of the instance. Labels set by users take precedence
+   over those with the same key reported by the beacon.
+*/
+func GetInstanceLabels(instance string) (map[string]string, error) {
+	where := databases.Filter{"InstanceAddress": instance}
+
+	scanner, err := labels.Select(nil, where, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	userKeys := make(map[string]bool)
+
+	for scanner.Next() {
+		var label labelData
+		scanner.Scan(&label)
+
+		if label.Source == LabelSourceUser {
+			userKeys[label.Key] = true
+		} else if userKeys[label.Key] {
+			continue
+		}
+
+		result[label.Key] = label.Value
+	}
+
+	return result, nil
+}
+
+/*
+   RETURN: The labels of every instance which has any, keyed by
+           instance address, read with a single query
+*/
+func getAllInstanceLabels() (map[string]map[string]string, error) {
+	return selectInstanceLabels(nil)
+}
+
+/*
+   RETURN: The labels of each of the instances which has any, keyed by
+           instance address, read with a single query
+*/
+func getLabelsOfInstances(instances []string) (map[string]map[string]string, error) {
+	in := make(databases.In, len(instances))
+	for i, instance := range instances {
+		in[i] = instance
+	}
+
+	return selectInstanceLabels(databases.Filter{"InstanceAddress": in})
+}
+
+func selectInstanceLabels(where databases.Filter) (map[string]map[string]string, error) {
+	scanner, err := labels.Select(nil, where, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	all := make(map[string]map[string]string)
+	userKeys := make(map[string]bool)
+
+	for scanner.Next() {
+		var label labelData
+		scanner.Scan(&label)
+
+		instanceLabels, ok := all[label.InstanceAddress]
+		if !ok {
+			instanceLabels = make(map[string]string)
+			all[label.InstanceAddress] = instanceLabels
+		}
+
+		userKey := label.InstanceAddress + "\x00" + label.Key
+
+		if label.Source == LabelSourceUser {
+			userKeys[userKey] = true
+		} else if userKeys[userKey] {
+			continue
+		}
+
+		instanceLabels[label.Key] = label.Value
+	}
+
+	return all, nil
+}
+
+/*
+   Replaces all of the instance's labels from the given source.
+*/
+func setInstanceLabels(instance, source string, values map[string]string) error {
+	if err := ValidateLabels(values); err != nil {
+		return err
+	}
+
+	where := databases.Filter{"InstanceAddress": instance, "Source": source}
+	err := labels.Delete(where)
+	if err != nil && err != databases.NoUpdateError {
+		return err
+	}
+
+	for key, value := range values {
+		err = labels.Insert(map[string]interface{}{
+			"InstanceAddress": instance,
+			"Key":             key,
+			"Value":           value,
+			"Source":          source,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeInstanceLabels(instance string) error {
+	err := labels.Delete(databases.Filter{"InstanceAddress": instance})
+	if err == databases.NoUpdateError {
+		return nil
+	}
+
+	return err
+}
+
+func ParseSelector(selector string) (Selector, error) {
+	parsed := make(Selector, 0)
+
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var parsedTerm selectorTerm
+
+		if idx := strings.Index(term, "!="); idx >= 0 {
+			parsedTerm = selectorTerm{Key: term[:idx], Value: term[idx+2:], Negate: true}
+		} else if idx := strings.Index(term, "="); idx >= 0 {
+			parsedTerm = selectorTerm{Key: term[:idx], Value: term[idx+1:]}
+		} else if strings.HasPrefix(term, "!") {
+			parsedTerm = selectorTerm{Key: term[1:], Negate: true, Exists: true}
+		} else {
+			parsedTerm = selectorTerm{Key: term, Exists: true}
+		}
+
+		if !validLabelKey.MatchString(parsedTerm.Key) ||
+			!validLabelValue.MatchString(parsedTerm.Value) {
+			return nil, InvalidSelectorError
+		}
+
+		parsed = append(parsed, parsedTerm)
+	}
+
+	return parsed, nil
+}
+
+func (this Selector) Matches(labels map[string]string) bool {
+	for _, term := range this {
+		value, found := labels[term.Key]
+
+		var matched bool
+		if term.Exists {
+			matched = found
+		} else {
+			matched = found && value == term.Value
+		}
+
+		if matched == term.Negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RETURN: All instances whose labels match the selector, by address
+func matchInstances(selector Selector) ([]instanceData, error) {
+	scanner, err := instances.Select(nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	allLabels, err := getAllInstanceLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]instanceData, 0)
+
+	for scanner.Next() {
+		var instance instanceData
+		scanner.Scan(&instance)
+
+		if selector.Matches(allLabels[instance.InstanceAddress]) {
+			matches = append(matches, instance)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].InstanceAddress < matches[j].InstanceAddress
+	})
+
+	return matches, nil
+}
+
+/*
+   RETURN: The addresses of all instances whose labels match the
+           selector, sorted. An empty selector matches everything.
+*/
+func GetInstancesMatching(selector Selector) ([]string, error) {
+	return GetInstancesMatchingFor(nil, selector)
+}
+
+/*
+   Like GetInstancesMatching, but only instances of beacons the user
+   can access are matched. A nil user can access every beacon.
+*/
+func GetInstancesMatchingFor(user *auth.User, selector Selector) ([]string, error) {
+	matches, err := matchInstances(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(matches))
+
+	for _, instance := range matches {
+		if user == nil || user.CanAccessBeacon(instance.BeaconAddress) {
+			addresses = append(addresses, instance.InstanceAddress)
+		}
+	}
+
+	return addresses, nil
+}
+
+func findInstances(user *auth.User, selector Selector) ([]map[string]interface{}, error) {
+	matches, err := matchInstances(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	accessible := make([]instanceData, 0, len(matches))
+
+	for _, instance := range matches {
+		if user.CanAccessBeacon(instance.BeaconAddress) {
+			accessible = append(accessible, instance)
+		}
+	}
+
+	listing, err := loadListingData(accessible)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]map[string]interface{}, len(accessible))
+
+	for i, instance := range accessible {
+		list[i] = listing.of(instance)
+	}
+
+	return list, nil
+}
+
+func handleFindInstances(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	selector, err := ParseSelector(r.URL.Query().Get("selector"))
+
+	var list []map[string]interface{}
+	if err == nil {
+		list, err = findInstances(user, selector)
+	}
+
+	var output []byte
+	if err == nil {
+		output, err = json.Marshal(list)
+	}
+
+	if err != nil {
+		writeResponse(err, w)
+	} else {
+		fmt.Fprint(w, string(output))
+	}
+}
+
+func handleSetInstanceLabels(w http.ResponseWriter, r *http.Request) {
+	instance := getAddressOf(mux.Vars(r)["Instance"])
+	user := auth.GetCurrentUser(r)
+
+	beacon, err := GetBeaconAddress(instance)
+	if err != nil {
+		writeResponse(UnknownInstanceError, w)
+		return
+	}
+
+	if !user.CanModifyBeacon(beacon) {
+		writeResponse(BeaconPermissionError, w)
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	var values map[string]string
+
+	err = json.Unmarshal(reqBody, &values)
+	if err != nil {
+		writeResponse(NotEnoughParametersError, w)
+		return
+	}
+
+	err = setInstanceLabels(instance, LabelSourceUser, values)
+	invalidateListings(beacon)
+
+	writeResponse(err, w)
+}
@@ -0,0 +1,194 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lighthouse/beacon/structs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+)
+
+func Test_ParseSelector(t *testing.T) {
+	selector, err := ParseSelector("env=prod, region!=us-east,gpu,!spot")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Selector{
+		{Key: "env", Value: "prod"},
+		{Key: "region", Value: "us-east", Negate: true},
+		{Key: "gpu", Exists: true},
+		{Key: "spot", Negate: true, Exists: true},
+	}, selector)
+
+	selector, err = ParseSelector("")
+	assert.Nil(t, err)
+	assert.Equal(t, Selector{}, selector)
+
+	for _, bad := range []string{"=prod", "env=a b", "!", "env==prod"} {
+		_, err = ParseSelector(bad)
+		assert.Equal(t, InvalidSelectorError, err, bad)
+	}
+}
+
+func Test_SelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "region": "us-west", "gpu": ""}
+
+	matches := []string{"", "env=prod", "env=prod,region!=us-east", "gpu", "!spot"}
+	for _, s := range matches {
+		selector, _ := ParseSelector(s)
+		assert.True(t, selector.Matches(labels), s)
+	}
+
+	misses := []string{"env=dev", "region!=us-west", "spot", "!gpu", "env=prod,spot"}
+	for _, s := range misses {
+		selector, _ := ParseSelector(s)
+		assert.False(t, selector.Matches(labels), s)
+	}
+}
+
+func Test_InstanceLabels(t *testing.T) {
+	setup()
+	defer teardown()
+
+	setInstanceLabels("INST", LabelSourceBeacon, map[string]string{"env": "dev", "zone": "a"})
+	setInstanceLabels("INST", LabelSourceUser, map[string]string{"env": "prod"})
+
+	labels, err := GetInstanceLabels("INST")
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "zone": "a"}, labels)
+
+	// Beacon labels are replaced without touching user labels
+	setInstanceLabels("INST", LabelSourceBeacon, map[string]string{"zone": "b"})
+	labels, _ = GetInstanceLabels("INST")
+	assert.Equal(t, map[string]string{"env": "prod", "zone": "b"}, labels)
+
+	err = setInstanceLabels("INST", LabelSourceUser, map[string]string{"bad key": ""})
+	assert.Equal(t, InvalidLabelError, err)
+
+	removeInstanceLabels("INST")
+	labels, _ = GetInstanceLabels("INST")
+	assert.Equal(t, map[string]string{}, labels)
+}
+
+func Test_SyncVMListOf_Labels(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		vms, _ := json.Marshal([]vmListing{{
+			VM:     structs.VM{Name: "VM", Address: "VM_ADDR", Port: "1234", Version: "v1.12"},
+			Labels: map[string]string{"env": "prod"},
+		}})
+		w.Write(vms)
+	}
+	defer setupServer(&f).Close()
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+	addBeacon(beacon)
+
+	RefreshBeacon(beacon, time.Hour)
+
+	labels, _ := GetInstanceLabels("VM_ADDR:1234/v1.12")
+	assert.Equal(t, map[string]string{"env": "prod"}, labels)
+}
+
+func Test_GetInstancesMatching(t *testing.T) {
+	setup()
+	defer teardown()
+
+	AddTestingInstance("B", "BEACON", map[string]string{"env": "prod"})
+	AddTestingInstance("A", "BEACON", map[string]string{"env": "prod", "gpu": ""})
+	AddTestingInstance("C", "BEACON", map[string]string{"env": "dev"})
+
+	selector, _ := ParseSelector("env=prod")
+	matches, err := GetInstancesMatching(selector)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"A", "B"}, matches)
+
+	selector, _ = ParseSelector("gpu")
+	matches, _ = GetInstancesMatching(selector)
+	assert.Equal(t, []string{"A"}, matches)
+
+	AddTestingInstance("D", "OTHER", map[string]string{"env": "prod"})
+
+	auth.CreateUser("USER", "", "")
+	user, _ := auth.GetUser("USER")
+	auth.SetUserBeaconAuthLevel(user, "BEACON", auth.AccessAuthLevel)
+
+	selector, _ = ParseSelector("env=prod")
+	matches, _ = GetInstancesMatchingFor(user, selector)
+	assert.Equal(t, []string{"A", "B"}, matches)
+
+	matches, _ = GetInstancesMatching(selector)
+	assert.Equal(t, []string{"A", "B", "D"}, matches)
+}
+
+func Test_HandleFindInstances(t *testing.T) {
+	setup()
+	defer teardown()
+
+	AddTestingInstance("A", "ALLOWED", map[string]string{"env": "prod"})
+	AddTestingInstance("B", "HIDDEN", map[string]string{"env": "prod"})
+	AddTestingInstance("C", "ALLOWED", map[string]string{"env": "dev"})
+
+	setupBeaconPermissions("ALLOWED", auth.AccessAuthLevel)
+
+	w := runHandlerTest("GET", "/instances?selector=env=prod", nil, "/instances", handleFindInstances)
+	assert.Equal(t, 200, w.Code)
+
+	var list []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &list)
+
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "A", list[0]["InstanceAddress"])
+	assert.Equal(t, map[string]interface{}{"env": "prod"}, list[0]["Labels"])
+
+	w = runHandlerTest("GET", "/instances?selector=env==prod", nil, "/instances", handleFindInstances)
+	assert.Equal(t, 400, w.Code)
+}
+
+func Test_HandleSetInstanceLabels(t *testing.T) {
+	setup()
+	defer teardown()
+
+	labels := map[string]string{"env": "prod"}
+
+	w := runHandlerTest("PUT", "/INST", labels, "/{Instance}", handleSetInstanceLabels)
+	assert.Equal(t, 404, w.Code)
+
+	AddTestingInstance("INST", "BEACON", nil)
+
+	w = runHandlerTest("PUT", "/INST", labels, "/{Instance}", handleSetInstanceLabels)
+	assert.Equal(t, 403, w.Code)
+
+	setupBeaconPermissions("BEACON", auth.ModifyAuthLevel)
+
+	w = runHandlerTest("PUT", "/INST", map[string]string{"bad key": ""}, "/{Instance}", handleSetInstanceLabels)
+	assert.Equal(t, 400, w.Code)
+
+	w = runHandlerTest("PUT", "/INST", labels, "/{Instance}", handleSetInstanceLabels)
+	assert.Equal(t, 200, w.Code)
+
+	got, _ := GetInstanceLabels("INST")
+	assert.Equal(t, labels, got)
+}
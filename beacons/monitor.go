@@ -0,0 +1,169 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lighthouse/lighthouse/logging"
+)
+
+const (
+	BeaconStatusUnknown = "Unknown"
+	BeaconStatusUp      = "Up"
+	BeaconStatusDown    = "Down"
+)
+
+/*
+   Health of a beacon as of the last time it was contacted, either by
+   the monitor or by a refresh. LatencyMs is the round trip time of the
+   last /vms request and LastSeen is the last time it succeeded.
+*/
+type BeaconStatus struct {
+	Address   string
+	Status    string
+	LatencyMs int64
+	LastCheck time.Time
+	LastSeen  time.Time
+	LastError string
+}
+
+var (
+	statuses     = make(map[string]BeaconStatus)
+	statusesLock = sync.RWMutex{}
+)
+
+func recordBeaconStatus(address string, latency time.Duration, err error) {
+	statusesLock.Lock()
+
+	status, ok := statuses[address]
+	if !ok {
+		status = BeaconStatus{Address: address}
+	}
+
+	before := status.Status
+
+	status.LastCheck = time.Now()
+	status.LatencyMs = int64(latency / time.Millisecond)
+
+	if err == nil {
+		status.Status = BeaconStatusUp
+		status.LastSeen = status.LastCheck
+		status.LastError = ""
+	} else {
+		status.Status = BeaconStatusDown
+		status.LastError = err.Error()
+	}
+
+	statuses[address] = status
+	statusesLock.Unlock()
+
+	if status.Status != before {
+		eventType := EventBeaconUp
+		if status.Status == BeaconStatusDown {
+			eventType = EventBeaconDown
+		}
+
+		publishEvent(Event{Type: eventType, Beacon: address, Data: status})
+	}
+}
+
+func forgetBeaconStatus(address string) {
+	statusesLock.Lock()
+	defer statusesLock.Unlock()
+
+	delete(statuses, address)
+}
+
+/*
+   RETURN: The last recorded health of the beacon, with a status of
+           BeaconStatusUnknown if it has not been contacted yet
+*/
+func GetBeaconStatus(address string) BeaconStatus {
+	statusesLock.RLock()
+	defer statusesLock.RUnlock()
+
+	status, ok := statuses[address]
+	if !ok {
+		return BeaconStatus{Address: address, Status: BeaconStatusUnknown}
+	}
+
+	return status
+}
+
+func getAllBeaconData() ([]beaconData, error) {
+	scanner, err := beacons.Select(nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]beaconData, 0)
+
+	for scanner.Next() {
+		var beacon beaconData
+		scanner.Scan(&beacon)
+		list = append(list, beacon)
+	}
+
+	return list, nil
+}
+
+/*
+   Pings every known beacon concurrently and records its health,
+   contacting at most RefreshWorkers of them at a time along with any
+   refreshes. Blocks until all beacons have responded or timed out.
+*/
+func PollBeacons() error {
+	list, err := getAllBeaconData()
+	if err != nil {
+		return err
+	}
+
+	forEachBeacon(list, func(i int) {
+		beacon := list[i]
+
+		before := GetBeaconStatus(beacon.Address).Status
+		requestVMList(beacon)
+		after := GetBeaconStatus(beacon.Address).Status
+
+		if before != after {
+			logging.Info(fmt.Sprintf("beacon %s is %s", beacon.Address, after))
+		}
+	})
+
+	return nil
+}
+
+/*
+   Starts polling all beacons in the background every interval.
+   A non-positive interval disables the monitor.
+*/
+func StartMonitor(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			PollBeacons()
+			<-ticker.C
+		}
+	}()
+}
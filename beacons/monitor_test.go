@@ -0,0 +1,132 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+)
+
+func Test_GetBeaconStatus_Unknown(t *testing.T) {
+	setup()
+	defer teardown()
+
+	status := GetBeaconStatus("ADDR")
+
+	assert.Equal(t, "ADDR", status.Address)
+	assert.Equal(t, BeaconStatusUnknown, status.Status)
+}
+
+func Test_RecordBeaconStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	recordBeaconStatus("ADDR", 5*time.Millisecond, nil)
+	up := GetBeaconStatus("ADDR")
+
+	assert.Equal(t, BeaconStatusUp, up.Status)
+	assert.Equal(t, int64(5), up.LatencyMs)
+	assert.Equal(t, up.LastCheck, up.LastSeen)
+	assert.Equal(t, "", up.LastError)
+
+	recordBeaconStatus("ADDR", time.Millisecond, errors.New("ERROR"))
+	down := GetBeaconStatus("ADDR")
+
+	assert.Equal(t, BeaconStatusDown, down.Status)
+	assert.Equal(t, "ERROR", down.LastError)
+	assert.Equal(t, up.LastSeen, down.LastSeen, "LastSeen should not move on failure")
+}
+
+func Test_PollBeacons(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var gotToken string
+
+	vms := func(w http.ResponseWriter, r *http.Request) {
+		gotToken = r.Header.Get(HEADER_TOKEN_KEY)
+		fmt.Fprint(w, "[]")
+	}
+
+	defer setupServer(&vms).Close()
+
+	addBeacon(beaconData{Address: "localhost:8080", Token: "TOKEN"})
+	addBeacon(beaconData{Address: "BAD ADDRESS", Token: "TOKEN"})
+
+	PollBeacons()
+
+	assert.Equal(t, "TOKEN", gotToken)
+	assert.Equal(t, BeaconStatusUp, GetBeaconStatus("localhost:8080").Status)
+	assert.Equal(t, BeaconStatusDown, GetBeaconStatus("BAD ADDRESS").Status)
+	assert.NotEqual(t, "", GetBeaconStatus("BAD ADDRESS").LastError)
+}
+
+func Test_RefreshRecordsStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	refreshVMListOf(beaconData{Address: "BAD ADDRESS"})
+
+	assert.Equal(t, BeaconStatusDown, GetBeaconStatus("BAD ADDRESS").Status)
+}
+
+func Test_WithBeaconStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	recordBeaconStatus("ADDR", 0, nil)
+
+	list := withBeaconStatus([]aliases.Alias{{"ALIAS", "ADDR"}})
+
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "ALIAS", list[0].Alias.Alias)
+	assert.Equal(t, BeaconStatusUp, list[0].Health.Status)
+}
+
+func Test_HandleBeaconStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// Not permitted
+	w := runHandlerTest("GET", "/ADDR", nil, "/{Beacon}", handleBeaconStatus)
+	assert.Equal(t, 403, w.Code)
+
+	setupBeaconPermissions("ADDR", 0)
+
+	// Unknown beacon
+	w = runHandlerTest("GET", "/ADDR", nil, "/{Beacon}", handleBeaconStatus)
+	assert.Equal(t, 404, w.Code)
+
+	addBeacon(beaconData{Address: "ADDR", Token: "TOKEN"})
+	recordBeaconStatus("ADDR", 0, errors.New("ERROR"))
+
+	w = runHandlerTest("GET", "/ADDR", nil, "/{Beacon}", handleBeaconStatus)
+	assert.Equal(t, 200, w.Code)
+
+	var status BeaconStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+
+	assert.Equal(t, BeaconStatusDown, status.Status)
+	assert.Equal(t, "ERROR", status.LastError)
+}
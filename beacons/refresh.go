@@ -0,0 +1,252 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lighthouse/lighthouse/logging"
+)
+
+/*
+   Given a list of instance addresses, an InstanceReferenceFunc returns
+   the names of anything outside of this package which still uses them
+   (e.g. applications). Used to report what is affected when instances
+   are removed without importing those packages here.
+*/
+type InstanceReferenceFunc func(instances []string) []string
+
+var instanceReferenceFuncs = []InstanceReferenceFunc{}
+
+// How long an instance may be missing from its beacon before it is removed
+var StaleInstanceGrace = time.Hour
+
+// How many beacons are refreshed at once, see acquireRefreshSlot
+var RefreshWorkers = 8
+
+var (
+	refreshing     = 0
+	refreshingCond = sync.NewCond(&sync.Mutex{})
+)
+
+type RefreshReport struct {
+	Beacon       string
+	Stale        []string
+	Removed      []string
+	Applications []string
+	Error        string `json:",omitempty"`
+}
+
+func AddInstanceReferenceFunc(f InstanceReferenceFunc) {
+	instanceReferenceFuncs = append(instanceReferenceFuncs, f)
+}
+
+func findInstanceReferences(instances []string) []string {
+	found := make(map[string]bool)
+
+	for _, f := range instanceReferenceFuncs {
+		for _, name := range f(instances) {
+			found[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+/*
+   Syncs the beacon's instances with its VM list and removes any which
+   have been stale for longer than the grace period.
+
+   RETURN: A report of the stale and removed instances along with
+           the names of anything still referencing the removed ones
+*/
+func RefreshBeacon(beacon beaconData, grace time.Duration) (RefreshReport, error) {
+	report := RefreshReport{Beacon: beacon.Address}
+
+	stale, err := syncVMListOf(beacon)
+	if err != nil {
+		return report, err
+	}
+
+	removed, err := pruneStaleInstances(beacon.Address, grace)
+	if err != nil {
+		return report, err
+	}
+
+	report.Stale = getDifferenceOf(stale, removed)
+	report.Removed = removed
+	report.Applications = findInstanceReferences(removed)
+
+	return report, nil
+}
+
+/*
+   Refreshes all of the beacons concurrently, contacting at most
+   RefreshWorkers of them at a time along with any other refreshes.
+   Blocks until every refresh is done.
+
+   RETURN: A report for each beacon in the order given, with Error set
+           for any beacon which could not be refreshed
+*/
+func RefreshBeacons(list []beaconData, grace time.Duration) []RefreshReport {
+	reports := make([]RefreshReport, len(list))
+
+	forEachBeacon(list, func(i int) {
+		report, err := RefreshBeacon(list[i], grace)
+		if err != nil {
+			report = RefreshReport{Beacon: list[i].Address, Error: err.Error()}
+		}
+		reports[i] = report
+	})
+
+	return reports
+}
+
+/*
+   Calls work with the index of every beacon in the list, from at most
+   RefreshWorkers goroutines which each hold a refresh slot while
+   working. Blocks until every call is done.
+*/
+func forEachBeacon(list []beaconData, work func(i int)) {
+	workers := RefreshWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(list) {
+		workers = len(list)
+	}
+
+	jobs := make(chan int)
+	wait := sync.WaitGroup{}
+	wait.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wait.Done()
+
+			for job := range jobs {
+				release := acquireRefreshSlot()
+				work(job)
+				release()
+			}
+		}()
+	}
+
+	for i := range list {
+		jobs <- i
+	}
+	close(jobs)
+
+	wait.Wait()
+}
+
+/*
+   Waits until fewer than RefreshWorkers beacons are being refreshed,
+   whether by RefreshBeacons or by a listing asking for a refresh, so
+   that the beacons are never all contacted at once.
+
+   RETURN: A function to call once the refresh is done
+*/
+func acquireRefreshSlot() func() {
+	refreshingCond.L.Lock()
+	defer refreshingCond.L.Unlock()
+
+	for refreshing >= RefreshWorkers && refreshing > 0 {
+		refreshingCond.Wait()
+	}
+	refreshing++
+
+	return func() {
+		refreshingCond.L.Lock()
+		refreshing--
+		refreshingCond.L.Unlock()
+
+		refreshingCond.Signal()
+	}
+}
+
+func getDifferenceOf(orig, remove []string) []string {
+	removeSet := make(map[string]bool)
+	for _, item := range remove {
+		removeSet[item] = true
+	}
+
+	ret := make([]string, 0)
+	for _, item := range orig {
+		if !removeSet[item] {
+			ret = append(ret, item)
+		}
+	}
+
+	return ret
+}
+
+func logRefreshReport(report RefreshReport) {
+	if len(report.Removed) == 0 {
+		return
+	}
+
+	msg := fmt.Sprintf("beacon %s removed stale instances [%s]",
+		report.Beacon, strings.Join(report.Removed, ", "))
+
+	if len(report.Applications) > 0 {
+		msg += fmt.Sprintf(" still used by applications [%s]",
+			strings.Join(report.Applications, ", "))
+	}
+
+	logging.Info(msg)
+}
+
+/*
+   Refreshes every beacon's instances in the background every interval,
+   removing instances which have been stale for longer than grace.
+   A non-positive interval disables the refresher.
+*/
+func StartRefresher(interval, grace time.Duration) {
+	StaleInstanceGrace = grace
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			<-ticker.C
+
+			list, err := getAllBeaconData()
+			if err != nil {
+				logging.Info("beacon refresh failed: " + err.Error())
+				continue
+			}
+
+			for _, report := range RefreshBeacons(list, StaleInstanceGrace) {
+				logRefreshReport(report)
+			}
+		}
+	}()
+}
@@ -0,0 +1,210 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lighthouse/beacon/structs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+)
+
+func setupVMServer(vms []structs.VM) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		val, _ := json.Marshal(vms)
+		w.Write(val)
+	}
+}
+
+func Test_RefreshBeacon_MarksStale(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := setupVMServer([]structs.VM{
+		{Name: "VM", Address: "VM_ADDR", Port: "1234", Version: "v1.12"},
+	})
+	defer setupServer(&f).Close()
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+	addBeacon(beacon)
+	addInstance(instanceData{"GONE", "GONE_VM", true, beacon.Address, 0})
+
+	report, err := RefreshBeacon(beacon, time.Hour)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"GONE"}, report.Stale)
+	assert.Equal(t, []string{}, report.Removed)
+
+	list, _ := getInstancesOf(beacon.Address)
+	for _, instance := range list {
+		if instance.InstanceAddress == "GONE" {
+			assert.NotEqual(t, int64(0), instance.StaleSince)
+		} else {
+			assert.Equal(t, int64(0), instance.StaleSince)
+		}
+	}
+}
+
+func Test_RefreshBeacon_Reappears(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := setupVMServer([]structs.VM{
+		{Name: "VM", Address: "VM_ADDR", Port: "1234", Version: "v1.12"},
+	})
+	defer setupServer(&f).Close()
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+	addBeacon(beacon)
+	addInstance(instanceData{"VM_ADDR:1234/v1.12", "VM", false, beacon.Address, 1})
+
+	report, err := RefreshBeacon(beacon, time.Hour)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, report.Stale)
+
+	var instance instanceData
+	instances.SelectRow(nil, nil, nil, &instance)
+	assert.Equal(t, int64(0), instance.StaleSince)
+}
+
+func Test_RefreshBeacon_Prunes(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := setupVMServer([]structs.VM{})
+	defer setupServer(&f).Close()
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+	addBeacon(beacon)
+
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	addInstance(instanceData{"OLD", "OLD_VM", true, beacon.Address, old})
+	aliases.AddAlias("OLD_ALIAS", "OLD")
+
+	var gotInstances []string
+	AddInstanceReferenceFunc(func(instances []string) []string {
+		gotInstances = instances
+		return []string{"APP"}
+	})
+
+	report, err := RefreshBeacon(beacon, time.Hour)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, report.Stale)
+	assert.Equal(t, []string{"OLD"}, report.Removed)
+	assert.Equal(t, []string{"APP"}, report.Applications)
+	assert.Equal(t, []string{"OLD"}, gotInstances)
+
+	assert.False(t, instanceExists("OLD"))
+
+	_, err = aliases.GetAddressOf("OLD_ALIAS")
+	assert.NotNil(t, err)
+}
+
+func Test_RefreshBeacon_BadBeacon(t *testing.T) {
+	setup()
+	defer teardown()
+
+	beacon := beaconData{Address: "BAD ADDRESS", Token: "TOKEN"}
+	addInstance(instanceData{"INST", "VM", true, beacon.Address, 0})
+
+	_, err := RefreshBeacon(beacon, 0)
+
+	assert.NotNil(t, err)
+	assert.True(t, instanceExists("INST"), "unreachable beacons should not lose instances")
+}
+
+func Test_FindInstanceReferences(t *testing.T) {
+	setup()
+	defer teardown()
+
+	assert.Equal(t, []string{}, findInstanceReferences([]string{"INST"}))
+
+	AddInstanceReferenceFunc(func([]string) []string { return []string{"B", "A"} })
+	AddInstanceReferenceFunc(func([]string) []string { return []string{"A", "C"} })
+
+	assert.Equal(t, []string{"A", "B", "C"}, findInstanceReferences([]string{"INST"}))
+}
+
+func Test_SyncVMListOf_NamespacedAliases(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := setupVMServer([]structs.VM{
+		{Name: "VM", Address: "VM_ADDR", Port: "1234", Version: "v1.12"},
+	})
+	defer setupServer(&f).Close()
+
+	beacon := beaconData{Address: "localhost:8080", Token: "TOKEN"}
+	addBeacon(beacon)
+	aliases.AddAlias("beacon", beacon.Address)
+
+	syncVMListOf(beacon)
+
+	alias, _ := aliases.GetAliasOf("VM_ADDR:1234/v1.12")
+	assert.Equal(t, "beacon"+INSTANCE_ALIAS_DELIM+"VM", alias)
+
+	// Renaming the beacon renames its instances
+	aliases.UpdateAlias("renamed", beacon.Address)
+
+	alias, _ = aliases.GetAliasOf("VM_ADDR:1234/v1.12")
+	assert.Equal(t, "renamed"+INSTANCE_ALIAS_DELIM+"VM", alias)
+
+	address, _ := aliases.ResolveAlias("VM", "renamed")
+	assert.Equal(t, "VM_ADDR:1234/v1.12", address)
+}
+
+func Test_HandleRefreshBeacon(t *testing.T) {
+	setup()
+	defer teardown()
+
+	f := setupVMServer([]structs.VM{})
+	defer setupServer(&f).Close()
+
+	addBeacon(beaconData{Address: "localhost:8080", Token: "TOKEN"})
+	aliases.AddAlias("BEACON", "localhost:8080")
+	addInstance(instanceData{"INST", "VM", true, "localhost:8080", 0})
+
+	// Refreshing may remove instances, so access is not enough
+	setupBeaconPermissions("localhost:8080", auth.AccessAuthLevel)
+
+	w := runHandlerTest("PUT", "/BEACON", nil, "/{Beacon:.*}", handleRefreshBeacon)
+	assert.Equal(t, 403, w.Code)
+
+	var instance instanceData
+	instances.SelectRow(nil, nil, nil, &instance)
+	assert.Equal(t, int64(0), instance.StaleSince)
+
+	w = runHandlerTest("PUT", "/", nil, "/", handleRefreshBeacons)
+	assert.Equal(t, "[]", w.Body.String())
+
+	setupBeaconPermissions("localhost:8080", auth.ModifyAuthLevel)
+
+	w = runHandlerTest("PUT", "/BEACON", nil, "/{Beacon:.*}", handleRefreshBeacon)
+	assert.Equal(t, 200, w.Code)
+
+	var report RefreshReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, "localhost:8080", report.Beacon)
+	assert.Equal(t, []string{"INST"}, report.Stale)
+}
@@ -0,0 +1,241 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/logging"
+)
+
+/*
+   Rotating a beacon's token is done in steps so that Lighthouse and
+   the beacon never disagree for long:
+
+     1. PUT /rotate/{Beacon} stores the next token as pending
+     2. The beacon is reconfigured with the pending token
+     3. PUT /promote/{Beacon} checks the pending token against the
+        beacon's /vms endpoint and makes it the current token
+
+   Until the pending token is promoted both tokens are in use: the
+   current token is sent first and the pending one is tried whenever
+   the beacon rejects it. Whichever request first succeeds with the
+   pending token promotes it, so step 3 is optional.
+*/
+
+var (
+	TokenRejectedError     = errors.New("beacons: beacon rejected the token")
+	NoPendingTokenError    = errors.New("beacons: no token rotation in progress")
+	TokenVerificationError = errors.New("beacons: could not verify the pending token with the beacon")
+)
+
+// Beacons answer requests with a wrong token with either of these
+func IsTokenRejection(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+func GenerateBeaconToken() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+func setBeaconToken(beacon, token string) error {
+	to := map[string]interface{}{
+		"Token":          token,
+		"PendingToken":   "",
+		"TokenRotatedAt": time.Now().Unix(),
+	}
+	where := databases.Filter{"Address": beacon}
+
+	err := beacons.Update(to, where)
+	if err == nil {
+		publishEvent(Event{Type: EventTokenChanged, Beacon: beacon})
+	}
+
+	return err
+}
+
+func startTokenRotation(beacon, token string) error {
+	to := map[string]interface{}{"PendingToken": token}
+	where := databases.Filter{"Address": beacon}
+
+	return beacons.Update(to, where)
+}
+
+func cancelTokenRotation(beacon string) error {
+	return startTokenRotation(beacon, "")
+}
+
+/*
+   Makes token the current token of the beacon if it is still the
+   pending one, which guards against a rotation that was cancelled or
+   restarted in the meantime.
+*/
+func promotePendingToken(beacon, token string) error {
+	data, err := getBeaconData(beacon)
+	if err != nil {
+		return err
+	}
+
+	if data.PendingToken == "" || data.PendingToken != token {
+		return NoPendingTokenError
+	}
+
+	err = setBeaconToken(beacon, token)
+	if err == nil {
+		logging.Info(fmt.Sprintf("beacon %s token rotated", beacon))
+	}
+
+	return err
+}
+
+/*
+   Checks the pending token of the beacon against its /vms endpoint
+   and promotes it if the beacon accepts it.
+*/
+func verifyPendingToken(beacon string) error {
+	data, err := getBeaconData(beacon)
+	if err != nil {
+		return err
+	}
+
+	if data.PendingToken == "" {
+		return NoPendingTokenError
+	}
+
+	_, err = doVMListRequest(data, data.PendingToken)
+	if err != nil {
+		return err
+	}
+
+	return promotePendingToken(beacon, data.PendingToken)
+}
+
+/*
+   Used when a request authorized with current failed with a token
+   rejection, so that it can be retried during a rotation. The caller
+   must already hold the current token.
+
+   RETURN: The pending token if current is the beacon's token and a
+           rotation is in progress, "" otherwise
+*/
+func PendingTokenFor(beacon, current string) string {
+	data, err := getBeaconData(beacon)
+	if err != nil || current == "" || data.Token != current {
+		return ""
+	}
+
+	return data.PendingToken
+}
+
+/*
+   Reports that the beacon accepted its pending token on some other
+   request, which promotes it.
+*/
+func ConfirmPendingToken(beacon, token string) error {
+	return promotePendingToken(beacon, token)
+}
+
+func getModifiableBeacon(w http.ResponseWriter, r *http.Request) (string, bool) {
+	beacon := getAddressOf(mux.Vars(r)["Beacon"])
+	user := auth.GetCurrentUser(r)
+
+	if !user.CanModifyBeacon(beacon) {
+		writeResponse(TokenPermissionError, w)
+		return "", false
+	}
+
+	if !beaconExists(beacon) {
+		writeResponse(UnknownBeaconError, w)
+		return "", false
+	}
+
+	return beacon, true
+}
+
+func handleStartTokenRotation(w http.ResponseWriter, r *http.Request) {
+	beacon, ok := getModifiableBeacon(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	var rotation struct {
+		Token    string
+		Generate bool
+	}
+
+	err = json.Unmarshal(reqBody, &rotation)
+	if err != nil || (rotation.Token == "") == !rotation.Generate {
+		writeResponse(NotEnoughParametersError, w)
+		return
+	}
+
+	if rotation.Generate {
+		rotation.Token = GenerateBeaconToken()
+	}
+
+	err = startTokenRotation(beacon, rotation.Token)
+	if err != nil {
+		writeResponse(err, w)
+		return
+	}
+
+	output, _ := json.Marshal(map[string]string{"PendingToken": rotation.Token})
+	fmt.Fprint(w, string(output))
+}
+
+func handlePromoteToken(w http.ResponseWriter, r *http.Request) {
+	beacon, ok := getModifiableBeacon(w, r)
+	if !ok {
+		return
+	}
+
+	err := verifyPendingToken(beacon)
+
+	switch err {
+	case nil, NoPendingTokenError:
+		writeResponse(err, w)
+	default:
+		// Either unreachable or not (yet) using the pending token
+		writeResponse(TokenVerificationError, w)
+	}
+}
+
+func handleCancelTokenRotation(w http.ResponseWriter, r *http.Request) {
+	beacon, ok := getModifiableBeacon(w, r)
+	if !ok {
+		return
+	}
+
+	writeResponse(cancelTokenRotation(beacon), w)
+}
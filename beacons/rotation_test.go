@@ -0,0 +1,191 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+)
+
+// Beacon which only accepts the given token
+func tokenServer(token *string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HEADER_TOKEN_KEY) != *token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "[]")
+	}
+}
+
+func Test_GenerateBeaconToken(t *testing.T) {
+	first, second := GenerateBeaconToken(), GenerateBeaconToken()
+
+	assert.Equal(t, 64, len(first))
+	assert.NotEqual(t, first, second)
+}
+
+func Test_VerifyPendingToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	accepted := "OLD"
+	f := tokenServer(&accepted)
+	defer setupServer(&f).Close()
+
+	addBeacon(beaconData{Address: "localhost:8080", Token: "OLD"})
+
+	assert.Equal(t, NoPendingTokenError, verifyPendingToken("localhost:8080"))
+
+	startTokenRotation("localhost:8080", "NEW")
+
+	// Beacon not updated yet
+	assert.Equal(t, TokenRejectedError, verifyPendingToken("localhost:8080"))
+
+	data, _ := getBeaconData("localhost:8080")
+	assert.Equal(t, "OLD", data.Token)
+	assert.Equal(t, "NEW", data.PendingToken)
+
+	accepted = "NEW"
+	assert.Nil(t, verifyPendingToken("localhost:8080"))
+
+	data, _ = getBeaconData("localhost:8080")
+	assert.Equal(t, "NEW", data.Token)
+	assert.Equal(t, "", data.PendingToken)
+	assert.NotEqual(t, int64(0), data.TokenRotatedAt)
+}
+
+func Test_RequestVMList_Overlap(t *testing.T) {
+	setup()
+	defer teardown()
+
+	accepted := "OLD"
+	f := tokenServer(&accepted)
+	defer setupServer(&f).Close()
+
+	addBeacon(beaconData{Address: "localhost:8080", Token: "OLD"})
+	startTokenRotation("localhost:8080", "NEW")
+
+	// Both tokens work while rotating
+	data, _ := getBeaconData("localhost:8080")
+	_, err := requestVMList(data)
+	assert.Nil(t, err)
+
+	data, _ = getBeaconData("localhost:8080")
+	assert.Equal(t, "OLD", data.Token)
+
+	accepted = "NEW"
+	_, err = requestVMList(data)
+	assert.Nil(t, err)
+
+	data, _ = getBeaconData("localhost:8080")
+	assert.Equal(t, "NEW", data.Token)
+	assert.Equal(t, "", data.PendingToken)
+}
+
+func Test_PendingTokenFor(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addBeacon(beaconData{Address: "ADDR", Token: "OLD"})
+
+	assert.Equal(t, "", PendingTokenFor("ADDR", "OLD"))
+
+	startTokenRotation("ADDR", "NEW")
+
+	assert.Equal(t, "NEW", PendingTokenFor("ADDR", "OLD"))
+	assert.Equal(t, "", PendingTokenFor("ADDR", "WRONG"))
+	assert.Equal(t, "", PendingTokenFor("ADDR", ""))
+
+	assert.Equal(t, NoPendingTokenError, ConfirmPendingToken("ADDR", "OTHER"))
+	assert.Nil(t, ConfirmPendingToken("ADDR", "NEW"))
+
+	data, _ := getBeaconData("ADDR")
+	assert.Equal(t, "NEW", data.Token)
+}
+
+func Test_HandleStartTokenRotation(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addBeacon(beaconData{Address: "ADDR", Token: "OLD"})
+
+	w := runHandlerTest("PUT", "/ADDR", map[string]string{"Token": "NEW"}, "/{Beacon}", handleStartTokenRotation)
+	assert.Equal(t, 403, w.Code)
+
+	setupBeaconPermissions("ADDR", auth.ModifyAuthLevel)
+
+	invalid := []interface{}{
+		map[string]interface{}{},
+		map[string]interface{}{"Token": "NEW", "Generate": true},
+	}
+
+	for _, body := range invalid {
+		w = runHandlerTest("PUT", "/ADDR", body, "/{Beacon}", handleStartTokenRotation)
+		assert.Equal(t, 400, w.Code)
+	}
+
+	w = runHandlerTest("PUT", "/ADDR", map[string]bool{"Generate": true}, "/{Beacon}", handleStartTokenRotation)
+	assert.Equal(t, 200, w.Code)
+
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	data, _ := getBeaconData("ADDR")
+	assert.Equal(t, "OLD", data.Token)
+	assert.Equal(t, resp["PendingToken"], data.PendingToken)
+	assert.NotEqual(t, "", data.PendingToken)
+
+	w = runHandlerTest("DELETE", "/ADDR", nil, "/{Beacon}", handleCancelTokenRotation)
+	assert.Equal(t, 200, w.Code)
+
+	data, _ = getBeaconData("ADDR")
+	assert.Equal(t, "", data.PendingToken)
+}
+
+func Test_HandlePromoteToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	accepted := "OLD"
+	f := tokenServer(&accepted)
+	defer setupServer(&f).Close()
+
+	addBeacon(beaconData{Address: "localhost:8080", Token: "OLD"})
+	setupBeaconPermissions("localhost:8080", auth.ModifyAuthLevel)
+
+	w := runHandlerTest("PUT", "/localhost:8080", nil, "/{Beacon}", handlePromoteToken)
+	assert.Equal(t, 409, w.Code)
+
+	startTokenRotation("localhost:8080", "NEW")
+
+	w = runHandlerTest("PUT", "/localhost:8080", nil, "/{Beacon}", handlePromoteToken)
+	assert.Equal(t, 409, w.Code)
+
+	accepted = "NEW"
+
+	w = runHandlerTest("PUT", "/localhost:8080", nil, "/{Beacon}", handlePromoteToken)
+	assert.Equal(t, 200, w.Code)
+
+	data, _ := getBeaconData("localhost:8080")
+	assert.Equal(t, "NEW", data.Token)
+}
@@ -0,0 +1,310 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+)
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 500
+)
+
+var InvalidSearchError = errors.New("beacons: invalid instance search parameters")
+
+// Fields of an instance listing which search results may be sorted by
+var searchSortFields = map[string]bool{
+	"Name":            true,
+	"InstanceAddress": true,
+	"BeaconAddress":   true,
+	"Alias":           true,
+}
+
+/*
+   Parameters of an instance search. Empty fields do not filter.
+   Name and Alias match case-insensitive substrings.
+*/
+type InstanceSearch struct {
+	Name            string
+	Alias           string
+	Beacon          string
+	CanAccessDocker *bool
+	Selector        Selector
+
+	Sort   string
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+type InstanceSearchResult struct {
+	Total     int
+	Offset    int
+	Limit     int
+	Instances []map[string]interface{}
+}
+
+/*
+   Reads an InstanceSearch from the query parameters name, alias,
+   beacon, docker, selector, sort, order (asc or desc), offset and
+   limit.
+*/
+func ParseInstanceSearch(query url.Values) (InstanceSearch, error) {
+	search := InstanceSearch{
+		Name:   query.Get("name"),
+		Alias:  query.Get("alias"),
+		Beacon: query.Get("beacon"),
+		Sort:   query.Get("sort"),
+		Limit:  DefaultSearchLimit,
+	}
+
+	var err error
+
+	if docker := query.Get("docker"); docker != "" {
+		canAccess, err := strconv.ParseBool(docker)
+		if err != nil {
+			return search, InvalidSearchError
+		}
+		search.CanAccessDocker = &canAccess
+	}
+
+	search.Selector, err = ParseSelector(query.Get("selector"))
+	if err != nil {
+		return search, err
+	}
+
+	if search.Sort == "" {
+		search.Sort = "InstanceAddress"
+	} else if !searchSortFields[search.Sort] {
+		return search, InvalidSearchError
+	}
+
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		search.Desc = true
+	default:
+		return search, InvalidSearchError
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		search.Offset, err = strconv.Atoi(offset)
+		if err != nil || search.Offset < 0 {
+			return search, InvalidSearchError
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		search.Limit, err = strconv.Atoi(limit)
+		if err != nil || search.Limit <= 0 || search.Limit > MaxSearchLimit {
+			return search, InvalidSearchError
+		}
+	}
+
+	return search, nil
+}
+
+func (this InstanceSearch) matches(listing map[string]interface{}) bool {
+	if !containsFold(listing["Name"].(string), this.Name) {
+		return false
+	}
+
+	if !containsFold(listing["Alias"].(string), this.Alias) {
+		return false
+	}
+
+	return this.Selector.Matches(listing["Labels"].(map[string]string))
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+/*
+   Searches the instances of every beacon the user can access.
+
+   RETURN: The requested page of matching instances along with the
+           total number of matches
+*/
+func SearchInstances(user *auth.User, search InstanceSearch) (InstanceSearchResult, error) {
+	result := InstanceSearchResult{
+		Offset:    search.Offset,
+		Limit:     search.Limit,
+		Instances: make([]map[string]interface{}, 0),
+	}
+
+	where := databases.Filter{}
+	if search.Beacon != "" {
+		where["BeaconAddress"] = getAddressOf(search.Beacon)
+	}
+	if search.CanAccessDocker != nil {
+		where["CanAccessDocker"] = *search.CanAccessDocker
+	}
+
+	scanner, err := instances.Select(nil, where, nil)
+	if err != nil {
+		return result, err
+	}
+
+	defer scanner.Close()
+
+	accessible := make([]instanceData, 0)
+	seen := make(map[string]bool)
+
+	for scanner.Next() {
+		var instance instanceData
+		scanner.Scan(&instance)
+
+		if seen[instance.InstanceAddress] || !user.CanAccessBeacon(instance.BeaconAddress) {
+			continue
+		}
+		seen[instance.InstanceAddress] = true
+
+		accessible = append(accessible, instance)
+	}
+
+	listing, err := loadListingData(accessible)
+	if err != nil {
+		return result, err
+	}
+
+	matches := make([]map[string]interface{}, 0)
+
+	for _, instance := range accessible {
+		entry := listing.of(instance)
+		if search.matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a := matches[i][search.Sort].(string)
+		b := matches[j][search.Sort].(string)
+
+		if a == b {
+			return matches[i]["InstanceAddress"].(string) < matches[j]["InstanceAddress"].(string)
+		}
+		if search.Desc {
+			return a > b
+		}
+		return a < b
+	})
+
+	result.Total = len(matches)
+
+	if search.Offset < len(matches) {
+		end := search.Offset + search.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Instances = matches[search.Offset:end]
+	}
+
+	return result, nil
+}
+
+/*
+   Somewhere the user can send Docker requests to. Beacon is empty for
+   direct Docker hosts.
+*/
+type DockerTarget struct {
+	Address string
+	Beacon  string
+}
+
+/*
+   RETURN: Every instance with Docker on a beacon the user can access,
+           followed by every direct Docker host they can access
+*/
+func GetDockerTargets(user *auth.User) ([]DockerTarget, error) {
+	targets := make([]DockerTarget, 0)
+
+	scanner, err := instances.Select(nil, databases.Filter{"CanAccessDocker": true}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	for scanner.Next() {
+		var instance instanceData
+		scanner.Scan(&instance)
+
+		if user.CanAccessBeacon(instance.BeaconAddress) {
+			targets = append(targets, DockerTarget{instance.InstanceAddress, instance.BeaconAddress})
+		}
+	}
+
+	hostScanner, err := hosts.Select([]string{"Address"}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer hostScanner.Close()
+
+	for hostScanner.Next() {
+		var host hostData
+		hostScanner.Scan(&host)
+
+		if user.CanAccessHost(host.Address) {
+			targets = append(targets, DockerTarget{Address: host.Address})
+		}
+	}
+
+	return targets, nil
+}
+
+func handleSearchInstances(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	search, err := ParseInstanceSearch(r.URL.Query())
+
+	var result InstanceSearchResult
+	if err == nil {
+		result, err = SearchInstances(user, search)
+	}
+
+	var output []byte
+	if err == nil {
+		output, err = json.Marshal(result)
+	}
+
+	if err != nil {
+		writeResponse(err, w)
+	} else {
+		fmt.Fprint(w, string(output))
+	}
+}
+
+/*
+   Registers the inventory routes, which live outside of the beacons
+   prefix since they span every beacon.
+*/
+func HandleInstances(r *mux.Router) {
+	r.HandleFunc("/instances", handleSearchInstances).Methods("GET")
+}
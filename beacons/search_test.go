@@ -0,0 +1,190 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"encoding/json"
+	"net/url"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/transport"
+)
+
+func setupSearchInstances() *auth.User {
+	addInstance(instanceData{"10.0.0.1", "web-1", true, "B1", 0})
+	addInstance(instanceData{"10.0.0.2", "web-2", false, "B1", 0})
+	addInstance(instanceData{"10.0.0.3", "db-1", true, "B2", 0})
+	addInstance(instanceData{"10.0.0.4", "hidden", true, "B3", 0})
+
+	setInstanceLabels("10.0.0.1", LabelSourceUser, map[string]string{"env": "prod"})
+	setInstanceLabels("10.0.0.3", LabelSourceUser, map[string]string{"env": "dev"})
+
+	aliases.AddAlias("primary-db", "10.0.0.3")
+
+	setupBeaconPermissions("B1", auth.AccessAuthLevel)
+	setupBeaconPermissions("B2", auth.AccessAuthLevel)
+
+	user, _ := auth.GetUser("USER")
+	return user
+}
+
+func searchAddresses(result InstanceSearchResult) []string {
+	addresses := make([]string, len(result.Instances))
+	for i, instance := range result.Instances {
+		addresses[i] = instance["InstanceAddress"].(string)
+	}
+	return addresses
+}
+
+func Test_ParseInstanceSearch(t *testing.T) {
+	search, err := ParseInstanceSearch(url.Values{})
+	assert.Nil(t, err)
+	assert.Equal(t, "InstanceAddress", search.Sort)
+	assert.Equal(t, DefaultSearchLimit, search.Limit)
+	assert.Nil(t, search.CanAccessDocker)
+
+	search, err = ParseInstanceSearch(url.Values{
+		"docker": {"true"},
+		"sort":   {"Name"},
+		"order":  {"desc"},
+		"offset": {"5"},
+		"limit":  {"10"},
+	})
+	assert.Nil(t, err)
+	assert.True(t, *search.CanAccessDocker)
+	assert.Equal(t, "Name", search.Sort)
+	assert.True(t, search.Desc)
+	assert.Equal(t, 5, search.Offset)
+	assert.Equal(t, 10, search.Limit)
+
+	invalid := []url.Values{
+		{"docker": {"maybe"}},
+		{"sort": {"Token"}},
+		{"order": {"up"}},
+		{"offset": {"-1"}},
+		{"limit": {"0"}},
+		{"limit": {"100000"}},
+	}
+
+	for _, query := range invalid {
+		_, err = ParseInstanceSearch(query)
+		assert.Equal(t, InvalidSearchError, err)
+	}
+
+	_, err = ParseInstanceSearch(url.Values{"selector": {"env==prod"}})
+	assert.Equal(t, InvalidSelectorError, err)
+}
+
+func Test_SearchInstances(t *testing.T) {
+	setup()
+	defer teardown()
+
+	user := setupSearchInstances()
+
+	all, _ := ParseInstanceSearch(url.Values{})
+	result, err := SearchInstances(user, all)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, searchAddresses(result))
+
+	tests := []struct {
+		Query    url.Values
+		Expected []string
+	}{
+		{url.Values{"name": {"WEB"}}, []string{"10.0.0.1", "10.0.0.2"}},
+		{url.Values{"beacon": {"B2"}}, []string{"10.0.0.3"}},
+		{url.Values{"docker": {"false"}}, []string{"10.0.0.2"}},
+		{url.Values{"alias": {"primary"}}, []string{"10.0.0.3"}},
+		{url.Values{"selector": {"env"}}, []string{"10.0.0.1", "10.0.0.3"}},
+		{url.Values{"sort": {"Name"}}, []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"}},
+		{url.Values{"order": {"desc"}}, []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}},
+		{url.Values{"beacon": {"B3"}}, []string{}},
+	}
+
+	for _, test := range tests {
+		search, _ := ParseInstanceSearch(test.Query)
+		result, err := SearchInstances(user, search)
+
+		assert.Nil(t, err)
+		assert.Equal(t, test.Expected, searchAddresses(result), test.Query.Encode())
+	}
+}
+
+func Test_SearchInstances_Pagination(t *testing.T) {
+	setup()
+	defer teardown()
+
+	user := setupSearchInstances()
+
+	search, _ := ParseInstanceSearch(url.Values{"offset": {"1"}, "limit": {"1"}})
+	result, _ := SearchInstances(user, search)
+
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, 1, result.Offset)
+	assert.Equal(t, 1, result.Limit)
+	assert.Equal(t, []string{"10.0.0.2"}, searchAddresses(result))
+
+	search.Offset = 10
+	result, _ = SearchInstances(user, search)
+
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, []string{}, searchAddresses(result))
+}
+
+func Test_HandleSearchInstances(t *testing.T) {
+	setup()
+	defer teardown()
+
+	setupSearchInstances()
+
+	w := runHandlerTest("GET", "/instances?name=db", nil, "/instances", handleSearchInstances)
+	assert.Equal(t, 200, w.Code)
+
+	var result InstanceSearchResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, "10.0.0.3", result.Instances[0]["InstanceAddress"])
+	assert.Equal(t, "primary-db", result.Instances[0]["Alias"])
+
+	w = runHandlerTest("GET", "/instances?limit=none", nil, "/instances", handleSearchInstances)
+	assert.Equal(t, 400, w.Code)
+}
+
+func Test_GetDockerTargets(t *testing.T) {
+	setup()
+	defer teardown()
+
+	user := setupSearchInstances()
+
+	SetHostTLS("HOST1", transport.TLSSettings{})
+	SetHostTLS("HOST2", transport.TLSSettings{})
+	auth.SetUserHostAuthLevel(user, "HOST2", auth.AccessAuthLevel)
+
+	targets, err := GetDockerTargets(user)
+	assert.Nil(t, err)
+
+	// Instances without Docker and those on hidden beacons are left out
+	assert.Equal(t, []DockerTarget{
+		{"10.0.0.1", "B1"},
+		{"10.0.0.3", "B2"},
+		{"HOST2", ""},
+	}, targets)
+}
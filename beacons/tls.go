@@ -0,0 +1,216 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/transport"
+)
+
+/*
+   Docker hosts which are reached directly rather than through a
+   beacon. Docker requests are only sent to hosts registered here.
+*/
+var hosts databases.TableInterface
+
+var hostSchema = databases.Schema{
+	"Address":    "text UNIQUE PRIMARY KEY",
+	"UseTLS":     "boolean",
+	"CACert":     "text",
+	"ClientCert": "text",
+	"ClientKey":  "text",
+	"ServerName": "text",
+}
+
+type hostData struct {
+	Address string
+	transport.TLSSettings
+}
+
+func tlsEntry(settings transport.TLSSettings) map[string]interface{} {
+	return map[string]interface{}{
+		"UseTLS":     settings.UseTLS,
+		"CACert":     settings.CACert,
+		"ClientCert": settings.ClientCert,
+		"ClientKey":  settings.ClientKey,
+		"ServerName": settings.ServerName,
+	}
+}
+
+/*
+   Gets the TLS settings used to reach the given beacon or direct
+   Docker host.
+
+   RETURN: The settings, plain http if none are stored
+*/
+func GetTLSSettings(address string) transport.TLSSettings {
+	where := databases.Filter{"Address": address}
+
+	var beacon beaconData
+	if beacons.SelectRow(nil, where, nil, &beacon) == nil {
+		return beacon.TLSSettings
+	}
+
+	var host hostData
+	if hosts.SelectRow(nil, where, nil, &host) == nil {
+		return host.TLSSettings
+	}
+
+	return transport.TLSSettings{}
+}
+
+/*
+   RETURN: A client configured for the TLS settings of the given
+           beacon or direct Docker host. A timeout of 0 means none.
+           Clients for direct hosts only connect to allowed addresses.
+*/
+func HTTPClientFor(address string, timeout time.Duration) (*http.Client, error) {
+	if beaconExists(address) {
+		return transport.NewClient(GetTLSSettings(address), timeout)
+	}
+
+	return transport.NewGuardedClient(GetTLSSettings(address), timeout, directHostDialer)
+}
+
+/*
+   Stores how a Docker host which is not behind a beacon is reached,
+   replacing any previous settings for it.
+*/
+func SetHostTLS(address string, settings transport.TLSSettings) error {
+	entry := tlsEntry(settings)
+
+	err := hosts.Update(entry, databases.Filter{"Address": address})
+	if err == databases.NoUpdateError {
+		entry["Address"] = address
+		err = hosts.Insert(entry)
+	}
+
+	return err
+}
+
+func readTLSSettings(r *http.Request) (transport.TLSSettings, error) {
+	var settings transport.TLSSettings
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return settings, err
+	}
+
+	err = json.Unmarshal(reqBody, &settings)
+	if err != nil {
+		return settings, NotEnoughParametersError
+	}
+
+	return settings, settings.Validate()
+}
+
+func handleUpdateBeaconTLS(w http.ResponseWriter, r *http.Request) {
+	beacon := getAddressOf(mux.Vars(r)["Beacon"])
+	user := auth.GetCurrentUser(r)
+
+	if !user.CanModifyBeacon(beacon) {
+		writeResponse(BeaconPermissionError, w)
+		return
+	}
+
+	if !beaconExists(beacon) {
+		writeResponse(UnknownBeaconError, w)
+		return
+	}
+
+	settings, err := readTLSSettings(r)
+	if err == nil {
+		err = beacons.Update(tlsEntry(settings), databases.Filter{"Address": beacon})
+	}
+
+	writeResponse(err, w)
+}
+
+/*
+   Registers a direct Docker host, or changes how it is reached. Only
+   users who are able to create other users may register hosts, and
+   only at allowed addresses. They become the host's owner.
+*/
+func updateHost(ctx context.Context, user *auth.User, host string, settings transport.TLSSettings) error {
+	isAdmin := user.AuthLevel >= auth.CreateUserAuthLevel
+
+	if !hostRegistered(host) {
+		if !isAdmin {
+			return HostPermissionError
+		}
+
+		if _, err := checkAllowlist(ctx, hostHostname(host)); err != nil {
+			return err
+		}
+
+		if err := SetHostTLS(host, settings); err != nil {
+			return err
+		}
+
+		return auth.SetUserHostAuthLevel(user, host, auth.OwnerAuthLevel)
+	}
+
+	if !isAdmin && !user.CanModifyHost(host) {
+		return HostPermissionError
+	}
+
+	return SetHostTLS(host, settings)
+}
+
+/*
+   Direct hosts may be removed by their owners and by admins.
+*/
+func removeHost(user *auth.User, host string) error {
+	if user.GetAuthLevel("Hosts", host) < auth.OwnerAuthLevel &&
+		user.AuthLevel < auth.CreateUserAuthLevel {
+
+		return HostPermissionError
+	}
+
+	err := hosts.Delete(databases.Filter{"Address": host})
+	if err != nil {
+		return err
+	}
+
+	return auth.RemovePermissionFromAll("Hosts", host)
+}
+
+func handleUpdateHostTLS(w http.ResponseWriter, r *http.Request) {
+	host := getAddressOf(mux.Vars(r)["Host"])
+	user := auth.GetCurrentUser(r)
+
+	settings, err := readTLSSettings(r)
+	if err == nil {
+		err = updateHost(r.Context(), user, host, settings)
+	}
+
+	writeResponse(err, w)
+}
+
+func handleRemoveHost(w http.ResponseWriter, r *http.Request) {
+	host := getAddressOf(mux.Vars(r)["Host"])
+	user := auth.GetCurrentUser(r)
+
+	writeResponse(removeHost(user, host), w)
+}
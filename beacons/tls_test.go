@@ -0,0 +1,125 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beacons
+
+import (
+	"testing"
+
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/transport"
+)
+
+func Test_GetTLSSettings(t *testing.T) {
+	setup()
+	defer teardown()
+
+	beaconTLS := transport.TLSSettings{UseTLS: true, ServerName: "BEACON"}
+	hostTLS := transport.TLSSettings{UseTLS: true, ServerName: "HOST"}
+
+	addBeacon(beaconData{Address: "BEACON_ADDR", Token: "TOKEN", TLSSettings: beaconTLS})
+	SetHostTLS("HOST_ADDR", hostTLS)
+
+	assert.Equal(t, beaconTLS, GetTLSSettings("BEACON_ADDR"))
+	assert.Equal(t, hostTLS, GetTLSSettings("HOST_ADDR"))
+	assert.Equal(t, transport.TLSSettings{}, GetTLSSettings("UNKNOWN"))
+
+	hostTLS.ServerName = "UPDATED"
+	SetHostTLS("HOST_ADDR", hostTLS)
+
+	assert.Equal(t, hostTLS, GetTLSSettings("HOST_ADDR"))
+}
+
+func Test_RequestVMList_TLS(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var gotToken string
+
+	s := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			gotToken = r.Header.Get(HEADER_TOKEN_KEY)
+			fmt.Fprint(w, "[]")
+		}))
+	defer s.Close()
+
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}
+
+	beacon := beaconData{Address: strings.TrimPrefix(s.URL, "https://"), Token: "TOKEN"}
+
+	// Plain http to a TLS server fails
+	_, err := requestVMList(beacon)
+	assert.NotNil(t, err)
+
+	beacon.TLSSettings = transport.TLSSettings{
+		UseTLS: true,
+		CACert: string(pem.EncodeToMemory(block)),
+	}
+
+	_, err = requestVMList(beacon)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "TOKEN", gotToken)
+}
+
+func Test_HandleUpdateBeaconTLS(t *testing.T) {
+	setup()
+	defer teardown()
+
+	settings := transport.TLSSettings{UseTLS: true, ServerName: "NAME"}
+
+	w := runHandlerTest("PUT", "/ADDR", settings, "/{Beacon}", handleUpdateBeaconTLS)
+	assert.Equal(t, 403, w.Code)
+
+	setupBeaconPermissions("ADDR", auth.ModifyAuthLevel)
+
+	w = runHandlerTest("PUT", "/ADDR", settings, "/{Beacon}", handleUpdateBeaconTLS)
+	assert.Equal(t, 404, w.Code)
+
+	addBeacon(beaconData{Address: "ADDR", Token: "TOKEN"})
+
+	bad := transport.TLSSettings{UseTLS: true, CACert: "NOT PEM"}
+	w = runHandlerTest("PUT", "/ADDR", bad, "/{Beacon}", handleUpdateBeaconTLS)
+	assert.Equal(t, 400, w.Code)
+
+	w = runHandlerTest("PUT", "/ADDR", settings, "/{Beacon}", handleUpdateBeaconTLS)
+	assert.Equal(t, 200, w.Code)
+
+	data, _ := getBeaconData("ADDR")
+	assert.Equal(t, beaconData{Address: "ADDR", Token: "TOKEN", TLSSettings: settings}, data)
+}
+
+func Test_HandleHostTLS_Unauthorized(t *testing.T) {
+	setup()
+	defer teardown()
+
+	settings := transport.TLSSettings{UseTLS: true, ServerName: "NAME"}
+
+	w := runHandlerTest("PUT", "/HOST", settings, "/{Host}", handleUpdateHostTLS)
+	assert.Equal(t, 403, w.Code)
+
+	SetHostTLS("HOST", settings)
+
+	w = runHandlerTest("DELETE", "/HOST", nil, "/{Host}", handleRemoveHost)
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, settings, GetTLSSettings("HOST"))
+}
@@ -49,6 +49,18 @@ type SelectOptions struct {
 
 type Filter map[string]interface{}
 
+// One of the updates given to UpdateAll
+type Change struct {
+	To    map[string]interface{}
+	Where Filter
+}
+
+/*
+   A Filter value matching any of the values it holds, e.g.
+   Filter{"Address": In{"a", "b"}}. An empty In matches nothing.
+*/
+type In []interface{}
+
 /*
    All compilers must support the following types:
 
@@ -105,6 +117,21 @@ func (this *Table) Reload() {
 	this.init()
 }
 
+/*
+   Brings a table created from an older version of its schema up to
+   date, keeping its rows. See the compiler's CompileMigrate for what
+   can be migrated.
+*/
+func (this *Table) Migrate() error {
+	for _, exec := range this.compiler.CompileMigrate(this.table) {
+		if _, err := this.db.Exec(exec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (this *Table) init() {
 	exec := this.compiler.CompileCreate(this.table)
 	this.db.Exec(exec)
@@ -203,6 +230,29 @@ func (this *Table) Update(to map[string]interface{}, where Filter) error {
 	return err
 }
 
+/*
+   Makes every change in a single transaction, so that either all of
+   them or none of them are made. Changes which match no rows do not
+   fail it.
+*/
+func (this *Table) UpdateAll(changes []Change) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		query, vals := this.compiler.CompileUpdate(this.table, change.To, change.Where)
+
+		if _, err := tx.Exec(query, vals...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (this *Table) SelectRow(columns []string, where Filter, opts *SelectOptions, dest interface{}) error {
 	if len(columns) == 0 {
 		columns = this.allColumns()
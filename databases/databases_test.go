@@ -280,6 +280,43 @@ func Test_Update_NoUpdate(t *testing.T) {
 	assert.Equal(t, NoUpdateError, err)
 }
 
+func Test_UpdateAll(t *testing.T) {
+	db := testDB()
+	table := NewTable(db, "test_table", testSchema)
+
+	changes := []Change{
+		{To: map[string]interface{}{"Age": 42}, Where: Filter{"Name": "Jane Doe"}},
+		{To: map[string]interface{}{"Age": 43}, Where: Filter{"Name": "John Doe"}},
+	}
+
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectExec(`UPDATE`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectCommit()
+
+	err := table.UpdateAll(changes)
+	assert.Nil(t, err)
+
+	if err := db.Close(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	db := testDB()
+
+	sqlmock.ExpectExec(`ALTER`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.Nil(t, NewTable(db, "test_table", testSchema).Migrate())
+
+	if err := db.Close(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
 func Test_SelectRow(t *testing.T) {
 	db := testDB()
 	table := NewTable(db, "test_table", testSchema)
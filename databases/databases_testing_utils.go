@@ -53,6 +53,10 @@ func (t *testCompiler) CompileDrop(tab string) string {
 	return "DROP"
 }
 
+func (t *testCompiler) CompileMigrate(tab string) []string {
+	return []string{"ALTER"}
+}
+
 func (t *testCompiler) CompileInsert(tab string, val map[string]interface{}) (string, []interface{}) {
 	return "INSERT", []interface{}{}
 }
@@ -38,9 +38,11 @@ type TableInterface interface {
 	InsertReturn(map[string]interface{}, []string, *SelectOptions, interface{}) error
 	Delete(Filter) error
 	Update(map[string]interface{}, Filter) error
+	UpdateAll([]Change) error
 	SelectRow([]string, Filter, *SelectOptions, interface{}) error
 	Select([]string, Filter, *SelectOptions) (ScannerInterface, error)
 	Reload()
+	Migrate() error
 }
 
 type ScannerInterface interface {
@@ -57,6 +59,7 @@ type Compiler interface {
 
 	CompileCreate(string) string
 	CompileDrop(string) string
+	CompileMigrate(string) []string
 	CompileInsert(string, map[string]interface{}) (string, []interface{})
 	CompileDelete(string, Filter) (string, []interface{})
 	CompileUpdate(string, map[string]interface{}, Filter) (string, []interface{})
@@ -88,7 +88,10 @@ func CommonTestingScanner(rows [][]interface{}, columns []string) *MockScanner {
 
 		rv := reflect.ValueOf(dest).Elem()
 		for i, colName := range scanner.ColumnNames {
-			rv.FieldByName(colName).Set(reflect.ValueOf(row[i]))
+			// Match Scanner, which leaves fields of NULL columns untouched
+			if row[i] != nil {
+				rv.FieldByName(colName).Set(reflect.ValueOf(row[i]))
+			}
 		}
 
 		return nil
@@ -36,10 +36,36 @@ type MockTable struct {
 	MockInsertReturn func(map[string]interface{}, []string, *SelectOptions, interface{}) error
 	MockDelete       func(Filter) error
 	MockUpdate       func(map[string]interface{}, Filter) error
+	MockUpdateAll    func([]Change) error
 	MockSelectRow    func([]string, Filter, *SelectOptions, interface{}) error
 	MockSelect       func([]string, Filter, *SelectOptions) (ScannerInterface, error)
 
-	MockReload func()
+	MockReload  func()
+	MockMigrate func() error
+}
+
+// Whether the row is one a database would pick out with the filter
+func rowMatches(schema map[string]int, row []interface{}, where Filter) bool {
+	for col, val := range where {
+		options, ok := val.(In)
+		if !ok {
+			options = In{val}
+		}
+
+		found := false
+		for _, option := range options {
+			if reflect.DeepEqual(row[schema[col]], option) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (t *MockTable) Insert(v map[string]interface{}) (e error) {
@@ -70,6 +96,13 @@ func (t *MockTable) Update(to map[string]interface{}, w Filter) (e error) {
 	return
 }
 
+func (t *MockTable) UpdateAll(c []Change) (e error) {
+	if t.MockUpdateAll != nil {
+		return t.MockUpdateAll(c)
+	}
+	return
+}
+
 func (t *MockTable) SelectRow(c []string, w Filter, opts *SelectOptions, d interface{}) (e error) {
 	if t.MockSelectRow != nil {
 		return t.MockSelectRow(c, w, opts, d)
@@ -91,6 +124,13 @@ func (t *MockTable) Reload() {
 	return
 }
 
+func (t *MockTable) Migrate() (e error) {
+	if t.MockMigrate != nil {
+		return t.MockMigrate()
+	}
+	return
+}
+
 func CommonTestingTable(schema Schema) *MockTable {
 	table := &MockTable{Database: make([][]interface{}, 0), Schema: make(map[string]int), lastUpdateRow: 0}
 
@@ -162,13 +202,7 @@ func CommonTestingTable(schema Schema) *MockTable {
 
 		i := 0
 		for _, row := range table.Database {
-			applies := true
-			for col, val := range where {
-				if row[table.Schema[col]] != val {
-					applies = false
-					break
-				}
-			}
+			applies := rowMatches(table.Schema, row, where)
 
 			if applies {
 				toDelete = append(toDelete, i)
@@ -178,8 +212,10 @@ func CommonTestingTable(schema Schema) *MockTable {
 			i = i + 1
 		}
 
-		//cut the appropriate rows from the database
-		for _, rowId := range toDelete {
+		//cut the appropriate rows from the database, last first so
+		//that earlier indices stay valid
+		for k := len(toDelete) - 1; k >= 0; k-- {
+			rowId := toDelete[k]
 			copy(table.Database[rowId:], table.Database[rowId+1:])
 			for j, end := len(table.Database)-1, len(table.Database); j < end; j++ {
 				table.Database[j] = nil
@@ -198,13 +234,7 @@ func CommonTestingTable(schema Schema) *MockTable {
 		updated := false
 		for _, row := range table.Database {
 
-			applies := true
-			for col, val := range where {
-				if row[table.Schema[col]] != val {
-					applies = false
-					break
-				}
-			}
+			applies := rowMatches(table.Schema, row, where)
 
 			if applies {
 				for col, val := range to {
@@ -221,6 +251,23 @@ func CommonTestingTable(schema Schema) *MockTable {
 		return nil
 	}
 
+	table.MockUpdateAll = func(changes []Change) error {
+		before := make([][]interface{}, len(table.Database))
+		for i, row := range table.Database {
+			before[i] = append([]interface{}(nil), row...)
+		}
+
+		for _, change := range changes {
+			err := table.MockUpdate(change.To, change.Where)
+			if err != nil && err != NoUpdateError {
+				table.Database = before
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	table.MockSelectRow = func(cols []string, where Filter, opts *SelectOptions, dest interface{}) error {
 		if opts == nil {
 			opts = &SelectOptions{Top: 1}
@@ -257,13 +304,7 @@ func CommonTestingTable(schema Schema) *MockTable {
 
 		for _, row := range table.Database {
 
-			applies := true
-			for col, val := range where {
-				if !reflect.DeepEqual(row[table.Schema[col]], val) {
-					applies = false
-					break
-				}
-			}
+			applies := rowMatches(table.Schema, row, where)
 
 			newEntry := make([]interface{}, len(cols))
 			for i, col := range cols {
@@ -135,6 +135,41 @@ func (this *postgresCompiler) CompileDrop(table string) string {
 	return fmt.Sprintf(`DROP TABLE %s;`, table)
 }
 
+/*
+   Adds the columns the table is missing, and unique indexes for the
+   columns which have become UNIQUE, named as Postgres names those of
+   UNIQUE columns so that existing ones are kept. Columns are never
+   dropped or changed otherwise.
+*/
+func (this *postgresCompiler) CompileMigrate(table string) []string {
+	var cols []string
+	for col := range this.schema {
+		cols = append(cols, col)
+	}
+
+	sort.Strings(cols)
+
+	var execs []string
+
+	for _, col := range cols {
+		colType := convertDatatype(this.schema[col])
+		upper := strings.ToUpper(colType)
+
+		if strings.Contains(upper, "PRIMARY KEY") {
+			continue
+		}
+
+		execs = append(execs, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;`, table, col, colType))
+
+		if strings.Contains(upper, "UNIQUE") {
+			index := strings.ToLower(fmt.Sprintf("%s_%s_key", table, col))
+			execs = append(execs, fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s);`, index, table, col))
+		}
+	}
+
+	return execs
+}
+
 func (this *postgresCompiler) CompileInsert(table string, values map[string]interface{}) (string, []interface{}) {
 	var valBuf bytes.Buffer
 	queryVals := make([]interface{}, len(values))
@@ -171,33 +206,65 @@ func (this *postgresCompiler) CompileInsert(table string, values map[string]inte
 
 func (this *postgresCompiler) CompileDelete(table string, where databases.Filter) (string, []interface{}) {
 	var buffer bytes.Buffer
-	vals := make([]interface{}, len(where))
 
 	buffer.WriteString("DELETE FROM ")
 	buffer.WriteString(table)
 
-	if len(where) > 0 {
-		buffer.WriteString(" WHERE ")
+	clause, vals := this.compileWhere(where, 1)
+	buffer.WriteString(clause)
+
+	buffer.WriteString(";")
+
+	return buffer.String(), vals
+}
+
+/*
+   Compiles the filter into a WHERE clause, numbering its parameters
+   from first. Columns filtered by an In match any of its values.
+
+   RETURN: The clause, empty if the filter is, and its parameters
+*/
+func (this *postgresCompiler) compileWhere(where databases.Filter, first int) (string, []interface{}) {
+	if len(where) == 0 {
+		return "", nil
+	}
+
+	var buffer bytes.Buffer
+	var vals []interface{}
+
+	var whereKeys []string
+	for col, _ := range where {
+		whereKeys = append(whereKeys, col)
+	}
+	sort.Strings(whereKeys)
+
+	buffer.WriteString(" WHERE ")
 
-		var whereKeys []string
-		for col, _ := range where {
-			whereKeys = append(whereKeys, col)
+	for i, col := range whereKeys {
+		if i != 0 {
+			buffer.WriteString(" AND ")
 		}
-		sort.Strings(whereKeys)
 
-		for i, col := range whereKeys {
-			val := where[col]
-			if i != 0 {
-				buffer.WriteString(" AND ")
-			}
+		in, ok := where[col].(databases.In)
+		if !ok {
+			buffer.WriteString(fmt.Sprintf("%s = ($%d)", col, first+len(vals)))
+			vals = append(vals, this.ConvertInput(where[col], col))
+			continue
+		}
 
-			buffer.WriteString(fmt.Sprintf("%s = ($%d)", col, i+1))
+		if len(in) == 0 {
+			buffer.WriteString("FALSE")
+			continue
+		}
 
-			vals[i] = this.ConvertInput(val, col)
+		params := make([]string, len(in))
+		for j, val := range in {
+			params[j] = fmt.Sprintf("($%d)", first+len(vals))
+			vals = append(vals, this.ConvertInput(val, col))
 		}
-	}
 
-	buffer.WriteString(";")
+		buffer.WriteString(fmt.Sprintf("%s IN (%s)", col, strings.Join(params, ", ")))
+	}
 
 	return buffer.String(), vals
 }
@@ -209,20 +276,15 @@ func (this *postgresCompiler) CompileUpdate(table string, to map[string]interfac
 	buffer.WriteString(table)
 	buffer.WriteString(" SET ")
 
-	vals := make([]interface{}, len(where)+len(to))
-	var toKeys, whereKeys []string
+	vals := make([]interface{}, len(to))
+	var toKeys []string
 	i := 1
 
 	for col, _ := range to {
 		toKeys = append(toKeys, col)
 	}
 
-	for col, _ := range where {
-		whereKeys = append(whereKeys, col)
-	}
-
 	sort.Strings(toKeys)
-	sort.Strings(whereKeys)
 
 	for _, col := range toKeys {
 		val := to[col]
@@ -236,19 +298,9 @@ func (this *postgresCompiler) CompileUpdate(table string, to map[string]interfac
 		i += 1
 	}
 
-	buffer.WriteString(" WHERE ")
-
-	for _, col := range whereKeys {
-		val := where[col]
-		if i != len(to)+1 {
-			buffer.WriteString(" AND ")
-		}
-
-		buffer.WriteString(fmt.Sprintf("%s = ($%d)", col, i))
-
-		vals[i-1] = this.ConvertInput(val, col)
-		i += 1
-	}
+	clause, whereVals := this.compileWhere(where, i)
+	buffer.WriteString(clause)
+	vals = append(vals, whereVals...)
 
 	buffer.WriteString(";")
 
@@ -273,29 +325,8 @@ func (this *postgresCompiler) CompileSelect(table string, cols []string, where d
 	buffer.WriteString(" FROM ")
 	buffer.WriteString(table)
 
-	whereVals := make([]interface{}, len(where))
-	if where != nil {
-
-		buffer.WriteString(" WHERE ")
-
-		var whereKeys []string
-		for col, _ := range where {
-			whereKeys = append(whereKeys, col)
-		}
-
-		sort.Strings(whereKeys)
-
-		for i, col := range whereKeys {
-			val := where[col]
-			if i != 0 {
-				buffer.WriteString(" AND ")
-			}
-
-			buffer.WriteString(fmt.Sprintf("%s = ($%d)", col, i+1))
-
-			whereVals[i] = this.ConvertInput(val, col)
-		}
-	}
+	clause, whereVals := this.compileWhere(where, 1)
+	buffer.WriteString(clause)
 
 	if opts.OrderBy != nil {
 		buffer.WriteString(" ORDER BY ")
@@ -98,6 +98,22 @@ func Test_CompileCreate(t *testing.T) {
 	assert.Equal(t, key, exec)
 }
 
+func Test_CompileMigrate(t *testing.T) {
+	schema := databases.Schema{
+		"Name":  "text UNIQUE PRIMARY KEY",
+		"Alias": "text UNIQUE",
+		"Data":  "json",
+	}
+
+	comp := &postgresCompiler{schema}
+
+	assert.Equal(t, []string{
+		"ALTER TABLE TABLE ADD COLUMN IF NOT EXISTS Alias text UNIQUE;",
+		"CREATE UNIQUE INDEX IF NOT EXISTS table_alias_key ON TABLE (Alias);",
+		"ALTER TABLE TABLE ADD COLUMN IF NOT EXISTS Data text;",
+	}, comp.CompileMigrate("TABLE"))
+}
+
 func TestCompileDrop(t *testing.T) {
 	comp := &postgresCompiler{testSchema}
 	exec := comp.CompileDrop("TABLE")
@@ -171,6 +187,25 @@ func Test_CompileSelect_Default(t *testing.T) {
 	assert.Equal(t, "Sam", vars[1])
 }
 
+func Test_CompileSelect_In(t *testing.T) {
+	columns := []string{"Phone"}
+	where := databases.Filter{"Age": 1, "Name": databases.In{"Sam", "Pete"}}
+
+	comp := &postgresCompiler{testSchema}
+	query, vars := comp.CompileSelect("TABLE", columns, where, nil)
+
+	key := "SELECT Phone FROM TABLE WHERE Age = ($1) AND Name IN (($2), ($3));"
+
+	assert.Equal(t, key, query)
+	assert.Equal(t, []interface{}{1, "Sam", "Pete"}, vars)
+
+	where = databases.Filter{"Name": databases.In{}}
+	query, vars = comp.CompileSelect("TABLE", columns, where, nil)
+
+	assert.Equal(t, "SELECT Phone FROM TABLE WHERE FALSE;", query)
+	assert.Equal(t, 0, len(vars))
+}
+
 func Test_CompileQuery_Options(t *testing.T) {
 	opts := databases.SelectOptions{
 		Distinct: true,
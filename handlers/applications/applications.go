@@ -20,7 +20,10 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/lighthouse/lighthouse/beacons"
 	"github.com/lighthouse/lighthouse/databases"
+	"github.com/lighthouse/lighthouse/handlers/docker"
+	"github.com/lighthouse/lighthouse/registries"
 )
 
 var (
@@ -31,8 +34,19 @@ var (
 	ImageNotPulledError        = errors.New("applications: deployment failed to pull an image")
 	NotEnoughParametersError   = errors.New("applications: not enough or invalid parameters given")
 	ApplicationPermissionError = errors.New("applications: user not permitted to modify application")
+	StreamingUnsupportedError  = errors.New("applications: streaming not supported")
+	TooManyInstancesError      = errors.New("applications: too many instances to follow the logs of at once")
 )
 
+/*
+   Entries of an application's instance list which start with this
+   prefix are label selectors (see beacons.ParseSelector) rather than
+   instance addresses, e.g. "selector:env=prod,region=us-east". They
+   are resolved to the matching instances whenever the application
+   is deployed, started or stopped.
+*/
+const SELECTOR_PREFIX = "selector:"
+
 var applications databases.TableInterface
 var deployments databases.TableInterface
 
@@ -41,6 +55,7 @@ var appSchema = databases.Schema{
 	"CurrentDeployment": "bigint",
 	"Name":              "text UNIQUE",
 	"Instances":         "json",
+	"Credential":        "text",
 }
 
 var deploySchema = databases.Schema{
@@ -51,11 +66,18 @@ var deploySchema = databases.Schema{
 	"Date":    "datetime DEFAULT current_timestamp",
 }
 
+/*
+   Credential names the registry credential (see the registries
+   package) which images are pulled with, if any. Without one, a
+   credential for the image's registry is picked from those the
+   deploying user can access.
+*/
 type applicationData struct {
 	Id                int64
 	CurrentDeployment int64
 	Name              string
 	Instances         interface{}
+	Credential        string
 }
 
 type deploymentData struct {
@@ -79,6 +101,11 @@ func Init(reload bool) {
 		applications.Reload()
 		deployments.Reload()
 	}
+
+	beacons.AddInstanceReferenceFunc(getApplicationsUsing)
+	registries.AddCredentialReferenceFunc(getApplicationsUsingCredential)
+	docker.SetApplicationFunc(getApplicationOf)
+	docker.SetApplicationIndexFunc(getApplicationIndex)
 }
 
 func GetApplicationById(Id int64) (applicationData, error) {
@@ -125,4 +152,8 @@ func Handle(r *mux.Router) {
 	r.HandleFunc("/revert/{Id:.*}", handleRevertApplication).Methods("PUT")
 
 	r.HandleFunc("/update/{Id:.*}", handleUpdateApplication).Methods("PUT")
+
+	r.HandleFunc("/logs/{Id:.*}", handleApplicationLogs).Methods("GET")
+
+	r.HandleFunc("/stats/{Id:.*}", handleApplicationStats).Methods("GET")
 }
@@ -19,6 +19,8 @@ import (
 
 	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/registries"
 )
 
 func SetupTestingTable() {
@@ -37,6 +39,7 @@ func makeDatabaseEntryFor(app applicationData) map[string]interface{} {
 		"CurrentDeployment": app.CurrentDeployment,
 		"Name":              app.Name,
 		"Instances":         app.Instances,
+		"Credential":        app.Credential,
 	}
 }
 
@@ -44,10 +47,26 @@ func setup() {
 	SetupTestingTable()
 	auth.SetupTestingTable()
 	beacons.SetupTestingTable()
+	aliases.SetupTestingTable()
+	registries.SetupTestingTable()
 }
 
 func teardown() {
 	TeardownTestingTable()
 	auth.TeardownTestingTable()
 	beacons.TeardownTestingTable()
+	aliases.TeardownTestingTable()
+	registries.TeardownTestingTable()
+}
+
+/*
+   Creates the "email" user, with access to the Docker hosts which
+   tests start on 127.0.0.1.
+*/
+func createTestingUser() *auth.User {
+	auth.CreateUser("email", "", "")
+	user, _ := auth.GetUser("email")
+	auth.SetUserHostAuthLevel(user, "127.0.0.1:*", auth.AccessAuthLevel)
+
+	return user
 }
@@ -29,6 +29,7 @@ import (
 	"github.com/lighthouse/lighthouse/databases"
 	"github.com/lighthouse/lighthouse/handlers"
 	"github.com/lighthouse/lighthouse/handlers/batch"
+	"github.com/lighthouse/lighthouse/registries"
 )
 
 func getBoolParamOrDefault(r *http.Request, param string, def bool) bool {
@@ -66,6 +67,11 @@ func writeError(w http.ResponseWriter, err error) {
 		return
 	}
 
+	if err == batch.TooManyOperationsError {
+		handlers.WriteTooManyRequests(w, "applications", err.Error(), batch.OperationRetryAfter)
+		return
+	}
+
 	code, ok := map[error]int{
 		UnknownApplicationError:    404,
 		UnknownDeploymentError:     404,
@@ -73,7 +79,13 @@ func writeError(w http.ResponseWriter, err error) {
 		NotEnoughDeploymentsError:  400,
 		NotEnoughParametersError:   400,
 		ApplicationPermissionError: 403,
+		StreamingUnsupportedError:  501,
+		TooManyInstancesError:      400,
 		databases.NoUpdateError:    400,
+
+		registries.UnknownCredentialError:    400,
+		registries.RegistryMismatchError:     400,
+		registries.CredentialPermissionError: 403,
 	}[err]
 
 	if !ok {
@@ -125,9 +137,10 @@ func handleCreateApplication(w http.ResponseWriter, r *http.Request) {
 	}
 
 	create := struct {
-		Name      string
-		Command   map[string]interface{}
-		Instances []interface{}
+		Name       string
+		Command    map[string]interface{}
+		Instances  []interface{}
+		Credential string
 	}{}
 
 	err = json.Unmarshal(body, &create)
@@ -136,7 +149,7 @@ func handleCreateApplication(w http.ResponseWriter, r *http.Request) {
 	}
 
 	instanceList, ok := convertInstanceList(create.Instances)
-	if !ok {
+	if !ok || !validateInstanceList(instanceList) {
 		err = NotEnoughParametersError
 		return
 	}
@@ -146,11 +159,31 @@ func handleCreateApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if create.Credential != "" {
+		if err = registries.CheckCredential(user, create.Credential); err != nil {
+			return
+		}
+	}
+
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	application, err := addApplication(create.Name, instanceList)
 	if err != nil {
 		return
 	}
 
+	if create.Credential != "" {
+		if err = setApplicationCredential(application.Id, create.Credential); err != nil {
+			removeApplication(application.Id)
+			return
+		}
+		application.Credential = create.Credential
+	}
+
 	deployment, err := addDeployment(application.Id, create.Command, user.Email)
 	if err != nil {
 		removeApplication(application.Id)
@@ -162,8 +195,9 @@ func handleCreateApplication(w http.ResponseWriter, r *http.Request) {
 		removeDeployment(deployment.Id)
 		removeApplication(application.Id)
 
-		if deployErr == NotEnoughParametersError {
-			err = NotEnoughParametersError
+		// Errors are only returned before anything was written
+		if deployErr != nil {
+			err = deployErr
 			return
 		}
 	} else {
@@ -252,6 +286,12 @@ func handleStartApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	startApplication(user, app.Id, w)
 	batch.Finalize(w)
 }
@@ -279,6 +319,12 @@ func handleStopApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	stopApplication(user, app.Id, w)
 	batch.Finalize(w)
 }
@@ -308,12 +354,22 @@ func handleRevertApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	deploy, err := getRevertDeployment(id, target)
 	if err != nil {
 		return
 	}
 
-	doDeployment(user, app, deploy, false, pull, w)
+	if deployErr, _ := doDeployment(user, app, deploy, false, pull, w); deployErr != nil {
+		err = deployErr
+		return
+	}
+
 	batch.Finalize(w)
 }
 
@@ -341,15 +397,22 @@ func handleUpdateApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return
 	}
 
 	update := struct {
-		Command map[string]interface{}
-		Add     []interface{}
-		Remove  []interface{}
+		Command    map[string]interface{}
+		Add        []interface{}
+		Remove     []interface{}
+		Credential *string
 	}{}
 
 	err = json.Unmarshal(body, &update)
@@ -359,7 +422,7 @@ func handleUpdateApplication(w http.ResponseWriter, r *http.Request) {
 
 	addList, addOK := convertInstanceList(update.Add)
 	removeList, removeOK := convertInstanceList(update.Remove)
-	if !addOK || !removeOK {
+	if !addOK || !removeOK || !validateInstanceList(addList) {
 		err = NotEnoughParametersError
 		return
 	}
@@ -374,7 +437,41 @@ func handleUpdateApplication(w http.ResponseWriter, r *http.Request) {
 
 	willDeploy := restart || len(update.Command) > 0
 
+	if update.Credential != nil {
+		if *update.Credential != "" {
+			if err = registries.CheckCredential(user, *update.Credential); err != nil {
+				return
+			}
+		}
+		app.Credential = *update.Credential
+	}
+
+	// Fail before changing anything if the image cannot be pulled
+	if willDeploy || len(addList) > 0 {
+		command := deployment.Command
+		if len(update.Command) > 0 {
+			command = update.Command
+		}
+
+		_, err = registries.AuthHeaderFor(user, fmt.Sprint(command["Image"]), app.Credential)
+		if err != nil {
+			return
+		}
+	}
+
+	if update.Credential != nil {
+		if err = setApplicationCredential(app.Id, app.Credential); err != nil {
+			return
+		}
+	}
+
+	// Only instances the new list no longer resolves to lose their
+	// container, even if an entry naming them was removed
+	var removed []string
+
 	if len(addList) > 0 || len(removeList) > 0 {
+		before := resolveInstances(user, app.Instances.([]string))
+
 		app.Instances = getDifferenceOf(app.Instances.([]string), removeList)
 
 		// Ensure no duplicate instances
@@ -403,6 +500,8 @@ func handleUpdateApplication(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return
 		}
+
+		removed = getDifferenceOf(before, resolveInstances(user, app.Instances.([]string)))
 	}
 
 	if len(update.Command) > 0 {
@@ -418,8 +517,8 @@ func handleUpdateApplication(w http.ResponseWriter, r *http.Request) {
 		willDeploy = true
 	}
 
-	if len(removeList) > 0 {
-		proc := batch.NewProcessor(user, w, removeList)
+	if len(removed) > 0 {
+		proc := batch.NewProcessor(user, w, removed)
 		batchDeleteContainersByName(proc, app.Name, false)
 	}
 
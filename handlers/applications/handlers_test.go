@@ -33,6 +33,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
 	"github.com/lighthouse/lighthouse/databases"
 	"github.com/lighthouse/lighthouse/handlers/batch"
 	"github.com/lighthouse/lighthouse/session"
@@ -42,8 +43,7 @@ func Test_HandleCreateApplication(t *testing.T) {
 	setup()
 	defer teardown()
 
-	auth.CreateUser("email", "", "")
-	user, _ := auth.GetUser("email")
+	user := createTestingUser()
 
 	m := mux.NewRouter()
 	m.HandleFunc("/create", handleCreateApplication)
@@ -355,6 +355,39 @@ func Test_HandleStartAndStopApplication(t *testing.T) {
 	}
 }
 
+func Test_HandleStartApplication_TooManyOperations(t *testing.T) {
+	setup()
+	defer teardown()
+
+	defer func(max int) { batch.MaxUserOperations = max }(batch.MaxUserOperations)
+	batch.MaxUserOperations = 1
+
+	user := createTestingUser()
+	app, _ := addApplication("TestApp", []string{})
+	auth.SetUserApplicationAuthLevel(user, app.Name, auth.OwnerAuthLevel)
+
+	m := mux.NewRouter()
+	m.HandleFunc("/start/{Id}", handleStartApplication)
+
+	start := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/start/TestApp", nil)
+		session.SetValue(req, "auth", "email", user.Email)
+
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		return w
+	}
+
+	release, _ := batch.Acquire(user)
+
+	w := start()
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	release()
+	assert.Equal(t, 200, start().Code)
+}
+
 func Test_HandleRevertApplication(t *testing.T) {
 	setup()
 	defer teardown()
@@ -480,8 +513,7 @@ func Test_HandleUpdateApplication(t *testing.T) {
 
 	command := map[string]interface{}{"Image": "test", "Pass": true}
 
-	auth.CreateUser("email", "", "")
-	user, _ := auth.GetUser("email")
+	user := createTestingUser()
 
 	app, _ := addApplication("TestApp", initialInsts)
 	dep, _ := addDeployment(app.Id, map[string]interface{}{}, user.Email)
@@ -525,3 +557,49 @@ func Test_HandleUpdateApplication(t *testing.T) {
 	assert.Equal(t, command, testDep.Command)
 	assert.Equal(t, app.Id, testDep.AppId)
 }
+
+func Test_HandleUpdateApplication_RemoveResolved(t *testing.T) {
+	setup()
+	defer teardown()
+
+	deleted := make(chan string, 2)
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			deleted <- r.Host
+		}
+		w.WriteHeader(200)
+	}
+
+	insts, servers := batch.SetupServers(h)
+	defer batch.ShutdownServers(servers)
+
+	user := createTestingUser()
+
+	// Still deployed to through the selector once its own entry is gone
+	beacons.AddTestingInstance("INST", "BEACON", map[string]string{"env": "prod"})
+	auth.SetUserBeaconAuthLevel(user, "BEACON", auth.AccessAuthLevel)
+
+	app, _ := addApplication("TestApp", []string{insts[0], "INST", SELECTOR_PREFIX + "env=prod"})
+	dep, _ := addDeployment(app.Id, map[string]interface{}{}, user.Email)
+	applications.Update(map[string]interface{}{"CurrentDeployment": dep.Id}, databases.Filter{"Id": app.Id})
+	auth.SetUserApplicationAuthLevel(user, app.Name, auth.OwnerAuthLevel)
+
+	m := mux.NewRouter()
+	m.HandleFunc("/update/{Id}", handleUpdateApplication)
+
+	data, _ := json.Marshal(map[string][]string{"Remove": {insts[0], "INST"}})
+	req, _ := http.NewRequest("PUT", "/update/TestApp", bytes.NewBuffer(data))
+	session.SetValue(req, "auth", "email", user.Email)
+	w := httptest.NewRecorder()
+
+	m.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	testApp, _ := GetApplicationById(app.Id)
+	assert.Equal(t, []string{SELECTOR_PREFIX + "env=prod"}, testApp.Instances)
+
+	assert.Equal(t, 1, len(deleted))
+	assert.Equal(t, insts[0], <-deleted)
+	assert.NotContains(t, w.Body.String(), `"Instance":"INST"`)
+}
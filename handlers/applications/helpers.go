@@ -20,10 +20,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
 	"github.com/lighthouse/lighthouse/databases"
 	"github.com/lighthouse/lighthouse/handlers/batch"
+	"github.com/lighthouse/lighthouse/registries"
 )
 
 func addApplication(name string, instances []string) (applicationData, error) {
@@ -31,6 +34,7 @@ func addApplication(name string, instances []string) (applicationData, error) {
 		"Name":              name,
 		"Instances":         instances,
 		"CurrentDeployment": int64(-1),
+		"Credential":        "",
 	}
 
 	opts := databases.SelectOptions{Top: 1, OrderBy: []string{"Id"}, Desc: true}
@@ -58,6 +62,12 @@ func addDeployment(app int64, cmd interface{}, email string) (deploymentData, er
 	return deploy, err
 }
 
+func setApplicationCredential(app int64, credential string) error {
+	to := map[string]interface{}{"Credential": credential}
+	where := databases.Filter{"Id": app}
+	return applications.Update(to, where)
+}
+
 func removeApplication(app int64) error {
 	where := databases.Filter{"Id": app}
 	return applications.Delete(where)
@@ -77,7 +87,7 @@ func stopApplication(user *auth.User, app int64, w http.ResponseWriter) error {
 }
 
 func doDeployment(user *auth.User, app applicationData, deployment deploymentData, startApp, pullImages bool, w http.ResponseWriter) (error, bool) {
-	deploy := batch.NewProcessor(user, w, app.Instances.([]string))
+	deploy := batch.NewProcessor(user, w, resolveInstances(user, app.Instances.([]string)))
 
 	if pullImages {
 		image, ok := deployment.Command["Image"]
@@ -85,8 +95,18 @@ func doDeployment(user *auth.User, app applicationData, deployment deploymentDat
 			return NotEnoughParametersError, false
 		}
 
+		registryAuth, err := registries.AuthHeaderFor(user, fmt.Sprint(image), app.Credential)
+		if err != nil {
+			return err, false
+		}
+
+		header := http.Header{}
+		if registryAuth != "" {
+			header.Set("X-Registry-Auth", registryAuth)
+		}
+
 		pullTarget := fmt.Sprintf("images/create?fromImage=%s", image)
-		err := deploy.Do("Pulling required image", "POST", nil, pullTarget, interpretPullImage)
+		err = deploy.DoWithHeader("Pulling required image", "POST", header, nil, pullTarget, interpretPullImage)
 
 		if err != nil {
 			return nil, false
@@ -158,6 +178,123 @@ func getApplicationList(user *auth.User) ([]applicationData, error) {
 	return apps, nil
 }
 
+/*
+   RETURN: The names of all applications which pull with the credential
+*/
+func getApplicationsUsingCredential(credential string) []string {
+	names := make([]string, 0)
+	where := databases.Filter{"Credential": credential}
+
+	scanner, err := applications.Select([]string{"Name"}, where, nil)
+	if err != nil {
+		return names
+	}
+
+	defer scanner.Close()
+
+	for scanner.Next() {
+		var app applicationData
+		if scanner.Scan(&app) == nil {
+			names = append(names, app.Name)
+		}
+	}
+
+	return names
+}
+
+/*
+   RETURN: The names of all applications deployed to any of the instances
+*/
+func getApplicationsUsing(instances []string) []string {
+	names := make([]string, 0)
+
+	scanner, err := applications.Select(nil, nil, nil)
+	if err != nil {
+		return names
+	}
+
+	lookup := make(map[string]bool)
+	for _, inst := range instances {
+		lookup[inst] = true
+	}
+
+	for scanner.Next() {
+		var app applicationData
+		if scanner.Scan(&app) != nil {
+			continue
+		}
+
+		appInstances, _ := convertInstanceList(app.Instances)
+		appInstances = resolveInstances(nil, appInstances)
+
+		for _, inst := range appInstances {
+			if lookup[inst] {
+				names = append(names, app.Name)
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+/*
+   Deployments name an application's containers after it, so a
+   container is part of the application of the same name if that
+   application is deployed to the container's instance.
+
+   RETURN: The name of the application the container belongs to
+*/
+func getApplicationOf(instance, container string) (string, bool) {
+	app, err := GetApplicationByName(container)
+	if err != nil {
+		return "", false
+	}
+
+	for _, inst := range resolveInstances(nil, app.Instances.([]string)) {
+		if inst == instance {
+			return app.Name, true
+		}
+	}
+
+	return "", false
+}
+
+/*
+   Like getApplicationOf for every container at once, resolving each
+   application's instances only once.
+
+   RETURN: The names of the applications deployed to each instance
+*/
+func getApplicationIndex() map[string]map[string]bool {
+	index := make(map[string]map[string]bool)
+
+	scanner, err := applications.Select(nil, nil, nil)
+	if err != nil {
+		return index
+	}
+
+	defer scanner.Close()
+
+	for scanner.Next() {
+		var app applicationData
+		if scanner.Scan(&app) != nil {
+			continue
+		}
+
+		appInstances, _ := convertInstanceList(app.Instances)
+
+		for _, inst := range resolveInstances(nil, appInstances) {
+			if index[inst] == nil {
+				index[inst] = make(map[string]bool)
+			}
+			index[inst][app.Name] = true
+		}
+	}
+
+	return index
+}
+
 func getApplicationHistory(user *auth.User, app applicationData) ([]map[string]interface{}, error) {
 	if !user.CanAccessApplication(app.Name) {
 		return []map[string]interface{}{}, nil
@@ -218,7 +355,7 @@ func setApplicationStateTo(user *auth.User, id int64, state bool, w http.Respons
 
 	w.WriteHeader(200)
 
-	toggle := batch.NewProcessor(user, w, app.Instances.([]string))
+	toggle := batch.NewProcessor(user, w, resolveInstances(user, app.Instances.([]string)))
 
 	err = toggle.Do(msg, "POST", nil, target, nil)
 	if err != nil {
@@ -287,6 +424,58 @@ func batchDeleteContainersByName(proc *batch.Processor, name string, isRollback
 	return proc.Do(msg, "DELETE", nil, deleteTarget, interpretDeleteContainer)
 }
 
+/*
+   Replaces any label selectors in the list with the addresses of the
+   instances they currently match on beacons the user can access, or
+   on every beacon if the user is nil. Duplicates are dropped.
+*/
+func resolveInstances(user *auth.User, list []string) []string {
+	resolved := make([]string, 0, len(list))
+	seen := make(map[string]bool)
+
+	add := func(inst string) {
+		if !seen[inst] {
+			seen[inst] = true
+			resolved = append(resolved, inst)
+		}
+	}
+
+	for _, inst := range list {
+		if !strings.HasPrefix(inst, SELECTOR_PREFIX) {
+			add(inst)
+			continue
+		}
+
+		selector, err := beacons.ParseSelector(strings.TrimPrefix(inst, SELECTOR_PREFIX))
+		if err != nil {
+			continue
+		}
+
+		matches, _ := beacons.GetInstancesMatchingFor(user, selector)
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	return resolved
+}
+
+func validateInstanceList(list []string) bool {
+	for _, inst := range list {
+		if !strings.HasPrefix(inst, SELECTOR_PREFIX) {
+			continue
+		}
+
+		// An empty selector would match every instance
+		selector, err := beacons.ParseSelector(strings.TrimPrefix(inst, SELECTOR_PREFIX))
+		if err != nil || len(selector) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
 func convertInstanceList(inter interface{}) ([]string, bool) {
 	if inter == nil {
 		return []string{}, true
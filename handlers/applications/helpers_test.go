@@ -18,6 +18,8 @@ import (
 	"testing"
 
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -28,8 +30,10 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
 	"github.com/lighthouse/lighthouse/databases"
 	"github.com/lighthouse/lighthouse/handlers/batch"
+	"github.com/lighthouse/lighthouse/registries"
 )
 
 func Test_AddApplication_New(t *testing.T) {
@@ -133,8 +137,7 @@ func Test_StartStopApplication_Normal(t *testing.T) {
 	setup()
 	defer teardown()
 
-	auth.CreateUser("email", "", "")
-	user, _ := auth.GetUser("email")
+	user := createTestingUser()
 
 	type testCase struct {
 		Id            int64 // Valid app is Id 0
@@ -195,8 +198,7 @@ func Test_SetApplicationStateTo_Unknown(t *testing.T) {
 	setup()
 	defer teardown()
 
-	auth.CreateUser("email", "", "")
-	user, _ := auth.GetUser("email")
+	user := createTestingUser()
 
 	_, servers := batch.SetupServers(nil)
 	defer batch.ShutdownServers(servers)
@@ -229,6 +231,107 @@ func Test_GetApplicationList(t *testing.T) {
 	assert.Equal(t, mod, apps[2])
 }
 
+func Test_GetApplicationsUsing(t *testing.T) {
+	setup()
+	defer teardown()
+
+	addApplication("ONE", []string{"Inst1", "Inst2"})
+	addApplication("TWO", []string{"Inst2"})
+	addApplication("THREE", []string{"Inst3"})
+
+	assert.Equal(t, []string{"ONE", "TWO"}, getApplicationsUsing([]string{"Inst2"}))
+	assert.Equal(t, []string{"ONE", "THREE"}, getApplicationsUsing([]string{"Inst1", "Inst3"}))
+	assert.Equal(t, []string{}, getApplicationsUsing([]string{"Other"}))
+}
+
+func Test_GetApplicationsUsingCredential(t *testing.T) {
+	setup()
+	defer teardown()
+
+	one, _ := addApplication("ONE", []string{"Inst1"})
+	two, _ := addApplication("TWO", []string{"Inst2"})
+	addApplication("THREE", []string{"Inst3"})
+
+	setApplicationCredential(one.Id, "CRED")
+	setApplicationCredential(two.Id, "CRED")
+
+	assert.Equal(t, []string{"ONE", "TWO"}, getApplicationsUsingCredential("CRED"))
+	assert.Equal(t, []string{}, getApplicationsUsingCredential("OTHER"))
+}
+
+func Test_GetApplicationOf(t *testing.T) {
+	setup()
+	defer teardown()
+
+	beacons.AddTestingInstance("Inst2", "BEACON", map[string]string{"env": "prod"})
+
+	addApplication("ONE", []string{"Inst1"})
+	addApplication("TWO", []string{SELECTOR_PREFIX + "env=prod"})
+
+	app, ok := getApplicationOf("Inst1", "ONE")
+	assert.True(t, ok)
+	assert.Equal(t, "ONE", app)
+
+	app, ok = getApplicationOf("Inst2", "TWO")
+	assert.True(t, ok)
+	assert.Equal(t, "TWO", app)
+
+	_, ok = getApplicationOf("Inst2", "ONE")
+	assert.False(t, ok)
+
+	_, ok = getApplicationOf("Inst1", "OTHER")
+	assert.False(t, ok)
+}
+
+func Test_GetApplicationIndex(t *testing.T) {
+	setup()
+	defer teardown()
+
+	beacons.AddTestingInstance("Inst2", "BEACON", map[string]string{"env": "prod"})
+
+	addApplication("ONE", []string{"Inst1", "Inst2"})
+	addApplication("TWO", []string{SELECTOR_PREFIX + "env=prod"})
+
+	assert.Equal(t, map[string]map[string]bool{
+		"Inst1": {"ONE": true},
+		"Inst2": {"ONE": true, "TWO": true},
+	}, getApplicationIndex())
+}
+
+func Test_ResolveInstances(t *testing.T) {
+	setup()
+	defer teardown()
+
+	beacons.AddTestingInstance("Inst1", "BEACON", map[string]string{"env": "prod"})
+	beacons.AddTestingInstance("Inst2", "BEACON", map[string]string{"env": "prod"})
+	beacons.AddTestingInstance("Inst3", "BEACON", map[string]string{"env": "dev"})
+
+	beacons.AddTestingInstance("Inst4", "OTHER", map[string]string{"env": "prod"})
+
+	resolved := resolveInstances(nil, []string{"Inst2", SELECTOR_PREFIX + "env=prod", "Other"})
+	assert.Equal(t, []string{"Inst2", "Inst1", "Inst4", "Other"}, resolved)
+
+	resolved = resolveInstances(nil, []string{SELECTOR_PREFIX + "env=none"})
+	assert.Equal(t, []string{}, resolved)
+
+	// Selectors only match instances of beacons the user can access
+	user := createTestingUser()
+	auth.SetUserBeaconAuthLevel(user, "BEACON", auth.AccessAuthLevel)
+
+	resolved = resolveInstances(user, []string{SELECTOR_PREFIX + "env=prod"})
+	assert.Equal(t, []string{"Inst1", "Inst2"}, resolved)
+
+	addApplication("SELECTED", []string{SELECTOR_PREFIX + "env=dev"})
+	assert.Equal(t, []string{"SELECTED"}, getApplicationsUsing([]string{"Inst3"}))
+}
+
+func Test_ValidateInstanceList(t *testing.T) {
+	assert.True(t, validateInstanceList([]string{"Inst", SELECTOR_PREFIX + "env=prod"}))
+	assert.False(t, validateInstanceList([]string{SELECTOR_PREFIX + "env==prod"}))
+	assert.False(t, validateInstanceList([]string{SELECTOR_PREFIX}))
+	assert.False(t, validateInstanceList([]string{SELECTOR_PREFIX + " , "}))
+}
+
 func Test_GetApplicationHistory_OK(t *testing.T) {
 	setup()
 	defer teardown()
@@ -320,8 +423,7 @@ func Test_DoDeployment(t *testing.T) {
 	setup()
 	defer teardown()
 
-	auth.CreateUser("email", "", "")
-	user, _ := auth.GetUser("email")
+	user := createTestingUser()
 
 	type testCase struct {
 		Deploy *deploymentData
@@ -419,6 +521,62 @@ func Test_DoDeployment(t *testing.T) {
 	}
 }
 
+func Test_DoDeployment_RegistryAuth(t *testing.T) {
+	setup()
+	defer teardown()
+
+	user := createTestingUser()
+	registries.AddTestingCredential(user, "CRED", "registry.example.com", "robot", "secret")
+	registries.AddTestingCredential(user, "HUB", "docker.io", "hub", "secret")
+
+	headers := make(chan string, 1)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/images/create") {
+			headers <- r.Header.Get("X-Registry-Auth")
+		}
+	}
+
+	insts, servers := batch.SetupServers(h)
+	defer batch.ShutdownServers(servers)
+
+	app, _ := addApplication("TestApp", insts)
+
+	cmd := map[string]interface{}{"Image": "registry.example.com/team/app"}
+	deployment := deploymentData{42, 0, cmd, "email", time.Now()}
+
+	err, ok := doDeployment(user, app, deployment, false, true, httptest.NewRecorder())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	data, _ := base64.URLEncoding.DecodeString(<-headers)
+
+	var config map[string]string
+	json.Unmarshal(data, &config)
+	assert.Equal(t, "robot", config["username"])
+	assert.Equal(t, "registry.example.com", config["serveraddress"])
+
+	// A named credential must belong to the image's registry
+	setApplicationCredential(app.Id, "HUB")
+	app, _ = GetApplicationById(app.Id)
+
+	err, ok = doDeployment(user, app, deployment, false, true, httptest.NewRecorder())
+	assert.Equal(t, registries.RegistryMismatchError, err)
+	assert.False(t, ok)
+
+	// Users who cannot access the credential may not deploy with it
+	setApplicationCredential(app.Id, "CRED")
+	app, _ = GetApplicationById(app.Id)
+
+	auth.CreateUser("OTHER", "", "")
+	other, _ := auth.GetUser("OTHER")
+	auth.SetUserApplicationAuthLevel(other, app.Name, auth.ModifyAuthLevel)
+
+	err, ok = doDeployment(other, app, deployment, false, true, httptest.NewRecorder())
+	assert.Equal(t, registries.CredentialPermissionError, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(headers))
+}
+
 func Test_ConvertInstanceList(t *testing.T) {
 	type testCase struct {
 		List interface{}
@@ -0,0 +1,391 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package applications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/handlers/batch"
+	"github.com/lighthouse/lighthouse/handlers/docker"
+)
+
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+	LogStreamError  = "error" // Lighthouse failed to read the instance's logs
+)
+
+// Lines longer than this are split
+const MAX_LOG_LINE_SIZE = 64 * 1024
+
+// How often idle server-sent log streams send a comment
+var LogKeepAlive = 15 * time.Second
+
+/*
+   A single line of an application's logs. Instance is the alias of
+   the instance the line came from, or its address if it has none.
+   Time is only set when timestamps were asked for.
+*/
+type logLine struct {
+	Instance string
+	Address  string
+	Stream   string
+	Time     string `json:",omitempty"`
+	Line     string
+}
+
+var logStreamNames = map[byte]string{
+	docker.STREAM_STDOUT: LogStreamStdout,
+	docker.STREAM_STDERR: LogStreamStderr,
+}
+
+/*
+   Translates the request's follow, since, tail and timestamps params
+   into the query of Docker's logs endpoint. since may be a unix time,
+   an RFC 3339 time or a duration before now, tail is a line count or
+   "all".
+*/
+func parseLogOptions(params url.Values, now time.Time) (url.Values, error) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+
+	for _, flag := range []string{"follow", "timestamps"} {
+		if value := params.Get(flag); value != "" {
+			set, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, NotEnoughParametersError
+			}
+			if set {
+				query.Set(flag, "1")
+			}
+		}
+	}
+
+	if since := params.Get("since"); since != "" {
+		if unix, err := strconv.ParseInt(since, 10, 64); err == nil && unix >= 0 {
+			query.Set("since", strconv.FormatInt(unix, 10))
+		} else if at, err := time.Parse(time.RFC3339, since); err == nil {
+			query.Set("since", strconv.FormatInt(at.Unix(), 10))
+		} else if ago, err := time.ParseDuration(since); err == nil && ago >= 0 {
+			query.Set("since", strconv.FormatInt(now.Add(-ago).Unix(), 10))
+		} else {
+			return nil, NotEnoughParametersError
+		}
+	}
+
+	if tail := params.Get("tail"); tail != "" {
+		if count, err := strconv.ParseUint(tail, 10, 32); err == nil {
+			query.Set("tail", strconv.FormatUint(count, 10))
+		} else if tail != "all" {
+			return nil, NotEnoughParametersError
+		}
+	}
+
+	return query, nil
+}
+
+// RETURN: Whether the container's output is multiplexed, i.e. it has no TTY
+func isMultiplexed(ctx context.Context, user *auth.User, instance, container string) (bool, error) {
+	req, err := docker.MakeDockerRequest(user, "GET", instance, "containers/"+container+"/json", nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := docker.SendDockerRequest(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode > 299 {
+		return false, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var info struct {
+		Config struct {
+			Tty bool
+		}
+	}
+
+	err = json.Unmarshal(body, &info)
+	return !info.Config.Tty, err
+}
+
+/*
+   Streams the logs of the application's container on the instance to
+   out, line by line, until they end or ctx is done.
+*/
+func streamInstanceLogs(ctx context.Context, user *auth.User, app, instance, name string, query url.Values, out chan<- logLine) {
+	timestamps := query.Get("timestamps") != ""
+
+	send := func(stream string, line []byte) error {
+		entry := logLine{
+			Instance: name,
+			Address:  instance,
+			Stream:   stream,
+			Line:     string(bytes.TrimSuffix(line, []byte("\r"))),
+		}
+
+		if timestamps && stream != LogStreamError {
+			if i := strings.IndexByte(entry.Line, ' '); i > 0 {
+				entry.Time, entry.Line = entry.Line[:i], entry.Line[i+1:]
+			}
+		}
+
+		select {
+		case out <- entry:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	fail := func(err error) {
+		if ctx.Err() == nil {
+			send(LogStreamError, []byte(err.Error()))
+		}
+	}
+
+	multiplexed, err := isMultiplexed(ctx, user, instance, app)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	endpoint := fmt.Sprintf("containers/%s/logs?%s", app, query.Encode())
+
+	req, err := docker.MakeDockerRequest(user, "GET", instance, endpoint, nil)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	resp, err := docker.SendDockerRequest(req.WithContext(ctx))
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		fail(fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+		return
+	}
+
+	// Partial lines of each stream, waiting for their newline
+	partial := make(map[byte][]byte)
+
+	err = docker.ReadDockerStream(resp.Body, multiplexed, func(stream byte, data []byte) error {
+		pending := append(partial[stream], data...)
+
+		for {
+			end := bytes.IndexByte(pending, '\n')
+			next := end + 1
+
+			if end < 0 {
+				if len(pending) < MAX_LOG_LINE_SIZE {
+					break
+				}
+				end, next = MAX_LOG_LINE_SIZE, MAX_LOG_LINE_SIZE
+			}
+
+			if err := send(logStreamNames[stream], pending[:end]); err != nil {
+				return err
+			}
+
+			pending = pending[next:]
+		}
+
+		partial[stream] = append([]byte(nil), pending...)
+		return nil
+	})
+
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	for _, stream := range []byte{docker.STREAM_STDOUT, docker.STREAM_STDERR} {
+		if len(partial[stream]) > 0 {
+			send(logStreamNames[stream], partial[stream])
+		}
+	}
+}
+
+/*
+   Merges the logs of the application's containers on all of its
+   instances as they arrive, reading at most as many at once as a batch
+   operation sends requests to. Followed logs never end, so following
+   more instances than that is refused.
+*/
+func streamApplicationLogs(ctx context.Context, user *auth.User, app applicationData, query url.Values) (<-chan logLine, error) {
+	instances := resolveInstances(user, app.Instances.([]string))
+
+	parallel := batch.DefaultOptions.MaxParallel
+	if query.Get("follow") != "" && parallel > 0 && len(instances) > parallel {
+		return nil, TooManyInstancesError
+	}
+
+	names, _ := aliases.GetAliasesOf(instances)
+
+	lines := make(chan logLine, len(instances))
+
+	go func() {
+		batch.ForEachInstance(instances, func(instance string) {
+			name, ok := names[instance]
+			if !ok {
+				name = instance
+			}
+
+			streamInstanceLogs(ctx, user, app.Name, instance, name, query, lines)
+		})
+
+		close(lines)
+	}()
+
+	return lines, nil
+}
+
+func wantsServerSentEvents(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "sse"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func writeLogLine(w http.ResponseWriter, line logLine, sse bool) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	if sse {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", line.Stream, data)
+	} else {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+	}
+
+	return err
+}
+
+/*
+   Streams the merged logs of an application's containers as
+   newline-delimited JSON, or as server-sent events when format=sse is
+   given or the client accepts text/event-stream. See parseLogOptions
+   for the other params.
+
+   Streaming the logs counts as one of the user's batch operations
+   until the stream ends.
+*/
+func handleApplicationLogs(w http.ResponseWriter, r *http.Request) {
+	var err error
+	defer func() { writeError(w, err) }()
+
+	user := auth.GetCurrentUser(r)
+
+	id, err := getAppIdByIdentifier(mux.Vars(r)["Id"])
+	if err != nil {
+		return
+	}
+
+	app, err := GetApplicationById(id)
+	if err != nil {
+		return
+	}
+
+	if !user.CanAccessApplication(app.Name) {
+		err = ApplicationPermissionError
+		return
+	}
+
+	query, err := parseLogOptions(r.URL.Query(), time.Now())
+	if err != nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err = StreamingUnsupportedError
+		return
+	}
+
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+
+	defer release()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	lines, err := streamApplicationLogs(ctx, user, app, query)
+	if err != nil {
+		return
+	}
+
+	sse := wantsServerSentEvents(r)
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(LogKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case line, ok := <-lines:
+			if !ok || writeLogLine(w, line, sse) != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if sse {
+				if _, writeErr := fmt.Fprint(w, ": keep-alive\n\n"); writeErr != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
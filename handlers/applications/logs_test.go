@@ -0,0 +1,264 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package applications
+
+import (
+	"testing"
+
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/handlers/batch"
+	"github.com/lighthouse/lighthouse/handlers/docker"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func Test_ParseLogOptions(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	params := url.Values{
+		"follow":     {"true"},
+		"timestamps": {"1"},
+		"since":      {"10m"},
+		"tail":       {"20"},
+	}
+
+	query, err := parseLogOptions(params, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "follow=1&since=400&stderr=1&stdout=1&tail=20&timestamps=1", query.Encode())
+
+	query, _ = parseLogOptions(url.Values{"since": {"1970-01-01T00:01:40Z"}, "tail": {"all"}}, now)
+	assert.Equal(t, "since=100&stderr=1&stdout=1", query.Encode())
+
+	query, _ = parseLogOptions(url.Values{"since": {"50"}, "follow": {"false"}}, now)
+	assert.Equal(t, "since=50&stderr=1&stdout=1", query.Encode())
+
+	bad := []url.Values{
+		{"follow": {"maybe"}},
+		{"since": {"yesterday"}},
+		{"since": {"-5"}},
+		{"tail": {"-1"}},
+	}
+
+	for _, params := range bad {
+		_, err = parseLogOptions(params, now)
+		assert.Equal(t, NotEnoughParametersError, err, params.Encode())
+	}
+}
+
+// Emulates a Docker host running the APP container
+func setupLogServer(tty bool, queries chan string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/APP/json":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Config": map[string]interface{}{"Tty": tty},
+			})
+
+		case "/containers/APP/logs":
+			queries <- r.URL.RawQuery
+
+			if tty {
+				w.Write([]byte("tty one\r\ntty"))
+				w.(http.Flusher).Flush()
+				w.Write([]byte(" two"))
+				return
+			}
+
+			for _, frame := range []struct {
+				Stream byte
+				Data   string
+			}{
+				{docker.STREAM_STDOUT, "out one\nout "},
+				{docker.STREAM_STDERR, "err one\n"},
+				{docker.STREAM_STDOUT, "two\n"},
+			} {
+				header := make([]byte, 8)
+				header[0] = frame.Stream
+				binary.BigEndian.PutUint32(header[4:], uint32(len(frame.Data)))
+
+				w.Write(header)
+				w.Write([]byte(frame.Data))
+			}
+
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message":"No such container: APP"}`))
+		}
+	}
+}
+
+func runLogsTest(email, endpoint string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", endpoint, nil)
+	session.SetValue(r, "auth", "email", email)
+
+	m := mux.NewRouter()
+	m.HandleFunc("/logs/{Id:.*}", handleApplicationLogs)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	return w
+}
+
+func Test_HandleApplicationLogs(t *testing.T) {
+	setup()
+	defer teardown()
+
+	queries := make(chan string, 4)
+
+	muxed := httptest.NewServer(setupLogServer(false, queries))
+	defer muxed.Close()
+
+	raw := httptest.NewServer(setupLogServer(true, queries))
+	defer raw.Close()
+
+	missing := httptest.NewServer(setupLogServer(false, queries))
+	defer missing.Close()
+
+	muxedAddr := strings.TrimPrefix(muxed.URL, "http://")
+	rawAddr := strings.TrimPrefix(raw.URL, "http://")
+	missingAddr := strings.TrimPrefix(missing.URL, "http://")
+
+	for _, addr := range []string{muxedAddr, rawAddr, missingAddr} {
+		beacons.AddTestingHost(addr)
+	}
+
+	aliases.AddAlias("muxed", muxedAddr)
+
+	user := createTestingUser()
+
+	addApplication("APP", []string{muxedAddr, rawAddr})
+
+	w := runLogsTest("email", "/logs/APP")
+	assert.Equal(t, 403, w.Code)
+
+	auth.SetUserApplicationAuthLevel(user, "APP", auth.AccessAuthLevel)
+
+	w = runLogsTest("email", "/logs/APP?tail=bad")
+	assert.Equal(t, 400, w.Code)
+
+	// Streaming the logs is one of the user's batch operations
+	defer func(max int) { batch.MaxUserOperations = max }(batch.MaxUserOperations)
+	batch.MaxUserOperations = 1
+
+	release, _ := batch.Acquire(user)
+	w = runLogsTest("email", "/logs/APP?tail=5")
+	assert.Equal(t, 429, w.Code)
+	release()
+
+	// Followed logs need a stream per instance for as long as they run
+	defer func(options batch.Options) { batch.DefaultOptions = options }(batch.DefaultOptions)
+	batch.DefaultOptions.MaxParallel = 1
+
+	w = runLogsTest("email", "/logs/APP?follow=true")
+	assert.Equal(t, 400, w.Code)
+
+	w = runLogsTest("email", "/logs/APP?tail=5")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	assert.Equal(t, "stderr=1&stdout=1&tail=5", <-queries)
+	assert.Equal(t, "stderr=1&stdout=1&tail=5", <-queries)
+
+	var lines []logLine
+	for _, data := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var line logLine
+		json.Unmarshal([]byte(data), &line)
+		lines = append(lines, line)
+	}
+
+	// Lines of each instance stay in order, "127.0.0.1:..." sorts before "muxed"
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Instance < lines[j].Instance
+	})
+
+	assert.Equal(t, []logLine{
+		{rawAddr, rawAddr, "stdout", "", "tty one"},
+		{rawAddr, rawAddr, "stdout", "", "tty two"},
+	}, lines[:2])
+
+	assert.Contains(t, lines, logLine{"muxed", muxedAddr, "stdout", "", "out one"})
+	assert.Contains(t, lines, logLine{"muxed", muxedAddr, "stderr", "", "err one"})
+	assert.Contains(t, lines, logLine{"muxed", muxedAddr, "stdout", "", "out two"})
+	assert.Equal(t, 5, len(lines))
+
+	// Instances without the container report an error line
+	addApplication("OTHER", []string{missingAddr})
+	auth.SetUserApplicationAuthLevel(user, "OTHER", auth.AccessAuthLevel)
+
+	w = runLogsTest("email", "/logs/OTHER?format=sse")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(w.Body.String(), "event: error\ndata: "))
+	assert.Contains(t, w.Body.String(), "No such container: APP")
+}
+
+func Test_HandleApplicationLogs_Timestamps(t *testing.T) {
+	setup()
+	defer teardown()
+
+	queries := make(chan string, 1)
+
+	raw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			w.WriteHeader(404)
+			return
+		case "/containers/APP/json":
+			w.Write([]byte(`{"Config":{"Tty":true}}`))
+			return
+		}
+
+		queries <- r.URL.RawQuery
+		w.Write([]byte("2015-01-01T00:00:00.000000000Z started\n"))
+	}))
+	defer raw.Close()
+
+	rawAddr := strings.TrimPrefix(raw.URL, "http://")
+	beacons.AddTestingHost(rawAddr)
+
+	user := createTestingUser()
+
+	addApplication("APP", []string{rawAddr})
+	auth.SetUserApplicationAuthLevel(user, "APP", auth.AccessAuthLevel)
+
+	r, _ := http.NewRequest("GET", "/logs/APP?timestamps=true&follow=true", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	session.SetValue(r, "auth", "email", "email")
+
+	m := mux.NewRouter()
+	m.HandleFunc("/logs/{Id:.*}", handleApplicationLogs)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, "follow=1&stderr=1&stdout=1&timestamps=1", <-queries)
+
+	data, _ := json.Marshal(logLine{rawAddr, rawAddr, "stdout", "2015-01-01T00:00:00.000000000Z", "started"})
+	assert.Equal(t, "event: stdout\ndata: "+string(data)+"\n\n", w.Body.String())
+}
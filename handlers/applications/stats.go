@@ -0,0 +1,367 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package applications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/handlers/batch"
+)
+
+// How often streamed stats are sent, unless the request gives an interval
+var StatsInterval = 5 * time.Second
+
+// Streams may not ask for stats more often than this
+const MIN_STATS_INTERVAL = time.Second
+
+/*
+   Resource usage of an application's container on one instance, or
+   of all of them together. Instance is the alias of the instance, or
+   its address if it has none. Error is set instead of the usage when
+   the instance's stats could not be read.
+*/
+type resourceStats struct {
+	Instance string `json:",omitempty"`
+	Address  string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+
+	CPUPercent    float64
+	MemoryUsage   uint64
+	MemoryLimit   uint64
+	MemoryPercent float64
+	NetworkRx     uint64
+	NetworkTx     uint64
+	BlockRead     uint64
+	BlockWrite    uint64
+}
+
+/*
+   Total sums the usage of every instance which reported it, so its
+   CPUPercent may exceed 100 when the containers use more than one CPU
+   in all.
+*/
+type applicationStats struct {
+	Time      time.Time
+	Instances []resourceStats
+	Total     resourceStats
+}
+
+// Fields of Docker's stats which are reported
+type dockerStats struct {
+	CPUStats    dockerCPUStats `json:"cpu_stats"`
+	PreCPUStats dockerCPUStats `json:"precpu_stats"`
+
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+type dockerCPUStats struct {
+	CPUUsage struct {
+		TotalUsage  uint64   `json:"total_usage"`
+		PercpuUsage []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  uint64 `json:"online_cpus"`
+}
+
+/*
+   Computes usage the way the docker CLI does. CPU is the share of the
+   host's time used since Docker's previous sample, scaled by the
+   number of CPUs, and memory does not count the page cache.
+*/
+func (this dockerStats) resourceStats() resourceStats {
+	var stats resourceStats
+
+	cpuDelta := float64(this.CPUStats.CPUUsage.TotalUsage) - float64(this.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(this.CPUStats.SystemUsage) - float64(this.PreCPUStats.SystemUsage)
+
+	cpus := this.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = uint64(len(this.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * float64(cpus) * 100
+	}
+
+	stats.MemoryUsage = this.MemoryStats.Usage
+	stats.MemoryLimit = this.MemoryStats.Limit
+
+	// cgroup v1 reports the cache, v2 the inactive files
+	cache, ok := this.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache, ok = this.MemoryStats.Stats["inactive_file"]
+	}
+	if !ok {
+		cache = this.MemoryStats.Stats["cache"]
+	}
+
+	if cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	}
+
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	for _, network := range this.Networks {
+		stats.NetworkRx += network.RxBytes
+		stats.NetworkTx += network.TxBytes
+	}
+
+	for _, entry := range this.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += entry.Value
+		case "write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+
+	return stats
+}
+
+func (this *resourceStats) add(other resourceStats) {
+	this.CPUPercent += other.CPUPercent
+	this.MemoryUsage += other.MemoryUsage
+	this.MemoryLimit += other.MemoryLimit
+	this.NetworkRx += other.NetworkRx
+	this.NetworkTx += other.NetworkTx
+	this.BlockRead += other.BlockRead
+	this.BlockWrite += other.BlockWrite
+
+	if this.MemoryLimit > 0 {
+		this.MemoryPercent = float64(this.MemoryUsage) / float64(this.MemoryLimit) * 100
+	}
+}
+
+func readInstanceStats(resp *http.Response, err error) (resourceStats, error) {
+	if err != nil {
+		return resourceStats{}, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resourceStats{}, err
+	}
+
+	if resp.StatusCode > 299 {
+		var message struct{ Message string }
+		if json.Unmarshal(body, &message) != nil || message.Message == "" {
+			message.Message = strings.TrimSpace(string(body))
+		}
+		return resourceStats{}, fmt.Errorf("%s: %s", resp.Status, message.Message)
+	}
+
+	var stats dockerStats
+	if err = json.Unmarshal(body, &stats); err != nil {
+		return resourceStats{}, err
+	}
+
+	return stats.resourceStats(), nil
+}
+
+/*
+   Reads the stats of the application's container on every one of its
+   instances at once, until ctx is canceled.
+*/
+func getApplicationStats(ctx context.Context, user *auth.User, app applicationData) applicationStats {
+	instances := resolveInstances(user, app.Instances.([]string))
+
+	names, _ := aliases.GetAllAliases()
+
+	result := applicationStats{
+		Time:      time.Now(),
+		Instances: make([]resourceStats, 0, len(instances)),
+	}
+
+	var lock sync.Mutex
+	endpoint := fmt.Sprintf("containers/%s/stats?stream=false", app.Name)
+
+	batch.Gather(ctx, user, instances, "GET", endpoint, nil, func(instance string, resp *http.Response, err error) {
+		stats, err := readInstanceStats(resp, err)
+		if err != nil {
+			stats.Error = err.Error()
+		}
+
+		stats.Address = instance
+		stats.Instance = instance
+		if name, ok := names[instance]; ok {
+			stats.Instance = name
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		result.Instances = append(result.Instances, stats)
+		if err == nil {
+			result.Total.add(stats)
+		}
+	})
+
+	sort.Slice(result.Instances, func(i, j int) bool {
+		return result.Instances[i].Address < result.Instances[j].Address
+	})
+
+	return result
+}
+
+/*
+   Reports the resource usage of an application's containers, per
+   instance and in total. With stream=true the stats are sent again
+   every interval (a duration, StatsInterval by default) as
+   newline-delimited JSON, or as server-sent events when format=sse is
+   given or the client accepts text/event-stream.
+
+   Reading the stats counts as one of the user's batch operations. A
+   stream skips the intervals in which the user is at their limit.
+*/
+func handleApplicationStats(w http.ResponseWriter, r *http.Request) {
+	var err error
+	defer func() { writeError(w, err) }()
+
+	user := auth.GetCurrentUser(r)
+
+	id, err := getAppIdByIdentifier(mux.Vars(r)["Id"])
+	if err != nil {
+		return
+	}
+
+	app, err := GetApplicationById(id)
+	if err != nil {
+		return
+	}
+
+	if !user.CanAccessApplication(app.Name) {
+		err = ApplicationPermissionError
+		return
+	}
+
+	query := r.URL.Query()
+
+	stream := false
+	if value := query.Get("stream"); value != "" {
+		if stream, err = strconv.ParseBool(value); err != nil {
+			err = NotEnoughParametersError
+			return
+		}
+	}
+
+	interval := StatsInterval
+	if value := query.Get("interval"); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval < MIN_STATS_INTERVAL {
+			err = NotEnoughParametersError
+			return
+		}
+	}
+
+	release, err := batch.Acquire(user)
+	if err != nil {
+		return
+	}
+
+	if !stream {
+		defer release()
+
+		output, _ := json.Marshal(getApplicationStats(r.Context(), user, app))
+		fmt.Fprint(w, string(output))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		release()
+		err = StreamingUnsupportedError
+		return
+	}
+
+	sse := wantsServerSentEvents(r)
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Intervals in which the user is at their limit are skipped
+		if release != nil {
+			stats := getApplicationStats(r.Context(), user, app)
+			release()
+
+			if writeStats(w, sse, stats) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		release, _ = batch.Acquire(user)
+	}
+}
+
+func writeStats(w http.ResponseWriter, sse bool, stats applicationStats) error {
+	data, _ := json.Marshal(stats)
+
+	if sse {
+		_, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data)
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%s\n", data)
+	return err
+}
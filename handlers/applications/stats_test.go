@@ -0,0 +1,255 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package applications
+
+import (
+	"testing"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/handlers/batch"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+const testingStats = `{
+	"cpu_stats": {
+		"cpu_usage": {"total_usage": 300, "percpu_usage": [150, 150]},
+		"system_cpu_usage": 2000
+	},
+	"precpu_stats": {
+		"cpu_usage": {"total_usage": 100},
+		"system_cpu_usage": 1000
+	},
+	"memory_stats": {"usage": 300, "limit": 1000, "stats": {"cache": 100}},
+	"networks": {
+		"eth0": {"rx_bytes": 10, "tx_bytes": 20},
+		"eth1": {"rx_bytes": 1, "tx_bytes": 2}
+	},
+	"blkio_stats": {
+		"io_service_bytes_recursive": [
+			{"op": "Read", "value": 5},
+			{"op": "Write", "value": 7},
+			{"op": "Total", "value": 12}
+		]
+	}
+}`
+
+func Test_DockerStats(t *testing.T) {
+	var stats dockerStats
+	json.Unmarshal([]byte(testingStats), &stats)
+
+	assert.Equal(t, resourceStats{
+		CPUPercent:    40,
+		MemoryUsage:   200,
+		MemoryLimit:   1000,
+		MemoryPercent: 20,
+		NetworkRx:     11,
+		NetworkTx:     22,
+		BlockRead:     5,
+		BlockWrite:    7,
+	}, stats.resourceStats())
+
+	// Newer daemons report the CPUs and inactive files instead
+	stats = dockerStats{}
+	json.Unmarshal([]byte(`{
+		"cpu_stats": {"cpu_usage": {"total_usage": 50}, "system_cpu_usage": 100, "online_cpus": 4},
+		"memory_stats": {"usage": 300, "limit": 600, "stats": {"inactive_file": 0, "cache": 100}}
+	}`), &stats)
+
+	result := stats.resourceStats()
+	assert.Equal(t, float64(200), result.CPUPercent)
+	assert.Equal(t, uint64(300), result.MemoryUsage)
+	assert.Equal(t, float64(50), result.MemoryPercent)
+}
+
+// Emulates a Docker host running the APP container
+func setupStatsServer(queries chan string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/APP/stats" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message":"No such container: APP"}`))
+			return
+		}
+
+		queries <- r.URL.RawQuery
+		w.Write([]byte(testingStats))
+	}))
+}
+
+func runStatsTest(ctx context.Context, email, endpoint string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", endpoint, nil)
+	r = r.WithContext(ctx)
+	session.SetValue(r, "auth", "email", email)
+
+	m := mux.NewRouter()
+	m.HandleFunc("/stats/{Id:.*}", handleApplicationStats)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	return w
+}
+
+// Cancels the request once something has been written and flushed
+type cancelingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel func()
+}
+
+func (this *cancelingRecorder) Flush() {
+	this.ResponseRecorder.Flush()
+	if this.Body.Len() > 0 {
+		this.cancel()
+	}
+}
+
+func Test_HandleApplicationStats(t *testing.T) {
+	setup()
+	defer teardown()
+
+	queries := make(chan string, 4)
+
+	first := setupStatsServer(queries)
+	defer first.Close()
+
+	second := setupStatsServer(queries)
+	defer second.Close()
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	firstAddr := strings.TrimPrefix(first.URL, "http://")
+	secondAddr := strings.TrimPrefix(second.URL, "http://")
+	missingAddr := strings.TrimPrefix(missing.URL, "http://")
+
+	for _, addr := range []string{firstAddr, secondAddr, missingAddr} {
+		beacons.AddTestingHost(addr)
+	}
+
+	aliases.AddAlias("first", firstAddr)
+
+	user := createTestingUser()
+
+	addApplication("APP", []string{firstAddr, secondAddr, missingAddr})
+
+	ctx := context.Background()
+
+	w := runStatsTest(ctx, "email", "/stats/APP")
+	assert.Equal(t, 403, w.Code)
+
+	auth.SetUserApplicationAuthLevel(user, "APP", auth.AccessAuthLevel)
+
+	w = runStatsTest(ctx, "email", "/stats/APP?interval=1ms&stream=true")
+	assert.Equal(t, 400, w.Code)
+
+	// Reading the stats is one of the user's batch operations
+	defer func(max int) { batch.MaxUserOperations = max }(batch.MaxUserOperations)
+	batch.MaxUserOperations = 1
+
+	release, _ := batch.Acquire(user)
+	w = runStatsTest(ctx, "email", "/stats/APP")
+	assert.Equal(t, 429, w.Code)
+	release()
+
+	w = runStatsTest(ctx, "email", "/stats/APP")
+	assert.Equal(t, 200, w.Code)
+
+	assert.Equal(t, "stream=false", <-queries)
+	assert.Equal(t, "stream=false", <-queries)
+
+	var stats applicationStats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+
+	assert.Equal(t, 3, len(stats.Instances))
+
+	for _, instance := range stats.Instances {
+		switch instance.Address {
+		case firstAddr:
+			assert.Equal(t, "first", instance.Instance)
+			assert.Equal(t, float64(40), instance.CPUPercent)
+		case secondAddr:
+			assert.Equal(t, secondAddr, instance.Instance)
+			assert.Equal(t, uint64(200), instance.MemoryUsage)
+		case missingAddr:
+			assert.Contains(t, instance.Error, "404")
+		}
+	}
+
+	// Instances which failed are left out of the total
+	assert.Equal(t, resourceStats{
+		CPUPercent:    80,
+		MemoryUsage:   400,
+		MemoryLimit:   2000,
+		MemoryPercent: 20,
+		NetworkRx:     22,
+		NetworkTx:     44,
+		BlockRead:     10,
+		BlockWrite:    14,
+	}, stats.Total)
+}
+
+func Test_HandleApplicationStats_Stream(t *testing.T) {
+	setup()
+	defer teardown()
+
+	queries := make(chan string, 1)
+
+	server := setupStatsServer(queries)
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	beacons.AddTestingHost(addr)
+
+	user := createTestingUser()
+
+	addApplication("APP", []string{addr})
+	auth.SetUserApplicationAuthLevel(user, "APP", auth.AccessAuthLevel)
+
+	// The client is gone after the first snapshot
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r, _ := http.NewRequest("GET", "/stats/APP?stream=true&format=sse", nil)
+	r = r.WithContext(ctx)
+	session.SetValue(r, "auth", "email", "email")
+
+	m := mux.NewRouter()
+	m.HandleFunc("/stats/{Id:.*}", handleApplicationStats)
+
+	w := &cancelingRecorder{httptest.NewRecorder(), cancel}
+	m.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "stream=false", <-queries)
+
+	body := w.Body.String()
+	assert.True(t, strings.HasPrefix(body, "event: stats\ndata: "))
+	assert.Equal(t, 1, strings.Count(body, "event: stats"))
+
+	var stats applicationStats
+	json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(body, "event: stats\ndata: "))), &stats)
+	assert.Equal(t, float64(40), stats.Total.CPUPercent)
+}
@@ -15,15 +15,20 @@
 package batch
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"runtime"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/handlers"
 	"github.com/lighthouse/lighthouse/handlers/docker"
 )
 
@@ -32,9 +37,54 @@ type Processor struct {
 	instances []string
 	failures  []string
 	user      *auth.User
+	options   Options
 	writeLock sync.Mutex
 }
 
+/*
+   How a Processor sends its requests. Failures which are likely to
+   pass when tried again are retried after Backoff, doubling for every
+   further attempt up to MaxBackoff:
+     - connections which were refused, as the request never arrived
+     - 502 and 503 responses, as the request was not handled
+     - timed out requests, but only for methods which may safely be
+       repeated (GET, HEAD, PUT, DELETE)
+
+   A zero value of any field means no limit, or no retries.
+*/
+type Options struct {
+	// How many instances are sent a request at once
+	MaxParallel int
+
+	// How long one attempt may take, reading the response included
+	RequestTimeout time.Duration
+
+	// How long one call of Do may take, retries included
+	Deadline time.Duration
+
+	// How many times a failed request is tried again
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// The options of processors made by NewProcessor
+var DefaultOptions = Options{
+	MaxParallel:    16,
+	RequestTimeout: 10 * time.Minute,
+	Deadline:       30 * time.Minute,
+	Retries:        2,
+	Backoff:        time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+var idempotentMethods = map[string]bool{
+	"GET":    true,
+	"HEAD":   true,
+	"PUT":    true,
+	"DELETE": true,
+}
+
 type Result struct {
 	Status  string
 	Message string
@@ -50,96 +100,147 @@ type progressUpdate struct {
 	Instance string
 	Item     int
 	Total    int
+	Attempt  int `json:",omitempty"`
 }
 
 type ResponseInterpreter func(int, io.Reader, error) (Result, error)
 
 func NewProcessor(user *auth.User, writer http.ResponseWriter, instances []string) *Processor {
-	return &Processor{writer, instances, []string{}, user, sync.Mutex{}}
+	return NewProcessorWithOptions(user, writer, instances, DefaultOptions)
+}
+
+func NewProcessorWithOptions(user *auth.User, writer http.ResponseWriter, instances []string, options Options) *Processor {
+	return &Processor{writer, instances, []string{}, user, options, sync.Mutex{}}
 }
 
 func (this *Processor) Do(action, method string, body interface{}, endpoint string, interpret ResponseInterpreter) error {
+	return this.DoWithHeader(action, method, nil, body, endpoint, interpret)
+}
+
+/*
+   Like Do, but every request also carries the given headers, e.g.
+   X-Registry-Auth for image pulls.
+*/
+func (this *Processor) DoWithHeader(action, method string, header http.Header, body interface{}, endpoint string, interpret ResponseInterpreter) error {
 	var (
 		completed           = []string{}
 		errorToReport error = nil
 		total               = len(this.instances)
-		requests            = sync.WaitGroup{}
-		channels            = sync.WaitGroup{}
-		queue               = make(chan string, 1)
-		failQueue           = make(chan string, 1)
+		resultsLock         = sync.Mutex{}
 	)
 
 	if interpret == nil {
 		interpret = interpretResponseDefault
 	}
 
-	this.writeUpdate(Result{"Starting", action, 0}, method, endpoint, "", 0, total)
+	this.writeUpdate(Result{"Starting", action, 0}, method, endpoint, "", 0, total, 0)
 
-	requests.Add(total)
-	for i, inst := range this.instances {
-		go func(inst string, itemNumber int) {
-			defer requests.Done()
+	ctx, cancel := withTimeout(context.Background(), this.options.Deadline)
+	defer cancel()
 
-			resp, err := runBatchRequest(this.user, method, inst, endpoint, body)
+	handlers.ForEach(total, this.options.MaxParallel, func(itemNumber int) {
+		inst := this.instances[itemNumber]
 
-			var result Result
+		result, err := this.send(ctx, method, header, body, endpoint, interpret, inst, itemNumber, total)
+		this.writeUpdate(result, method, endpoint, inst, itemNumber, total, 0)
 
-			if resp != nil {
-				result, err = interpret(resp.StatusCode, resp.Body, err)
+		resultsLock.Lock()
+		defer resultsLock.Unlock()
 
-				// Make sure the response is complete before ending
-				if err == nil {
-					ioutil.ReadAll(resp.Body)
-					resp.Body.Close()
-				}
+		if err == nil {
+			completed = append(completed, inst)
+		} else {
+			this.failures = append(this.failures, inst)
+			errorToReport = err
+		}
+	})
 
-			} else {
-				result, err = interpret(500, nil, err)
-			}
+	this.writeUpdate(Result{"Complete", action, 0}, method, endpoint, "", total, total, 0)
 
-			this.writeUpdate(result, method, endpoint, inst, itemNumber, total)
+	this.instances = completed
+	return errorToReport
+}
 
-			// Yield to other goroutines
-			runtime.Gosched()
+/*
+   Sends the request to one instance, trying again while it fails in
+   a way the options allow to retry. Every retry is reported.
+*/
+func (this *Processor) send(ctx context.Context, method string, header http.Header, body interface{}, endpoint string, interpret ResponseInterpreter, inst string, itemNumber, total int) (Result, error) {
+	backoff := this.options.Backoff
 
-			if err == nil {
-				queue <- inst
-			} else {
-				failQueue <- inst
-				errorToReport = err
-			}
-		}(inst, i)
-	}
+	for attempt := 1; ; attempt++ {
+		result, err, retry := this.attempt(ctx, method, header, body, endpoint, interpret, inst)
 
-	go func() {
-		channels.Add(1)
-		defer channels.Done()
-		for inst := range queue {
-			completed = append(completed, inst)
+		if err == nil || !retry || attempt > this.options.Retries || ctx.Err() != nil {
+			return result, err
 		}
-	}()
 
-	go func() {
-		channels.Add(1)
-		defer channels.Done()
-		for inst := range failQueue {
-			this.failures = append(this.failures, inst)
+		message := fmt.Sprintf("%s, retrying in %s", result.Message, backoff)
+		this.writeUpdate(Result{"Retrying", message, result.Code}, method, endpoint, inst, itemNumber, total, attempt+1)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return result, err
 		}
-	}()
 
-	requests.Wait()
-	close(queue)
-	close(failQueue)
-	channels.Wait()
+		if backoff *= 2; this.options.MaxBackoff > 0 && backoff > this.options.MaxBackoff {
+			backoff = this.options.MaxBackoff
+		}
+	}
+}
 
-	this.writeUpdate(Result{"Complete", action, 0}, method, endpoint, "", total, total)
+/*
+   RETURN: The interpreted response, and whether a failure may be retried
+*/
+func (this *Processor) attempt(ctx context.Context, method string, header http.Header, body interface{}, endpoint string, interpret ResponseInterpreter, inst string) (Result, error, bool) {
+	ctx, cancel := withTimeout(ctx, this.options.RequestTimeout)
+	defer cancel()
+
+	resp, err := runBatchRequest(ctx, this.user, method, inst, endpoint, body, header)
+	if resp == nil {
+		result, err := interpret(500, nil, err)
+		return result, err, isTransientError(method, err)
+	}
 
-	this.instances = completed
-	return errorToReport
+	defer resp.Body.Close()
+
+	result, err := interpret(resp.StatusCode, resp.Body, err)
+
+	// Make sure the response is complete before ending
+	if err == nil {
+		ioutil.ReadAll(resp.Body)
+	}
+
+	transient := resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable
+
+	return result, err, transient
+}
+
+// Limits ctx to the timeout, unless it is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func isTransientError(method string, err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return idempotentMethods[method]
+	}
+
+	return false
 }
 
 func (this *Processor) FailureProcessor() *Processor {
-	return NewProcessor(this.user, this.writer, this.failures)
+	return NewProcessorWithOptions(this.user, this.writer, this.failures, this.options)
 }
 
 func Finalize(w http.ResponseWriter) {
@@ -149,7 +250,11 @@ func Finalize(w http.ResponseWriter) {
 	}
 }
 
-func (this *Processor) writeUpdate(res Result, method, endpoint, instance string, progress, total int) {
+/*
+   Reports progress on the instance, or on the whole operation if it is
+   empty. A non-zero attempt is the try the request is about to get.
+*/
+func (this *Processor) writeUpdate(res Result, method, endpoint, instance string, progress, total, attempt int) {
 	update := progressUpdate{
 		Status:   res.Status,
 		Method:   method,
@@ -159,6 +264,7 @@ func (this *Processor) writeUpdate(res Result, method, endpoint, instance string
 		Instance: instance,
 		Item:     progress,
 		Total:    total,
+		Attempt:  attempt,
 	}
 
 	this.writeLock.Lock()
@@ -170,14 +276,66 @@ func (this *Processor) writeUpdate(res Result, method, endpoint, instance string
 	}
 }
 
-func runBatchRequest(user *auth.User, method, instance, endpoint string, body interface{}) (*http.Response, error) {
+/*
+   Sends the same request to every instance and hands each response to
+   handle as it arrives, which must close its body. Unlike a Processor
+   no progress is written, for callers which build their own response,
+   and failures are not retried. Requests are limited like those of
+   processors made by NewProcessor, and all of them are canceled along
+   with ctx. Returns once every instance has been handled.
+*/
+func Gather(ctx context.Context, user *auth.User, instances []string, method, endpoint string, body interface{}, handle func(instance string, resp *http.Response, err error)) {
+	options := DefaultOptions
+
+	ctx, cancel := withTimeout(ctx, options.Deadline)
+	defer cancel()
+
+	handlers.ForEach(len(instances), options.MaxParallel, func(item int) {
+		// The response must be handled before its request is canceled
+		ctx, cancel := withTimeout(ctx, options.RequestTimeout)
+		defer cancel()
+
+		resp, err := runBatchRequest(ctx, user, method, instances[item], endpoint, body, nil)
+		handle(instances[item], resp, err)
+	})
+}
+
+/*
+   Calls work with every instance, at most as many at once as
+   processors made by NewProcessor send requests to, for callers which
+   talk to the instances themselves. Returns once every call is done.
+*/
+func ForEachInstance(instances []string, work func(instance string)) {
+	handlers.ForEach(len(instances), DefaultOptions.MaxParallel, func(item int) {
+		work(instances[item])
+	})
+}
+
+func runBatchRequest(ctx context.Context, user *auth.User, method, instance, endpoint string, body interface{}, header http.Header) (*http.Response, error) {
 	payload, _ := json.Marshal(body)
+
+	// The policy works on the payload as Docker will read it
+	var decoded map[string]interface{}
+	json.Unmarshal(payload, &decoded)
+
+	if violation := docker.CheckPolicy(user, method, instance, endpoint, decoded); violation != nil {
+		return nil, errors.New(violation.Message)
+	}
+
+	if decoded != nil {
+		payload, _ = json.Marshal(decoded)
+	}
+
 	req, err := docker.MakeDockerRequest(user, method, instance, endpoint, payload)
 	if err != nil {
 		return nil, err
 	}
 
-	return http.DefaultClient.Do(req)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	return docker.SendDockerRequest(req.WithContext(ctx))
 }
 
 func interpretResponseDefault(code int, body io.Reader, err error) (Result, error) {
@@ -18,17 +18,23 @@ import (
 	"testing"
 
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/handlers/docker"
 )
 
 func setup() *auth.User {
@@ -36,6 +42,7 @@ func setup() *auth.User {
 	auth.SetupTestingTable()
 	auth.CreateUser("email", "", "")
 	user, _ := auth.GetUser("email")
+	auth.SetUserHostAuthLevel(user, "127.0.0.1:*", auth.AccessAuthLevel)
 	return user
 }
 
@@ -78,6 +85,7 @@ func Test_NewProcessor(t *testing.T) {
 
 	assert.Equal(t, writer, proc.writer)
 	assert.Equal(t, instances, proc.instances)
+	assert.Equal(t, DefaultOptions, proc.options)
 }
 
 func Test_Do_Nothing(t *testing.T) {
@@ -93,8 +101,8 @@ func Test_Do_Nothing(t *testing.T) {
 
 	updates := getUpdates(w, true)
 
-	keyStart := progressUpdate{"Starting", "GET", "ENDPOINT", "TEST", 0, "", 0, 0}
-	keyComplete := progressUpdate{"Complete", "GET", "ENDPOINT", "TEST", 0, "", 0, 0}
+	keyStart := progressUpdate{"Starting", "GET", "ENDPOINT", "TEST", 0, "", 0, 0, 0}
+	keyComplete := progressUpdate{"Complete", "GET", "ENDPOINT", "TEST", 0, "", 0, 0, 0}
 
 	assert.Equal(t, keyStart, updates[0])
 	assert.Equal(t, keyComplete, updates[1])
@@ -126,7 +134,7 @@ func Test_Do_SingleInstance(t *testing.T) {
 
 	updates := getUpdates(w, false)
 
-	keyUpdate := progressUpdate{"OK", "GET", "", "", 200, insts[0], 0, 1}
+	keyUpdate := progressUpdate{"OK", "GET", "", "", 200, insts[0], 0, 1, 0}
 
 	assert.Equal(t, keyUpdate, updates[0])
 }
@@ -147,10 +155,43 @@ func Test_Do_SingleInstance_Fail(t *testing.T) {
 
 	updates := getUpdates(w, false)
 
-	keyUpdate := progressUpdate{"Error", "GET", "", "", 400, insts[0], 0, 1}
+	keyUpdate := progressUpdate{"Error", "GET", "", "", 400, insts[0], 0, 1, 0}
 	assert.Equal(t, keyUpdate, updates[0])
 }
 
+func Test_Do_Policy(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	docker.SetPolicy(docker.Policy{Rules: []docker.PolicyRule{{
+		Name:     "no-privileged",
+		Endpoint: "^containers/create$",
+		Forbid:   map[string]interface{}{"HostConfig.Privileged": true},
+	}}})
+	defer docker.SetPolicy(docker.Policy{})
+
+	requests := 0
+	insts, servers := SetupServers(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+	defer ShutdownServers(servers)
+
+	body := map[string]interface{}{
+		"Image":      "ubuntu",
+		"HostConfig": map[string]interface{}{"Privileged": true},
+	}
+
+	w := httptest.NewRecorder()
+	proc := NewProcessor(user, w, insts)
+	err := proc.Do("TEST", "POST", body, "containers/create", nil)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, requests)
+
+	updates := getUpdates(w, false)
+	assert.Equal(t, "no-privileged: HostConfig.Privileged may not be true", updates[0].Message)
+}
+
 func Test_Do_Multiple(t *testing.T) {
 	user := setup()
 	defer teardown()
@@ -241,6 +282,185 @@ func Test_Failures(t *testing.T) {
 	assert.Equal(t, insts[4], failures.instances[2])
 }
 
+// Options which retry quickly, so that tests do not wait on backoff
+func testingOptions(retries int) Options {
+	return Options{Retries: retries, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+}
+
+// A handler which answers with each code in turn, repeating the last
+func sequenceHandler(hits *int32, codes ...int) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(hits, 1)) - 1
+		if i >= len(codes) {
+			i = len(codes) - 1
+		}
+		w.WriteHeader(codes[i])
+	}
+}
+
+// A handler which only answers once the client has given up
+func hangingHandler(hits *int32) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+
+		// Disconnects are only noticed once the body has been read
+		ioutil.ReadAll(r.Body)
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func Test_Do_Retry(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	var hits int32
+	insts, servers := SetupServers(sequenceHandler(&hits, 503, 502, 200))
+	defer ShutdownServers(servers)
+
+	w := httptest.NewRecorder()
+	proc := NewProcessorWithOptions(user, w, insts, testingOptions(2))
+	err := proc.Do("TEST", "POST", nil, "", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+	assert.Equal(t, insts, proc.instances)
+
+	updates := getUpdates(w, false)
+	assert.Equal(t, 3, len(updates))
+
+	assert.Equal(t, "Retrying", updates[0].Status)
+	assert.Equal(t, 503, updates[0].Code)
+	assert.Equal(t, 2, updates[0].Attempt)
+	assert.True(t, strings.HasSuffix(updates[0].Message, "retrying in 1ms"))
+
+	assert.Equal(t, "Retrying", updates[1].Status)
+	assert.Equal(t, 3, updates[1].Attempt)
+	assert.True(t, strings.HasSuffix(updates[1].Message, "retrying in 2ms"))
+
+	assert.Equal(t, progressUpdate{"OK", "POST", "", "", 200, insts[0], 0, 1, 0}, updates[2])
+}
+
+func Test_Do_Retry_Exhausted(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	var unavailable, failing int32
+	insts, servers := SetupServers(sequenceHandler(&unavailable, 503), sequenceHandler(&failing, 500))
+	defer ShutdownServers(servers)
+
+	w := httptest.NewRecorder()
+	proc := NewProcessorWithOptions(user, w, insts, testingOptions(1))
+	err := proc.Do("TEST", "GET", nil, "", nil)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(proc.instances))
+
+	// Only failures which may pass on a second try are retried
+	assert.Equal(t, int32(2), atomic.LoadInt32(&unavailable))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failing))
+}
+
+func Test_Do_Retry_Refused(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	insts, servers := SetupServers(nil)
+	ShutdownServers(servers)
+
+	w := httptest.NewRecorder()
+	proc := NewProcessorWithOptions(user, w, insts, testingOptions(1))
+	err := proc.Do("TEST", "POST", nil, "", nil)
+
+	assert.NotNil(t, err)
+
+	updates := getUpdates(w, false)
+	assert.Equal(t, 2, len(updates))
+	assert.Equal(t, "Retrying", updates[0].Status)
+	assert.Equal(t, "Error", updates[1].Status)
+}
+
+func Test_Do_RequestTimeout(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	var hits int32
+	insts, servers := SetupServers(hangingHandler(&hits))
+	defer ShutdownServers(servers)
+
+	options := testingOptions(1)
+	options.RequestTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+
+	proc := NewProcessorWithOptions(user, httptest.NewRecorder(), insts, options)
+	assert.NotNil(t, proc.Do("TEST", "GET", nil, "", nil))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	// Requests which may not be safe to repeat are not retried
+	proc = NewProcessorWithOptions(user, httptest.NewRecorder(), insts, options)
+	assert.NotNil(t, proc.Do("TEST", "POST", nil, "", nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
+
+func Test_Do_Deadline(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	var hits int32
+	insts, servers := SetupServers(hangingHandler(&hits), handlerFactory(200))
+	defer ShutdownServers(servers)
+
+	options := testingOptions(3)
+	options.Deadline = 50 * time.Millisecond
+
+	start := time.Now()
+
+	proc := NewProcessorWithOptions(user, httptest.NewRecorder(), insts, options)
+	assert.NotNil(t, proc.Do("TEST", "GET", nil, "", nil))
+
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.Equal(t, []string{insts[1]}, proc.instances)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func Test_Do_MaxParallel(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	var running, most int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			seen := atomic.LoadInt32(&most)
+			if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	insts, servers := SetupServers(h, h, h, h, h)
+	defer ShutdownServers(servers)
+
+	options := testingOptions(0)
+	options.MaxParallel = 2
+
+	proc := NewProcessorWithOptions(user, httptest.NewRecorder(), insts, options)
+	assert.Nil(t, proc.Do("TEST", "GET", nil, "", nil))
+
+	assert.Equal(t, 5, len(proc.instances))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&most))
+}
+
 func Test_DefaultInterpret_Long(t *testing.T) {
 	buf := make([]byte, 83)
 	for i := 0; i < 83; i += 1 {
@@ -260,3 +480,104 @@ func Test_DefaultInterpret_Long(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, string(key), res.Message)
 }
+
+func Test_Gather(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	insts, servers := SetupServers(handlerFactory(200), handlerFactory(404))
+	defer ShutdownServers(servers)
+
+	codes := make(map[string]int)
+	var lock sync.Mutex
+
+	Gather(context.Background(), user, append(insts, "UNKNOWN"), "GET", "containers/APP/json", nil,
+		func(instance string, resp *http.Response, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				codes[instance] = -1
+				return
+			}
+
+			resp.Body.Close()
+			codes[instance] = resp.StatusCode
+		})
+
+	assert.Equal(t, map[string]int{insts[0]: 200, insts[1]: 404, "UNKNOWN": -1}, codes)
+}
+
+func Test_Gather_Limits(t *testing.T) {
+	user := setup()
+	defer teardown()
+
+	defer func(options Options) { DefaultOptions = options }(DefaultOptions)
+	DefaultOptions = Options{MaxParallel: 1, RequestTimeout: 50 * time.Millisecond}
+
+	var hits int32
+	insts, servers := SetupServers(hangingHandler(&hits), hangingHandler(&hits))
+	defer ShutdownServers(servers)
+
+	var running, most int32
+	failed := int32(0)
+
+	handle := func(instance string, resp *http.Response, err error) {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		if now > atomic.LoadInt32(&most) {
+			atomic.StoreInt32(&most, now)
+		}
+
+		if err != nil {
+			atomic.AddInt32(&failed, 1)
+		}
+	}
+
+	start := time.Now()
+	Gather(context.Background(), user, insts, "GET", "", nil, handle)
+
+	// Hung hosts time out one at a time
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&failed))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&most))
+
+	// Canceling the context cancels the requests
+	DefaultOptions = Options{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	Gather(ctx, user, insts, "GET", "", nil, handle)
+
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&failed))
+}
+
+func Test_ForEachInstance(t *testing.T) {
+	defer func(options Options) { DefaultOptions = options }(DefaultOptions)
+	DefaultOptions = Options{MaxParallel: 2}
+
+	var running, most int32
+	var lock sync.Mutex
+	seen := make([]string, 0)
+
+	ForEachInstance([]string{"A", "B", "C", "D"}, func(instance string) {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		if now > atomic.LoadInt32(&most) {
+			atomic.StoreInt32(&most, now)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		seen = append(seen, instance)
+		lock.Unlock()
+	})
+
+	sort.Strings(seen)
+	assert.Equal(t, []string{"A", "B", "C", "D"}, seen)
+	assert.True(t, atomic.LoadInt32(&most) <= 2)
+}
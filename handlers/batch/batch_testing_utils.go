@@ -20,6 +20,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+
+	"github.com/lighthouse/lighthouse/beacons"
 )
 
 var nextPortNumber = 8080
@@ -30,6 +32,12 @@ func ShutdownServers(servers []*httptest.Server) {
 	}
 }
 
+/*
+   Starts a server for each handler, registered as a direct Docker
+   host. The servers do not report their API versions, so requests
+   reach the handlers as they are sent. The beacons testing table must
+   be set up.
+*/
 func SetupServers(handlers ...func(http.ResponseWriter, *http.Request)) ([]string, []*httptest.Server) {
 	addresses := make([]string, len(handlers))
 	servers := make([]*httptest.Server, len(handlers))
@@ -39,12 +47,23 @@ func SetupServers(handlers ...func(http.ResponseWriter, *http.Request)) ([]strin
 			f = func(http.ResponseWriter, *http.Request) {}
 		}
 
+		handler := func(f http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/version" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				f(w, r)
+			}
+		}(f)
+
 		// Start a new test server to listen for requests from the tests
-		server := httptest.NewUnstartedServer(http.HandlerFunc(f))
+		server := httptest.NewUnstartedServer(handler)
 		server.Listener, _ = net.Listen("tcp", fmt.Sprintf("localhost:%d", nextPortNumber))
 		server.Start()
 
 		addresses[i] = strings.Replace(server.URL, "http://", "", 1)
+		beacons.AddTestingHost(addresses[i])
 		servers[i] = server
 		nextPortNumber++
 	}
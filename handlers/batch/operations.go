@@ -0,0 +1,69 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lighthouse/lighthouse/auth"
+)
+
+var TooManyOperationsError = errors.New("batch: too many batch operations running, try again later")
+
+var (
+	// How many batch operations one user may run at once, 0 for no limit
+	MaxUserOperations = 0
+
+	// How long users turned away by MaxUserOperations are told to wait
+	OperationRetryAfter = 5 * time.Second
+)
+
+var (
+	operations     = map[string]int{}
+	operationsLock sync.Mutex
+)
+
+/*
+   Reserves one of the user's batch operations, e.g. a deployment, for
+   as long as a handler runs it. Service accounts are counted on their
+   own since they act as a user of their name.
+
+   RETURN: A function to release the operation on success,
+           TooManyOperationsError if the user is at their limit
+*/
+func Acquire(user *auth.User) (func(), error) {
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+
+	if MaxUserOperations > 0 && operations[user.Email] >= MaxUserOperations {
+		return nil, TooManyOperationsError
+	}
+
+	operations[user.Email] += 1
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			operationsLock.Lock()
+			defer operationsLock.Unlock()
+
+			if operations[user.Email] -= 1; operations[user.Email] <= 0 {
+				delete(operations, user.Email)
+			}
+		})
+	}, nil
+}
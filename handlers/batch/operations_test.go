@@ -0,0 +1,66 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+)
+
+func Test_Acquire(t *testing.T) {
+	defer func(max int) { MaxUserOperations = max }(MaxUserOperations)
+	MaxUserOperations = 2
+
+	user := &auth.User{Email: "USER"}
+	other := &auth.User{Email: "OTHER"}
+
+	first, err := Acquire(user)
+	assert.Nil(t, err)
+
+	second, err := Acquire(user)
+	assert.Nil(t, err)
+
+	_, err = Acquire(user)
+	assert.Equal(t, TooManyOperationsError, err)
+
+	// Users are limited separately
+	release, err := Acquire(other)
+	assert.Nil(t, err)
+	release()
+
+	// Releasing twice frees only one operation
+	first()
+	first()
+
+	third, err := Acquire(user)
+	assert.Nil(t, err)
+
+	_, err = Acquire(user)
+	assert.Equal(t, TooManyOperationsError, err)
+
+	second()
+	third()
+	assert.Equal(t, 0, len(operations))
+
+	MaxUserOperations = 0
+	for i := 0; i < 5; i++ {
+		release, err = Acquire(user)
+		assert.Nil(t, err)
+		defer release()
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/handlers"
+)
+
+// How long listing the containers of a single instance may take
+var ContainerListTimeout = 10 * time.Second
+
+/*
+   How many instances are asked for their containers at once. Set to
+   the batch parallelism (batch.DefaultOptions.MaxParallel) on startup,
+   which cannot be read here as batch imports this package. 0 for all.
+*/
+var ContainerListWorkers = 16
+
+/*
+   How long an instance's containers are served from memory. The
+   inventory is read often and changes slowly, a non-positive TTL
+   disables the cache.
+*/
+var ContainerCacheTTL = 10 * time.Second
+
+/*
+   A container as listed in the fleet-wide inventory. Alias is the
+   alias of the container's instance, if it has one, and Beacon is
+   empty for direct Docker hosts. Application is the Lighthouse
+   application the container belongs to, if any.
+*/
+type containerListing struct {
+	Id          string
+	Name        string
+	Image       string
+	State       string
+	Status      string
+	Created     int64
+	Instance    string
+	Alias       string
+	Beacon      string
+	Application string
+}
+
+/*
+   Errors holds why the containers of an instance could not be
+   listed, by instance address.
+*/
+type containerInventory struct {
+	Containers []containerListing
+	Errors     map[string]string
+}
+
+// Fields of Docker's containers/json listing which the inventory uses
+type dockerContainer struct {
+	Id      string
+	Names   []string
+	Image   string
+	State   string
+	Status  string
+	Created int64
+}
+
+type containerCacheEntry struct {
+	containers []dockerContainer
+	expires    time.Time
+}
+
+var (
+	containerCache     = make(map[string]containerCacheEntry)
+	containerCacheLock = sync.RWMutex{}
+)
+
+func getCachedContainers(instance string) ([]dockerContainer, bool) {
+	containerCacheLock.RLock()
+	defer containerCacheLock.RUnlock()
+
+	entry, ok := containerCache[instance]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.containers, true
+}
+
+func cacheContainers(instance string, containers []dockerContainer) {
+	if ContainerCacheTTL <= 0 {
+		return
+	}
+
+	containerCacheLock.Lock()
+	defer containerCacheLock.Unlock()
+
+	containerCache[instance] = containerCacheEntry{containers, time.Now().Add(ContainerCacheTTL)}
+}
+
+func clearContainerCache() {
+	containerCacheLock.Lock()
+	defer containerCacheLock.Unlock()
+
+	containerCache = make(map[string]containerCacheEntry)
+}
+
+/*
+   Filters of the inventory, empty fields do not filter. Image matches
+   the image with or without its tag, Instance matches the instance's
+   address or alias.
+*/
+type containerFilter struct {
+	Image       string
+	State       string
+	Application string
+	Instance    string
+	Beacon      string
+}
+
+func (this containerFilter) matches(container containerListing) bool {
+	if this.Image != "" && container.Image != this.Image &&
+		!strings.HasPrefix(container.Image, this.Image+":") &&
+		!strings.HasPrefix(container.Image, this.Image+"@") {
+		return false
+	}
+
+	if this.State != "" && !strings.EqualFold(container.State, this.State) {
+		return false
+	}
+
+	return this.Application == "" || container.Application == this.Application
+}
+
+// RETURN: Whether the target's containers could match the filter
+func (this containerFilter) includes(target beacons.DockerTarget, alias string) bool {
+	if this.Instance != "" && target.Address != this.Instance && alias != this.Instance {
+		return false
+	}
+
+	return this.Beacon == "" || target.Beacon == this.Beacon
+}
+
+/*
+   Docker only reports State since API 1.23, older daemons are read
+   from the start of Status, e.g. "Up 2 hours" or "Exited (0) ...".
+*/
+func containerState(container dockerContainer) string {
+	if container.State != "" {
+		return container.State
+	}
+
+	status := strings.ToLower(container.Status)
+
+	switch {
+	case strings.Contains(status, "(paused)"):
+		return "paused"
+	case strings.HasPrefix(status, "up"):
+		return "running"
+	case strings.HasPrefix(status, "exited"):
+		return "exited"
+	case strings.HasPrefix(status, "created"):
+		return "created"
+	case strings.HasPrefix(status, "restarting"):
+		return "restarting"
+	case strings.HasPrefix(status, "removal"):
+		return "removing"
+	case strings.HasPrefix(status, "dead"):
+		return "dead"
+	}
+
+	return ""
+}
+
+/*
+   Lists all of the containers on the instance, running or not, from
+   the cache unless refresh is set.
+*/
+func listContainers(ctx context.Context, user *auth.User, instance string, refresh bool) ([]dockerContainer, error) {
+	if !refresh {
+		if containers, ok := getCachedContainers(instance); ok {
+			return containers, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ContainerListTimeout)
+	defer cancel()
+
+	req, err := MakeDockerRequest(user, "GET", instance, "containers/json?all=1", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := SendDockerRequest(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, readDockerError(resp))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []dockerContainer
+	if err = json.Unmarshal(body, &containers); err != nil {
+		return nil, err
+	}
+
+	cacheContainers(instance, containers)
+
+	return containers, nil
+}
+
+/*
+   Lists the containers of every instance and direct Docker host the
+   user can access, asking at most ContainerListWorkers of them at
+   once. Instances which fail are reported in the inventory's Errors.
+*/
+func getContainerInventory(ctx context.Context, user *auth.User, filter containerFilter, refresh bool) (containerInventory, error) {
+	inventory := containerInventory{
+		Containers: make([]containerListing, 0),
+		Errors:     make(map[string]string),
+	}
+
+	targets, err := beacons.GetDockerTargets(user)
+	if err != nil {
+		return inventory, err
+	}
+
+	names, err := aliases.GetAllAliases()
+	if err != nil {
+		return inventory, err
+	}
+
+	included := make([]beacons.DockerTarget, 0, len(targets))
+	for _, target := range targets {
+		if filter.includes(target, names[target.Address]) {
+			included = append(included, target)
+		}
+	}
+
+	deployed := applicationIndexFunc()
+
+	var lock sync.Mutex
+
+	handlers.ForEach(len(included), ContainerListWorkers, func(item int) {
+		target := included[item]
+
+		containers, err := listContainers(ctx, user, target.Address, refresh)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if err != nil {
+			inventory.Errors[target.Address] = err.Error()
+			return
+		}
+
+		for _, container := range containers {
+			listing := containerListing{
+				Id:       container.Id,
+				Image:    container.Image,
+				State:    containerState(container),
+				Status:   container.Status,
+				Created:  container.Created,
+				Instance: target.Address,
+				Alias:    names[target.Address],
+				Beacon:   target.Beacon,
+			}
+
+			if len(container.Names) > 0 {
+				listing.Name = strings.TrimPrefix(container.Names[0], "/")
+			}
+
+			// Deployments name an application's containers after it
+			if deployed[target.Address][listing.Name] {
+				listing.Application = listing.Name
+			}
+
+			if filter.matches(listing) {
+				inventory.Containers = append(inventory.Containers, listing)
+			}
+		}
+	})
+
+	sort.Slice(inventory.Containers, func(i, j int) bool {
+		a, b := inventory.Containers[i], inventory.Containers[j]
+		if a.Instance == b.Instance {
+			return a.Name < b.Name
+		}
+		return a.Instance < b.Instance
+	})
+
+	return inventory, nil
+}
+
+/*
+   Lists the containers running, or not, on everything the user can
+   access. Takes the image, state, application, instance and beacon
+   params as filters (see containerFilter) and refresh to bypass the
+   cache.
+*/
+func handleListContainers(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+	query := r.URL.Query()
+
+	filter := containerFilter{
+		Image:       query.Get("image"),
+		State:       query.Get("state"),
+		Application: query.Get("application"),
+		Instance:    query.Get("instance"),
+		Beacon:      resolveHost(query.Get("beacon")),
+	}
+
+	refresh := false
+	if value := query.Get("refresh"); value != "" {
+		var err error
+		refresh, err = strconv.ParseBool(value)
+		if err != nil {
+			handlers.WriteError(w, http.StatusBadRequest, "control", "refresh must be a boolean")
+			return
+		}
+	}
+
+	inventory, err := getContainerInventory(r.Context(), user, filter, refresh)
+	if err != nil {
+		handlers.WriteError(w, http.StatusInternalServerError, "control", err.Error())
+		return
+	}
+
+	output, _ := json.Marshal(inventory)
+	fmt.Fprint(w, string(output))
+}
+
+/*
+   Registers the fleet-wide container routes, which live outside of
+   the Docker prefix since they span every instance.
+*/
+func HandleContainers(r *mux.Router) {
+	r.HandleFunc("/containers", handleListContainers).Methods("GET")
+}
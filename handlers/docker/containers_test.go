@@ -0,0 +1,193 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/beacons/aliases"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func Test_ContainerState(t *testing.T) {
+	tests := map[string]string{
+		"Up 2 hours":              "running",
+		"Up 5 minutes (Paused)":   "paused",
+		"Exited (0) 3 days ago":   "exited",
+		"Created":                 "created",
+		"Restarting (1) 1 second": "restarting",
+		"":                        "",
+	}
+
+	for status, state := range tests {
+		assert.Equal(t, state, containerState(dockerContainer{Status: status}), status)
+	}
+
+	assert.Equal(t, "running", containerState(dockerContainer{State: "running", Status: "Exited"}))
+}
+
+func Test_ContainerFilter(t *testing.T) {
+	container := containerListing{Image: "ubuntu:14.04", State: "running", Application: "APP"}
+
+	matching := []containerFilter{
+		{},
+		{Image: "ubuntu"},
+		{Image: "ubuntu:14.04"},
+		{State: "Running"},
+		{Application: "APP"},
+	}
+
+	for _, filter := range matching {
+		assert.True(t, filter.matches(container), "%v", filter)
+	}
+
+	other := []containerFilter{
+		{Image: "ubunt"},
+		{Image: "ubuntu:12.04"},
+		{State: "exited"},
+		{Application: "OTHER"},
+	}
+
+	for _, filter := range other {
+		assert.False(t, filter.matches(container), "%v", filter)
+	}
+
+	target := beacons.DockerTarget{Address: "INST", Beacon: "BEACON"}
+
+	assert.True(t, containerFilter{Instance: "ALIAS"}.includes(target, "ALIAS"))
+	assert.True(t, containerFilter{Instance: "INST", Beacon: "BEACON"}.includes(target, ""))
+	assert.False(t, containerFilter{Instance: "OTHER"}.includes(target, "ALIAS"))
+	assert.False(t, containerFilter{Beacon: "OTHER"}.includes(target, ""))
+}
+
+func runContainersTest(email, endpoint string) (containerInventory, int) {
+	r, _ := http.NewRequest("GET", endpoint, nil)
+	session.SetValue(r, "auth", "email", email)
+
+	router := mux.NewRouter()
+	HandleContainers(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	var inventory containerInventory
+	json.Unmarshal(w.Body.Bytes(), &inventory)
+
+	return inventory, w.Code
+}
+
+func Test_HandleListContainers(t *testing.T) {
+	email := setup()
+	defer teardown()
+	defer SetApplicationIndexFunc(defaultApplicationIndexFunc)
+
+	var requests int32
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		switch r.URL.Path {
+		case "/containers/json":
+			assert.Equal(t, "all=1", r.URL.RawQuery)
+			w.Write([]byte(`[{"Id":"1","Names":["/web"],"Image":"nginx:1.9","Status":"Up 1 hour"}]`))
+
+		case "/d/INST/containers/json":
+			w.Write([]byte(`[
+				{"Id":"2","Names":["/db"],"Image":"postgres","State":"exited","Status":"Exited (0)"},
+				{"Id":"3","Names":["/web"],"Image":"nginx","State":"running","Status":"Up 2 hours"}
+			]`))
+		}
+	}
+
+	defer SetupServer(&h).Close()
+
+	user, _ := auth.GetUser(email)
+
+	// An instance behind a beacon, next to the direct host from setup
+	beacons.AddTestingBeacon("127.0.0.1:8080", "TOKEN", "")
+	beacons.AddTestingInstance("INST", "127.0.0.1:8080", nil)
+	auth.SetUserBeaconAuthLevel(user, "127.0.0.1:8080", auth.AccessAuthLevel)
+	aliases.AddAlias("inst-alias", "INST")
+
+	// A host which cannot be reached
+	beacons.AddTestingHost("localhost:1")
+	auth.SetUserHostAuthLevel(user, "localhost:1", auth.AccessAuthLevel)
+
+	var lookups int32
+	SetApplicationIndexFunc(func() map[string]map[string]bool {
+		atomic.AddInt32(&lookups, 1)
+		return map[string]map[string]bool{"INST": {"web": true}}
+	})
+
+	inventory, code := runContainersTest(email, "/containers")
+	assert.Equal(t, 200, code)
+
+	// Applications are looked up once, not per container
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+
+	assert.Equal(t, []containerListing{
+		{"2", "db", "postgres", "exited", "Exited (0)", 0, "INST", "inst-alias", "127.0.0.1:8080", ""},
+		{"3", "web", "nginx", "running", "Up 2 hours", 0, "INST", "inst-alias", "127.0.0.1:8080", "web"},
+		{"1", "web", "nginx:1.9", "running", "Up 1 hour", 0, "localhost:8080", "", "", ""},
+	}, inventory.Containers)
+
+	assert.Equal(t, 1, len(inventory.Errors))
+	assert.Contains(t, inventory.Errors, "localhost:1")
+
+	// Listings are cached
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	inventory, _ = runContainersTest(email, "/containers?image=nginx&state=running")
+	assert.Equal(t, 2, len(inventory.Containers))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	inventory, _ = runContainersTest(email, "/containers?application=web")
+	assert.Equal(t, 1, len(inventory.Containers))
+	assert.Equal(t, "3", inventory.Containers[0].Id)
+
+	// Instances which cannot match are not asked at all
+	inventory, _ = runContainersTest(email, "/containers?instance=inst-alias&refresh=true")
+	assert.Equal(t, 2, len(inventory.Containers))
+	assert.Equal(t, 0, len(inventory.Errors))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	_, code = runContainersTest(email, "/containers?refresh=maybe")
+	assert.Equal(t, 400, code)
+}
+
+func Test_HandleListContainers_Permissions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	defer SetupServer(nil).Close()
+
+	beacons.AddTestingInstance("INST", "BEACON", nil)
+
+	auth.CreateUser("other", "", "")
+
+	inventory, code := runContainersTest("other", "/containers")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0, len(inventory.Containers))
+	assert.Equal(t, 0, len(inventory.Errors))
+}
@@ -17,9 +17,12 @@ package docker
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -33,52 +36,87 @@ import (
 
 /*
    Forwards the given request on to the Docker client.  Data stored
-   in the request's 'Payload' field is also forwarded.
+   in the request's 'Payload' field is forwarded in place of the body,
+   any other body is forwarded as it was sent.  The response is
+   streamed back along with its headers.
 
    Will only write to the given ResponseWriter on success.
 
    RETURN: nil on succes.  A non-nil *handlers.HandlerError on failure
 */
 func DockerRequestHandler(w http.ResponseWriter, info handlers.HandlerInfo) *handlers.HandlerError {
-	payload := []byte(nil)
-	if info.Body != nil {
-		payload, _ = json.Marshal(info.Body.Payload)
+	var body io.Reader
+	if info.Body != nil && info.Body.Payload != nil {
+		payload, _ := json.Marshal(info.Body.Payload)
+		body = bytes.NewReader(payload)
+	} else if info.Request.Body != nil {
+		body = info.Request.Body
 	}
 
 	user := auth.GetCurrentUser(info.Request)
-	req, err := MakeDockerRequest(user, info.Request.Method, info.Host, info.DockerEndpoint, payload)
+	req, err := MakeDockerStreamRequest(user, info.Request.Method, info.Host, dockerEndpointOf(info), body)
 	if err != nil {
-		return &handlers.HandlerError{500, "control", "Failed to create " + info.Request.Method + " request"}
+		return requestError(err, "Failed to create "+info.Request.Method+" request")
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	copyRequestHeaders(req.Header, info.Request.Header)
+
+	resp, err := SendDockerRequest(req)
 	if err != nil {
-		return &handlers.HandlerError{500, "control", info.Request.Method + " request failed"}
+		return requestError(err, info.Request.Method+" request failed")
 	}
 
 	// Close body after return
 	defer resp.Body.Close()
 
 	if resp.StatusCode > 299 {
-		return &handlers.HandlerError{resp.StatusCode, "docker", resp.Status}
+		return &handlers.HandlerError{resp.StatusCode, "docker", readDockerError(resp)}
 	}
 
+	copyResponseHeaders(w.Header(), resp.Header)
 	w.WriteHeader(resp.StatusCode)
-	var bodyBuffer = make([]byte, 16)
 
-	for {
-		n, err := resp.Body.Read(bodyBuffer)
-		w.Write(bodyBuffer[:n])
+	err = streamBody(w, resp.Body)
+	if err != nil {
+		logging.Info("An unexpected error occured while reading the docker stream")
+	}
+
+	return nil
+}
+
+var hostErrorCodes = map[error]int{
+	beacons.UnknownHostError:    http.StatusNotFound,
+	beacons.HostPermissionError: http.StatusForbidden,
+	beacons.HostNotAllowedError: http.StatusForbidden,
+}
+
+/*
+   Reports direct hosts which may not be used as such and requests the
+   instance's API cannot serve, and any other failure to reach Docker
+   with the given message.
+*/
+func requestError(err error, message string) *handlers.HandlerError {
+	var versionErr IncompatibleVersionError
+	if errors.As(err, &versionErr) {
+		return &handlers.HandlerError{http.StatusBadRequest, "docker", versionErr.Error()}
+	}
 
-		if err != nil {
-			if err != io.EOF {
-				logging.Info("An unexpected error occured while reading the docker stream")
-			}
-			break
+	for hostErr, code := range hostErrorCodes {
+		if errors.Is(err, hostErr) {
+			return &handlers.HandlerError{code, "control", hostErr.Error()}
 		}
 	}
 
-	return nil
+	return &handlers.HandlerError{500, "control", message}
+}
+
+// RETURN: The Docker endpoint of the request, including its query
+func dockerEndpointOf(info handlers.HandlerInfo) string {
+	endpoint := info.DockerEndpoint
+	if query := info.Request.URL.RawQuery; query != "" && !strings.Contains(endpoint, "?") {
+		endpoint += "?" + query
+	}
+	return endpoint
 }
 
 /*
@@ -101,7 +139,7 @@ func DockerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var customHandlers = handlers.CustomHandlerMap{
-	//regexp.MustCompile("example"): ExampleHandler,
+		regexp.MustCompile(".*"): PolicyHandler,
 	}
 
 	runCustomHandlers, err := handlers.RunCustomHandlers(info, customHandlers)
@@ -133,15 +171,7 @@ func GetHandlerInfo(r *http.Request) (handlers.HandlerInfo, bool) {
 		return handlers.HandlerInfo{}, false
 	}
 
-	hostAlias := params["Host"]
-
-	value, err := aliases.GetAddressOf(hostAlias)
-	if err == nil {
-		info.Host = value
-	} else {
-		info.Host = hostAlias // Unknown alias, just use what was given
-	}
-
+	info.Host = resolveHost(params["Host"])
 	info.DockerEndpoint = params["DockerEndpoint"]
 	info.Body = handlers.GetRequestBody(r)
 	info.Request = r
@@ -149,24 +179,62 @@ func GetHandlerInfo(r *http.Request) (handlers.HandlerInfo, bool) {
 	return info, true
 }
 
+func resolveHost(alias string) string {
+	address, err := aliases.GetAddressOf(alias)
+	if err != nil {
+		return alias // Unknown alias, just use what was given
+	}
+	return address
+}
+
 func MakeDockerRequest(user *auth.User, method, host, endpoint string, body []byte) (*http.Request, error) {
+	return MakeDockerStreamRequest(user, method, host, endpoint, bytes.NewBuffer(body))
+}
+
+/*
+   Like MakeDockerRequest, but the body is read as the request is sent.
+   Requests made with a body other than a *bytes.Buffer, *bytes.Reader
+   or *strings.Reader cannot be retried by SendDockerRequest.
+*/
+func MakeDockerStreamRequest(user *auth.User, method, host, endpoint string, body io.Reader) (*http.Request, error) {
+	target, endpoint, err := negotiateEndpoint(user, host, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDockerRequest(user, method, host, target, endpoint, body)
+}
+
+/*
+   Builds a request for the endpoint of the instance or direct host,
+   sent to it at the target address. The target differs from host
+   only in the API version an instance was registered with.
+*/
+func newDockerRequest(user *auth.User, method, host, target, endpoint string, body io.Reader) (*http.Request, error) {
 	beaconAddress, err := beacons.GetBeaconAddress(host)
 
 	requestIsToBeacon := err == nil
 
+	if !requestIsToBeacon {
+		if err := beacons.CheckDirectHost(user, host); err != nil {
+			return nil, err
+		}
+	}
+
 	var targetAddress, targetEndpoint string
 
 	if requestIsToBeacon {
 		targetAddress = beaconAddress
-		targetEndpoint = fmt.Sprintf("d/%s/%s", host, endpoint)
+		targetEndpoint = fmt.Sprintf("d/%s/%s", target, endpoint)
 	} else {
 		targetAddress = host
 		targetEndpoint = endpoint
 	}
 
-	url := fmt.Sprintf("http://%s/%s", targetAddress, targetEndpoint)
+	scheme := beacons.GetTLSSettings(targetAddress).Scheme()
+	url := fmt.Sprintf("%s://%s/%s", scheme, targetAddress, targetEndpoint)
 
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +249,52 @@ func MakeDockerRequest(user *auth.User, method, host, endpoint string, body []by
 	return req, nil
 }
 
+/*
+   Sends a request made by MakeDockerRequest using the TLS settings
+   of the beacon or Docker host it is addressed to. If a beacon in the
+   middle of a token rotation rejects its current token, the request
+   is retried once with the pending token.
+*/
+func SendDockerRequest(req *http.Request) (*http.Response, error) {
+	client, err := beacons.HTTPClientFor(req.URL.Host, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil || !beacons.IsTokenRejection(resp.StatusCode) {
+		return resp, err
+	}
+
+	token := req.Header.Get(beacons.HEADER_TOKEN_KEY)
+	pending := beacons.PendingTokenFor(req.URL.Host, token)
+
+	if pending == "" || req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Body, err = req.GetBody()
+	if err != nil {
+		return resp, nil
+	}
+
+	retry.Header.Set(beacons.HEADER_TOKEN_KEY, pending)
+
+	retryResp, err := client.Do(retry)
+	if err != nil {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	if !beacons.IsTokenRejection(retryResp.StatusCode) {
+		beacons.ConfirmPendingToken(req.URL.Host, pending)
+	}
+
+	return retryResp, nil
+}
+
 func Handle(r *mux.Router) {
 	r.HandleFunc("/{Endpoint:.*}", DockerHandler)
 }
@@ -21,6 +21,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -32,6 +33,7 @@ import (
 	"github.com/lighthouse/lighthouse/beacons/aliases"
 	"github.com/lighthouse/lighthouse/handlers"
 	"github.com/lighthouse/lighthouse/session"
+	"github.com/lighthouse/lighthouse/transport"
 )
 
 func setup() string {
@@ -39,6 +41,12 @@ func setup() string {
 	aliases.SetupTestingTable()
 	auth.SetupTestingTable()
 	auth.CreateUser("email", "", "")
+
+	// Tests send requests directly to SetupServer's Docker
+	beacons.AddTestingHost("localhost:8080")
+	user, _ := auth.GetUser("email")
+	auth.SetUserHostAuthLevel(user, "localhost:8080", auth.AccessAuthLevel)
+
 	return "email"
 }
 
@@ -46,6 +54,11 @@ func teardown() {
 	beacons.TeardownTestingTable()
 	aliases.TeardownTestingTable()
 	auth.TeardownTestingTable()
+
+	// Every test starts a new server on the same port
+	transport.CloseIdleConnections()
+	clearContainerCache()
+	clearVersionCache()
 }
 
 /*
@@ -68,8 +81,17 @@ func SetupServer(f *func(http.ResponseWriter, *http.Request)) *httptest.Server {
 		useFunc = func(http.ResponseWriter, *http.Request) {}
 	}
 
+	// Report no API versions, requests are forwarded as they are given
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/version") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		useFunc(w, r)
+	}
+
 	// Start a new test server to listen for requests from the tests
-	server := httptest.NewUnstartedServer(http.HandlerFunc(useFunc))
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handler))
 	server.Listener, _ = net.Listen("tcp", ":8080")
 	server.Start()
 
@@ -299,3 +321,93 @@ func Test_GetHandlerInfo(t *testing.T) {
 	assert.Equal(t, expected, info,
 		"GetHandlerInfo did not extract data correctly")
 }
+
+func Test_MakeDockerRequest_TLS(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	user, _ := auth.GetUser(email)
+
+	beacons.SetHostTLS("HOST", transport.TLSSettings{})
+	auth.SetUserHostAuthLevel(user, "HOST", auth.AccessAuthLevel)
+
+	req, _ := MakeDockerRequest(user, "GET", "HOST", "info", nil)
+	assert.Equal(t, "http://HOST/info", req.URL.String())
+
+	beacons.SetHostTLS("HOST", transport.TLSSettings{UseTLS: true})
+
+	req, _ = MakeDockerRequest(user, "GET", "HOST", "info", nil)
+	assert.Equal(t, "https://HOST/info", req.URL.String())
+}
+
+func Test_SendDockerRequest_PendingToken(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if r.Header.Get(beacons.HEADER_TOKEN_KEY) != "NEW" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write(body)
+	}
+
+	defer SetupServer(&h).Close()
+
+	beacons.AddTestingBeacon("localhost:8080", "OLD", "NEW")
+	beacons.AddTestingInstance("INST", "localhost:8080", nil)
+
+	user, _ := auth.GetUser(email)
+	auth.SetUserBeaconAuthLevel(user, "localhost:8080", auth.AccessAuthLevel)
+
+	req, _ := MakeDockerRequest(user, "POST", "INST", "containers/create", []byte("BODY"))
+	resp, err := SendDockerRequest(req)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "BODY", string(body))
+
+	token, _ := beacons.TryGetBeaconToken("localhost:8080", user)
+	assert.Equal(t, "NEW", token)
+}
+
+func Test_DockerRequestHandler_DirectHost(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	defer SetupServer(nil).Close()
+
+	run := func(host string) *handlers.HandlerError {
+		r, _ := http.NewRequest("GET", "/", nil)
+		session.SetValue(r, "auth", "email", email)
+		info := handlers.HandlerInfo{"info", host, nil, r, nil}
+
+		return DockerRequestHandler(httptest.NewRecorder(), info)
+	}
+
+	assert.Nil(t, run("localhost:8080"))
+
+	// Unregistered hosts are never proxied to
+	err := run("127.0.0.1:8080")
+	assert.Equal(t, 404, err.StatusCode)
+
+	beacons.SetHostTLS("127.0.0.1:8080", transport.TLSSettings{})
+
+	err = run("127.0.0.1:8080")
+	assert.Equal(t, 403, err.StatusCode)
+
+	// Neither are hosts outside of the allowlist
+	user, _ := auth.GetUser(email)
+	auth.SetUserHostAuthLevel(user, "127.0.0.1:8080", auth.AccessAuthLevel)
+
+	err = run("127.0.0.1:8080")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, 403, err.StatusCode)
+		assert.Equal(t, beacons.HostNotAllowedError.Error(), err.Message)
+	}
+}
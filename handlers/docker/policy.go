@@ -0,0 +1,549 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/handlers"
+	"github.com/lighthouse/lighthouse/logging"
+)
+
+// Registry of images which do not name one
+const DEFAULT_REGISTRY = "docker.io"
+
+/*
+   A rule of the Docker request policy. A rule applies to requests
+   whose endpoint (without any API version prefix or query) matches
+   the Endpoint regexp and whose method is one of Methods, or any
+   method if none are given. Users with at least the Exempt level
+   ("modify" or "owner") on the instance's beacon are not subject to it.
+
+   An applicable rule denies the request if:
+     - Deny is set
+     - a payload field of Forbid has the given value, e.g.
+       {"HostConfig.Privileged": true}
+     - Binds is set and a bind mount's host path is not within one
+       of its paths. An empty list forbids bind mounts entirely
+     - Registries is set and the image being created or pulled is
+       not from one of them
+
+   Otherwise the payload fields of Set are rewritten to the given
+   values, e.g. {"HostConfig.ReadonlyRootfs": true}.
+
+   Field paths are separated by '.' and, as in Docker, matched
+   regardless of case.
+*/
+type PolicyRule struct {
+	Name       string
+	Endpoint   string
+	Methods    []string
+	Exempt     string
+	Deny       bool
+	Forbid     map[string]interface{}
+	Binds      []string
+	Registries []string
+	Set        map[string]interface{}
+
+	endpoint *regexp.Regexp
+	exempt   int
+}
+
+type Policy struct {
+	Rules []PolicyRule
+}
+
+var exemptLevels = map[string]int{
+	"modify": auth.ModifyAuthLevel,
+	"owner":  auth.OwnerAuthLevel,
+}
+
+var (
+	policy     Policy
+	policyLock sync.RWMutex
+)
+
+var versionPrefix = regexp.MustCompile(`^v[0-9.]+/`)
+
+/*
+   Replaces the current policy, checking all of its rules first.
+
+   RETURN: nil on success, a description of the first invalid rule otherwise
+*/
+func SetPolicy(newPolicy Policy) error {
+	for i := range newPolicy.Rules {
+		rule := &newPolicy.Rules[i]
+
+		var err error
+		if rule.endpoint, err = regexp.Compile(rule.Endpoint); err != nil {
+			return fmt.Errorf("policy rule %q: invalid endpoint: %s", rule.Name, err)
+		}
+
+		rule.exempt = -1
+		if rule.Exempt != "" {
+			level, ok := exemptLevels[rule.Exempt]
+			if !ok {
+				return fmt.Errorf("policy rule %q: unknown exempt level %q", rule.Name, rule.Exempt)
+			}
+			rule.exempt = level
+		}
+
+		for j, method := range rule.Methods {
+			rule.Methods[j] = strings.ToUpper(method)
+		}
+
+		for j, bind := range rule.Binds {
+			rule.Binds[j] = path.Clean(bind)
+		}
+	}
+
+	policyLock.Lock()
+	policy = newPolicy
+	policyLock.Unlock()
+
+	return nil
+}
+
+/*
+   Loads the policy from docker_policy.json in the config directory.
+   Without a policy file every request is allowed.
+*/
+func LoadPolicy() error {
+	var fileName string
+	if _, err := os.Stat("./config/docker_policy.json.dev"); !os.IsNotExist(err) {
+		fileName = "./config/docker_policy.json.dev"
+	} else if _, err := os.Stat("./config/docker_policy.json"); !os.IsNotExist(err) {
+		fileName = "./config/docker_policy.json"
+	} else {
+		fileName = "/config/docker_policy.json"
+	}
+
+	configFile, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return SetPolicy(Policy{})
+	} else if err != nil {
+		return err
+	}
+
+	var newPolicy Policy
+	if err = json.Unmarshal(configFile, &newPolicy); err != nil {
+		return fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	return SetPolicy(newPolicy)
+}
+
+/*
+   RETURN: The endpoint's path without a leading API version and its
+           query params
+*/
+func normalizeEndpoint(endpoint string) (string, url.Values) {
+	rawPath, rawQuery := endpoint, ""
+	if i := strings.Index(endpoint, "?"); i >= 0 {
+		rawPath, rawQuery = endpoint[:i], endpoint[i+1:]
+	}
+
+	if unescaped, err := url.PathUnescape(rawPath); err == nil {
+		rawPath = unescaped
+	}
+
+	cleaned := strings.TrimPrefix(path.Clean("/"+rawPath), "/")
+	query, _ := url.ParseQuery(rawQuery)
+
+	return versionPrefix.ReplaceAllString(cleaned, ""), query
+}
+
+// Finds the key of the field regardless of case, as Docker does
+func fieldKey(object map[string]interface{}, name string) (string, bool) {
+	if _, ok := object[name]; ok {
+		return name, true
+	}
+
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+
+	return name, false
+}
+
+/*
+   Docker matches fields regardless of case and keeps the last of any
+   duplicates, while rules see whichever one fieldKey finds. Payloads
+   which give a field more than once are not checked at all.
+
+   RETURN: A field which appears more than once in some object of the
+           value, if any
+*/
+func duplicateField(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		seen := make(map[string]bool, len(value))
+
+		for key, child := range value {
+			folded := strings.ToLower(key)
+			if seen[folded] {
+				return key, true
+			}
+			seen[folded] = true
+
+			if field, ok := duplicateField(child); ok {
+				return key + "." + field, true
+			}
+		}
+
+	case []interface{}:
+		for _, child := range value {
+			if field, ok := duplicateField(child); ok {
+				return field, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func getField(payload map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = payload
+
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		key, ok := fieldKey(object, name)
+		if !ok {
+			return nil, false
+		}
+
+		value = object[key]
+	}
+
+	return value, true
+}
+
+func setField(payload map[string]interface{}, field string, value interface{}) {
+	names := strings.Split(field, ".")
+	object := payload
+
+	for _, name := range names[:len(names)-1] {
+		key, _ := fieldKey(object, name)
+
+		child, ok := object[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			object[key] = child
+		}
+
+		object = child
+	}
+
+	key, _ := fieldKey(object, names[len(names)-1])
+	object[key] = value
+}
+
+// RETURN: The host paths of the payload's bind mounts
+func bindSources(payload map[string]interface{}) []string {
+	sources := []string{}
+
+	binds, _ := getField(payload, "HostConfig.Binds")
+	list, _ := binds.([]interface{})
+
+	for _, bind := range list {
+		spec, _ := bind.(string)
+		source := strings.SplitN(spec, ":", 2)[0]
+
+		// Anything else names a volume
+		if strings.HasPrefix(source, "/") {
+			sources = append(sources, source)
+		}
+	}
+
+	mounts, _ := getField(payload, "HostConfig.Mounts")
+	list, _ = mounts.([]interface{})
+
+	for _, mount := range list {
+		object, _ := mount.(map[string]interface{})
+		kind, _ := getField(object, "Type")
+		source, _ := getField(object, "Source")
+
+		if kind == "bind" {
+			sourcePath, _ := source.(string)
+			sources = append(sources, sourcePath)
+		}
+	}
+
+	return sources
+}
+
+func bindAllowed(source string, allowed []string) bool {
+	source = path.Clean(source)
+
+	for _, prefix := range allowed {
+		if source == prefix || strings.HasPrefix(source, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RETURN: The image the request creates a container from or pulls, if any
+func requestImage(endpoint string, query url.Values, payload map[string]interface{}) (string, bool) {
+	switch endpoint {
+	case "containers/create":
+		image, _ := getField(payload, "Image")
+		name, ok := image.(string)
+		return name, ok
+	case "images/create":
+		name := query.Get("fromImage")
+		return name, name != ""
+	}
+
+	return "", false
+}
+
+func imageRegistry(image string) string {
+	i := strings.IndexByte(image, '/')
+	if i < 0 {
+		return DEFAULT_REGISTRY
+	}
+
+	host := image[:i]
+	if strings.ContainsAny(host, ".:") || host == "localhost" {
+		return strings.ToLower(host)
+	}
+
+	return DEFAULT_REGISTRY
+}
+
+func (this *PolicyRule) appliesTo(user *auth.User, method, host, endpoint string) bool {
+	if !this.endpoint.MatchString(endpoint) {
+		return false
+	}
+
+	if len(this.Methods) > 0 {
+		found := false
+		for _, allowed := range this.Methods {
+			found = found || allowed == method
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if this.exempt >= 0 && user != nil {
+		if beacons.GetDockerAuthLevel(user, host) >= this.exempt {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+   RETURN: Why the rule denies the request, empty if it does not
+*/
+func (this *PolicyRule) violation(endpoint string, query url.Values, payload map[string]interface{}, readable bool) string {
+	if this.Deny {
+		return "request denied"
+	}
+
+	inspectsPayload := len(this.Forbid) > 0 || this.Binds != nil || this.Registries != nil
+	if inspectsPayload && !readable {
+		return "request body is not a JSON object"
+	}
+
+	if (inspectsPayload || len(this.Set) > 0) && payload != nil {
+		if field, ok := duplicateField(payload); ok {
+			return fmt.Sprintf("%s is given more than once", field)
+		}
+	}
+
+	for field, forbidden := range this.Forbid {
+		if value, ok := getField(payload, field); ok && reflect.DeepEqual(value, forbidden) {
+			return fmt.Sprintf("%s may not be %v", field, forbidden)
+		}
+	}
+
+	if this.Binds != nil {
+		for _, source := range bindSources(payload) {
+			if !bindAllowed(source, this.Binds) {
+				return fmt.Sprintf("bind mount of %s is not allowed", source)
+			}
+		}
+	}
+
+	if image, ok := requestImage(endpoint, query, payload); ok && this.Registries != nil {
+		registry := imageRegistry(image)
+
+		found := false
+		for _, allowed := range this.Registries {
+			found = found || strings.EqualFold(allowed, registry)
+		}
+
+		if !found {
+			return fmt.Sprintf("images from %s are not allowed", registry)
+		}
+	}
+
+	return ""
+}
+
+/*
+   Applies the policy to the request. The payload may be rewritten in
+   place, readable is false if the request has a body which is not a
+   JSON object.
+
+   RETURN: Whether the payload was rewritten, and a 403 *HandlerError
+           if the request violates the policy
+*/
+func checkPolicy(user *auth.User, method, host, endpoint string, payload map[string]interface{}, readable bool) (bool, *handlers.HandlerError) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	endpoint, query := normalizeEndpoint(endpoint)
+	method = strings.ToUpper(method)
+
+	applicable := []*PolicyRule{}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if !rule.appliesTo(user, method, host, endpoint) {
+			continue
+		}
+
+		if reason := rule.violation(endpoint, query, payload, readable); reason != "" {
+			email := ""
+			if user != nil {
+				email = user.Email
+			}
+
+			logging.Info(fmt.Sprintf("Policy %q denied %s %s on %s by %s: %s",
+				rule.Name, method, endpoint, host, email, reason))
+
+			return false, &handlers.HandlerError{403, "policy", rule.Name + ": " + reason}
+		}
+
+		applicable = append(applicable, rule)
+	}
+
+	rewritten := false
+
+	for _, rule := range applicable {
+		if len(rule.Set) > 0 && payload != nil {
+			for field, value := range rule.Set {
+				setField(payload, field, value)
+			}
+			rewritten = true
+		}
+	}
+
+	return rewritten, nil
+}
+
+/*
+   Applies the policy to a Docker request made on behalf of the user.
+   The payload, if any, is rewritten in place.
+
+   RETURN: nil if the request is allowed, a 403 *HandlerError otherwise
+*/
+func CheckPolicy(user *auth.User, method, host, endpoint string, payload map[string]interface{}) *handlers.HandlerError {
+	_, err := checkPolicy(user, method, host, endpoint, payload, true)
+	return err
+}
+
+/*
+   Reads a JSON body sent without the 'Payload' wrapper, leaving the
+   request's body in place. Bodies of other types are left alone,
+   Docker refuses them on endpoints which take JSON.
+
+   RETURN: The payload, or nil if there is none, and false if the body
+           is JSON but not an object
+*/
+func readRawPayload(info handlers.HandlerInfo) (map[string]interface{}, bool) {
+	r := info.Request
+	if r.Body == nil {
+		return nil, true
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+			return nil, true
+		}
+	}
+
+	raw, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, true
+	}
+
+	var payload map[string]interface{}
+	if json.Unmarshal(raw, &payload) != nil || payload == nil {
+		return nil, false
+	}
+
+	return payload, true
+}
+
+/*
+   Custom handler enforcing the policy on requests through the Docker
+   endpoint. Rewritten payloads replace what is forwarded to Docker.
+*/
+func PolicyHandler(info handlers.HandlerInfo, rollback bool) *handlers.HandlerError {
+	if rollback {
+		return nil
+	}
+
+	user := auth.GetCurrentUser(info.Request)
+	endpoint := dockerEndpointOf(info)
+
+	if info.Body != nil && info.Body.Payload != nil {
+		_, err := checkPolicy(user, info.Request.Method, info.Host, endpoint, info.Body.Payload, true)
+		return err
+	}
+
+	payload, readable := readRawPayload(info)
+
+	rewritten, err := checkPolicy(user, info.Request.Method, info.Host, endpoint, payload, readable)
+	if err != nil {
+		return err
+	}
+
+	if rewritten {
+		body, _ := json.Marshal(payload)
+		info.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		info.Request.ContentLength = int64(len(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func setupTestingPolicy() {
+	SetPolicy(Policy{Rules: []PolicyRule{
+		{
+			Name:     "no-privileged",
+			Endpoint: "^containers/create$",
+			Methods:  []string{"post"},
+			Forbid:   map[string]interface{}{"HostConfig.Privileged": true},
+		},
+		{
+			Name:     "binds",
+			Endpoint: "^containers/create$",
+			Binds:    []string{"/data/"},
+		},
+		{
+			Name:       "registries",
+			Endpoint:   "^(containers|images)/create$",
+			Registries: []string{"docker.io", "registry.example.com"},
+		},
+		{
+			Name:     "owners-delete-images",
+			Endpoint: "^images/",
+			Methods:  []string{"DELETE"},
+			Exempt:   "owner",
+			Deny:     true,
+		},
+		{
+			Name:     "read-only",
+			Endpoint: "^containers/create$",
+			Set:      map[string]interface{}{"HostConfig.ReadonlyRootfs": true},
+		},
+	}})
+}
+
+func Test_SetPolicy_Invalid(t *testing.T) {
+	defer SetPolicy(Policy{})
+
+	err := SetPolicy(Policy{Rules: []PolicyRule{{Name: "bad", Endpoint: "("}}})
+	assert.NotNil(t, err)
+
+	err = SetPolicy(Policy{Rules: []PolicyRule{{Name: "bad", Endpoint: ".*", Exempt: "admin"}}})
+	assert.NotNil(t, err)
+}
+
+func Test_NormalizeEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"containers/create":               "containers/create",
+		"/v1.41/containers/create?name=x": "containers/create",
+		"containers//create":              "containers/create",
+		"images/../containers/create":     "containers/create",
+		"containers%2Fcreate":             "containers/create",
+	}
+
+	for endpoint, expected := range tests {
+		normalized, _ := normalizeEndpoint(endpoint)
+		assert.Equal(t, expected, normalized, endpoint)
+	}
+
+	_, query := normalizeEndpoint("images/create?fromImage=ubuntu&tag=latest")
+	assert.Equal(t, url.Values{"fromImage": {"ubuntu"}, "tag": {"latest"}}, query)
+}
+
+func Test_ImageRegistry(t *testing.T) {
+	tests := map[string]string{
+		"ubuntu":                          "docker.io",
+		"library/ubuntu:14.04":            "docker.io",
+		"registry.example.com/team/app:1": "registry.example.com",
+		"localhost:5000/app":              "localhost:5000",
+		"localhost/app":                   "localhost",
+	}
+
+	for image, expected := range tests {
+		assert.Equal(t, expected, imageRegistry(image), image)
+	}
+}
+
+func Test_CheckPolicy(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	setupTestingPolicy()
+	defer SetPolicy(Policy{})
+
+	user, _ := auth.GetUser(email)
+
+	denied := []map[string]interface{}{
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{"Privileged": true}},
+		{"Image": "ubuntu", "hostconfig": map[string]interface{}{"privileged": true}},
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{"Binds": []interface{}{"/etc:/etc"}}},
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{"Binds": []interface{}{"/data/../etc:/etc"}}},
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{
+			"Mounts": []interface{}{map[string]interface{}{"Type": "bind", "Source": "/"}},
+		}},
+		{"Image": "evil.example.com/ubuntu"},
+
+		// Docker keeps the last of fields given more than once
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{"Privileged": false, "privileged": true}},
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{}, "hostConfig": map[string]interface{}{"Privileged": true}},
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{"Binds": []interface{}{}, "binds": []interface{}{"/etc:/etc"}}},
+		{"Image": "ubuntu", "HostConfig": map[string]interface{}{
+			"Mounts": []interface{}{map[string]interface{}{"Type": "volume", "type": "bind", "Source": "/"}},
+		}},
+	}
+
+	for _, payload := range denied {
+		err := CheckPolicy(user, "POST", "HOST", "containers/create", payload)
+		if assert.NotNil(t, err, "%v", payload) {
+			assert.Equal(t, 403, err.StatusCode)
+			assert.Equal(t, "policy", err.Cause)
+		}
+	}
+
+	// The API version prefix does not get around the policy
+	payload := map[string]interface{}{"Image": "ubuntu", "HostConfig": map[string]interface{}{"Privileged": true}}
+	assert.NotNil(t, CheckPolicy(user, "POST", "HOST", "v1.41/containers/create", payload))
+
+	payload = map[string]interface{}{
+		"Image": "registry.example.com/app",
+		"HostConfig": map[string]interface{}{
+			"Privileged": false,
+			"Binds":      []interface{}{"/data/app:/app:ro", "volume:/var/lib"},
+		},
+	}
+
+	assert.Nil(t, CheckPolicy(user, "POST", "HOST", "containers/create", payload))
+	assert.Equal(t, true, payload["HostConfig"].(map[string]interface{})["ReadonlyRootfs"])
+
+	payload = map[string]interface{}{"Image": "ubuntu", "HostConfig": map[string]interface{}{}, "hostconfig": nil}
+	err := CheckPolicy(user, "POST", "HOST", "containers/create", payload)
+	assert.True(t, strings.HasSuffix(strings.ToLower(err.Message), ": hostconfig is given more than once"), err.Message)
+
+	err = CheckPolicy(user, "POST", "HOST", "images/create?fromImage=evil.example.com/app", nil)
+	assert.Equal(t, "registries: images from evil.example.com are not allowed", err.Message)
+
+	assert.Nil(t, CheckPolicy(user, "POST", "HOST", "images/create?fromImage=ubuntu", nil))
+
+	// Only owners of the host may delete images
+	assert.NotNil(t, CheckPolicy(user, "DELETE", "HOST", "images/ubuntu", nil))
+	assert.Nil(t, CheckPolicy(user, "GET", "HOST", "images/json", nil))
+
+	auth.SetUserHostAuthLevel(user, "HOST", auth.OwnerAuthLevel)
+	assert.Nil(t, CheckPolicy(user, "DELETE", "HOST", "images/ubuntu", nil))
+}
+
+func runPolicyHandlerTest(email, method, endpoint, contentType string, body []byte) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, "/localhost:8080/"+endpoint, bytes.NewReader(body))
+	r.RequestURI = "/localhost:8080/" + endpoint
+	r.Header.Set("Content-Type", contentType)
+	session.SetValue(r, "auth", "email", email)
+
+	router := mux.NewRouter()
+	Handle(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	return w
+}
+
+func Test_DockerHandler_Policy(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	setupTestingPolicy()
+	defer SetPolicy(Policy{})
+
+	received := make(chan map[string]interface{}, 1)
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+
+		received <- payload
+		w.WriteHeader(201)
+	}
+
+	defer SetupServer(&h).Close()
+
+	privileged := []byte(`{"Image":"ubuntu","HostConfig":{"Privileged":true}}`)
+
+	w := runPolicyHandlerTest(email, "POST", "containers/create", "application/json", privileged)
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, w.Body.String(), "no-privileged")
+	assert.Equal(t, 0, len(received))
+
+	// Bodies which are not objects cannot be checked
+	w = runPolicyHandlerTest(email, "POST", "containers/create", "application/json", []byte(`[1]`))
+	assert.Equal(t, 403, w.Code)
+
+	// Raw and wrapped payloads are both rewritten before being forwarded
+	bodies := [][]byte{
+		[]byte(`{"Image":"ubuntu"}`),
+		[]byte(`{"Payload":{"Image":"ubuntu"}}`),
+	}
+
+	for _, body := range bodies {
+		w = runPolicyHandlerTest(email, "POST", "containers/create", "application/json", body)
+		assert.Equal(t, 201, w.Code)
+
+		payload := <-received
+		assert.Equal(t, "ubuntu", payload["Image"])
+		assert.Equal(t, map[string]interface{}{"ReadonlyRootfs": true}, payload["HostConfig"])
+	}
+
+	w = runPolicyHandlerTest(email, "DELETE", "images/ubuntu", "", nil)
+	assert.Equal(t, 403, w.Code)
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Size of the buffer used to stream Docker responses
+const STREAM_BUFFER_SIZE = 32 * 1024
+
+// Largest Docker error body relayed to the client
+const MAX_ERROR_BODY_SIZE = 64 * 1024
+
+// Streams of attached containers, as numbered in Docker's stream framing
+const (
+	STREAM_STDIN  = 0
+	STREAM_STDOUT = 1
+	STREAM_STDERR = 2
+)
+
+/*
+   Request headers passed on to Docker. Everything else, in particular
+   Lighthouse's own cookies, stays behind.
+*/
+var forwardedRequestHeaders = []string{
+	"Accept",
+	"Content-Type",
+	"X-Registry-Auth",
+	"X-Registry-Config",
+}
+
+/*
+   Response headers which only apply to a single connection and so are
+   not passed back from Docker.
+*/
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+func copyRequestHeaders(to, from http.Header) {
+	for _, key := range forwardedRequestHeaders {
+		if values, ok := from[http.CanonicalHeaderKey(key)]; ok {
+			to[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	}
+}
+
+func copyResponseHeaders(to, from http.Header) {
+	for key, values := range from {
+		if !hopByHopHeaders[http.CanonicalHeaderKey(key)] {
+			to[key] = append([]string(nil), values...)
+		}
+	}
+}
+
+/*
+   Copies the body to the writer, flushing after every read so that
+   streamed responses such as logs, events and pull progress reach the
+   client as Docker sends them.
+*/
+func streamBody(w http.ResponseWriter, body io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buffer := make([]byte, STREAM_BUFFER_SIZE)
+
+	for {
+		n, err := body.Read(buffer)
+
+		if n > 0 {
+			if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+   RETURN: The message of Docker's JSON error body, the body itself if
+           it is not JSON, or the response status if it is empty
+*/
+func readDockerError(resp *http.Response) string {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_ERROR_BODY_SIZE))
+
+	var dockerError struct {
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(body, &dockerError) == nil && dockerError.Message != "" {
+		return dockerError.Message
+	}
+
+	if message := strings.TrimSpace(string(body)); message != "" {
+		return message
+	}
+
+	return resp.Status
+}
+
+/*
+   Reads a container's output stream, as returned by the attach, exec
+   start and logs endpoints, passing it to emit in chunks. Without a TTY
+   Docker multiplexes stdout and stderr, each frame starting with an
+   8 byte header of the stream followed by the big endian size. With a
+   TTY everything is stdout.
+
+   The data passed to emit is only valid until it returns.
+
+   RETURN: nil once the stream ends, otherwise the first read or emit error
+*/
+func ReadDockerStream(body io.Reader, multiplexed bool, emit func(stream byte, data []byte) error) error {
+	buffer := make([]byte, STREAM_BUFFER_SIZE)
+
+	if !multiplexed {
+		return emitChunks(body, STREAM_STDOUT, buffer, emit)
+	}
+
+	header := make([]byte, 8)
+
+	for {
+		_, err := io.ReadFull(body, header)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		frame := &io.LimitedReader{R: body, N: int64(binary.BigEndian.Uint32(header[4:]))}
+
+		if err = emitChunks(frame, header[0], buffer, emit); err != nil {
+			return err
+		}
+
+		if frame.N > 0 {
+			return io.ErrUnexpectedEOF
+		}
+	}
+}
+
+func emitChunks(r io.Reader, stream byte, buffer []byte, emit func(byte, []byte) error) error {
+	for {
+		n, err := r.Read(buffer)
+
+		if n > 0 {
+			if emitErr := emit(stream, buffer[:n]); emitErr != nil {
+				return emitErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
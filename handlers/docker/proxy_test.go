@@ -0,0 +1,159 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/handlers"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func Test_DockerRequestHandler_RawBody(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "TARBALL", string(body))
+		assert.Equal(t, "application/x-tar", r.Header.Get("Content-Type"))
+		assert.Equal(t, "AUTH", r.Header.Get("X-Registry-Auth"))
+		assert.Equal(t, "", r.Header.Get("Cookie"))
+		assert.Equal(t, "t=test", r.URL.RawQuery)
+
+		w.WriteHeader(200)
+	}
+
+	defer SetupServer(&h).Close()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/build?t=test", bytes.NewBufferString("TARBALL"))
+	r.Header.Set("Content-Type", "application/x-tar")
+	r.Header.Set("X-Registry-Auth", "AUTH")
+	r.Header.Set("Cookie", "session=SECRET")
+	session.SetValue(r, "auth", "email", email)
+
+	info := handlers.HandlerInfo{"build", "localhost:8080", handlers.GetRequestBody(r), r, nil}
+
+	assert.Nil(t, DockerRequestHandler(w, info))
+	assert.Equal(t, 200, w.Code)
+}
+
+func Test_DockerRequestHandler_RawJSONBody(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, `{"Image":"ubuntu"}`, string(body))
+
+		w.WriteHeader(201)
+	}
+
+	defer SetupServer(&h).Close()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/containers/create", bytes.NewBufferString(`{"Image":"ubuntu"}`))
+	r.Header.Set("Content-Type", "application/json")
+	session.SetValue(r, "auth", "email", email)
+
+	info := handlers.HandlerInfo{"containers/create", "localhost:8080", handlers.GetRequestBody(r), r, nil}
+
+	assert.Nil(t, DockerRequestHandler(w, info))
+	assert.Equal(t, 201, w.Code)
+}
+
+func Test_DockerRequestHandler_ResponseHeaders(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		w.Header().Set("Api-Version", "1.41")
+		w.Header().Set("Connection", "close")
+
+		w.WriteHeader(200)
+
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("line\n"))
+			w.(http.Flusher).Flush()
+		}
+	}
+
+	defer SetupServer(&h).Close()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/containers/ID/logs?follow=1", nil)
+	session.SetValue(r, "auth", "email", email)
+	info := handlers.HandlerInfo{"containers/ID/logs?follow=1", "localhost:8080", nil, r, nil}
+
+	assert.Nil(t, DockerRequestHandler(w, info))
+
+	assert.Equal(t, "application/vnd.docker.raw-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "1.41", w.Header().Get("Api-Version"))
+	assert.Equal(t, "", w.Header().Get("Connection"))
+	assert.Equal(t, "line\nline\nline\n", w.Body.String())
+	assert.True(t, w.Flushed)
+}
+
+func Test_DockerRequestHandler_ErrorBody(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	messages := map[string]string{
+		`{"message":"No such container: ID"}`: "No such container: ID",
+		"plain failure\n":                     "plain failure",
+		"":                                    "404 Not Found",
+	}
+
+	for body, expected := range messages {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			w.Write([]byte(body))
+		}
+
+		server := SetupServer(&h)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/containers/ID/json", nil)
+		session.SetValue(r, "auth", "email", email)
+		info := handlers.HandlerInfo{"containers/ID/json", "localhost:8080", nil, r, nil}
+
+		err := DockerRequestHandler(w, info)
+
+		server.Close()
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 404, err.StatusCode)
+		assert.Equal(t, "docker", err.Cause)
+		assert.Equal(t, expected, err.Message)
+	}
+}
+
+func Test_StreamBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	data := strings.Repeat("x", STREAM_BUFFER_SIZE*2+10)
+
+	assert.Nil(t, streamBody(w, strings.NewReader(data)))
+	assert.Equal(t, data, w.Body.String())
+	assert.True(t, w.Flushed)
+}
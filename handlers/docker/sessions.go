@@ -0,0 +1,485 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/handlers"
+	"github.com/lighthouse/lighthouse/logging"
+)
+
+// Command run by exec sessions which do not give one
+var DefaultExecCommand = []string{"/bin/sh"}
+
+// How often sessions are pinged so idle ones are not dropped by proxies
+var SessionPingInterval = 30 * time.Second
+
+/*
+   Reports the application a container belongs to, if any. Set by the
+   applications package, which cannot be imported here, so users who
+   may modify an application can open sessions in its containers and
+   the container inventory can name their applications.
+*/
+type ApplicationFunc func(instance, container string) (string, bool)
+
+func defaultApplicationFunc(instance, container string) (string, bool) {
+	return "", false
+}
+
+var applicationFunc ApplicationFunc = defaultApplicationFunc
+
+func SetApplicationFunc(f ApplicationFunc) {
+	applicationFunc = f
+}
+
+/*
+   Like ApplicationFunc for every container at once, so that the
+   container inventory looks the applications up once per request.
+
+   RETURN: The names of the applications deployed to each instance
+*/
+type ApplicationIndexFunc func() map[string]map[string]bool
+
+func defaultApplicationIndexFunc() map[string]map[string]bool {
+	return map[string]map[string]bool{}
+}
+
+var applicationIndexFunc ApplicationIndexFunc = defaultApplicationIndexFunc
+
+func SetApplicationIndexFunc(f ApplicationIndexFunc) {
+	applicationIndexFunc = f
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  STREAM_BUFFER_SIZE,
+	WriteBufferSize: STREAM_BUFFER_SIZE,
+}
+
+/*
+   Interactive sessions relay a container's stdin, stdout and stderr
+   over a WebSocket.
+
+   Client to server: binary messages are written to stdin as they are.
+   Text messages are JSON sessionMessages of Type "stdin" (Data is
+   written to stdin) or "resize" (Width and Height set the TTY size).
+
+   Server to client: binary messages are output, the first byte being
+   the stream it was written to (STREAM_STDOUT or STREAM_STDERR) and
+   the rest the data. When the output ends a text "exit" message with
+   the exit code is sent, if it is known, and the WebSocket is closed.
+*/
+type sessionMessage struct {
+	Type     string
+	Data     string `json:",omitempty"`
+	Width    uint   `json:",omitempty"`
+	Height   uint   `json:",omitempty"`
+	ExitCode *int   `json:",omitempty"`
+}
+
+type containerInfo struct {
+	Id     string
+	Name   string
+	Config struct {
+		Tty bool
+	}
+	State struct {
+		Running  bool
+		ExitCode int
+	}
+}
+
+type execSession struct {
+	user      *auth.User
+	host      string
+	container containerInfo
+	execId    string // Empty when attached to the container itself
+	tty       bool
+}
+
+/*
+   Users may open sessions in containers on beacons (or direct Docker
+   hosts) they can modify, or in containers of applications they can
+   modify.
+*/
+func canOpenSession(user *auth.User, host string, container containerInfo) bool {
+	if user == nil {
+		return false
+	}
+
+	if beacons.GetDockerAuthLevel(user, host) >= auth.ModifyAuthLevel {
+		return true
+	}
+
+	app, ok := applicationFunc(host, strings.TrimPrefix(container.Name, "/"))
+	return ok && user.CanModifyApplication(app)
+}
+
+/*
+   Sends a request with a JSON body to Docker and decodes its JSON
+   response into out, if it is not nil.
+*/
+func dockerJSON(user *auth.User, method, host, endpoint string, body, out interface{}) *handlers.HandlerError {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+
+	req, err := MakeDockerRequest(user, method, host, endpoint, payload)
+	if err != nil {
+		return requestError(err, "Failed to create "+method+" request")
+	}
+
+	resp, err := SendDockerRequest(req)
+	if err != nil {
+		return requestError(err, method+" request failed")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return &handlers.HandlerError{resp.StatusCode, "docker", readDockerError(resp)}
+	}
+
+	if out != nil {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(respBody, out) != nil {
+			return &handlers.HandlerError{502, "docker", "Unexpected response from Docker"}
+		}
+	}
+
+	return nil
+}
+
+/*
+   Asks Docker to upgrade the request's connection to a raw stream, as
+   the attach and exec start endpoints do.
+
+   RETURN: The raw stream to the container
+*/
+func hijackDockerStream(user *auth.User, host, endpoint string, body interface{}) (io.ReadWriteCloser, *handlers.HandlerError) {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+
+	req, err := MakeDockerRequest(user, "POST", host, endpoint, payload)
+	if err != nil {
+		return nil, requestError(err, "Failed to create POST request")
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	resp, err := SendDockerRequest(req)
+	if err != nil {
+		return nil, requestError(err, "POST request failed")
+	}
+
+	if resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, &handlers.HandlerError{resp.StatusCode, "docker", readDockerError(resp)}
+	}
+
+	stream, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		resp.Body.Close()
+		return nil, &handlers.HandlerError{502, "docker", "Docker did not upgrade the connection"}
+	}
+
+	return stream, nil
+}
+
+func (this *execSession) resize(width, height uint) {
+	var endpoint string
+	if this.execId != "" {
+		endpoint = fmt.Sprintf("exec/%s/resize?h=%d&w=%d", this.execId, height, width)
+	} else {
+		endpoint = fmt.Sprintf("containers/%s/resize?h=%d&w=%d", this.container.Id, height, width)
+	}
+
+	if err := dockerJSON(this.user, "POST", this.host, endpoint, nil, nil); err != nil {
+		logging.Info("Failed to resize session in " + this.container.Id + ": " + err.Message)
+	}
+}
+
+// RETURN: The exit code of the session's process, if it has exited
+func (this *execSession) exitCode() (int, bool) {
+	if this.execId != "" {
+		var exec struct {
+			Running  bool
+			ExitCode int
+		}
+
+		err := dockerJSON(this.user, "GET", this.host, "exec/"+this.execId+"/json", nil, &exec)
+		return exec.ExitCode, err == nil && !exec.Running
+	}
+
+	var container containerInfo
+
+	err := dockerJSON(this.user, "GET", this.host, "containers/"+this.container.Id+"/json", nil, &container)
+	return container.State.ExitCode, err == nil && !container.State.Running
+}
+
+/*
+   Relays messages from the WebSocket to the container until either
+   side goes away.
+*/
+func (this *execSession) relayInput(conn *websocket.Conn, stream io.ReadWriteCloser) {
+	// Unblocks relayOutput if the client leaves first
+	defer stream.Close()
+
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if kind == websocket.BinaryMessage {
+			if _, err = stream.Write(data); err != nil {
+				return
+			}
+			continue
+		}
+
+		var msg sessionMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case "stdin":
+			if _, err = stream.Write([]byte(msg.Data)); err != nil {
+				return
+			}
+		case "resize":
+			if msg.Width > 0 && msg.Height > 0 {
+				this.resize(msg.Width, msg.Height)
+			}
+		}
+	}
+}
+
+// Relays the container's output to the WebSocket
+func (this *execSession) relayOutput(conn *websocket.Conn, stream io.Reader) error {
+	message := make([]byte, 0, STREAM_BUFFER_SIZE+1)
+
+	return ReadDockerStream(stream, !this.tty, func(kind byte, data []byte) error {
+		message = append(append(message[:0], kind), data...)
+		return conn.WriteMessage(websocket.BinaryMessage, message)
+	})
+}
+
+func (this *execSession) run(conn *websocket.Conn, stream io.ReadWriteCloser) {
+	defer conn.Close()
+	defer stream.Close()
+
+	done := make(chan bool)
+	defer close(done)
+
+	go this.relayInput(conn, stream)
+
+	go func() {
+		ticker := time.NewTicker(SessionPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				deadline := time.Now().Add(SessionPingInterval)
+				conn.WriteControl(websocket.PingMessage, nil, deadline)
+			}
+		}
+	}()
+
+	err := this.relayOutput(conn, stream)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		logging.Info("Session in " + this.container.Id + " ended: " + err.Error())
+	}
+
+	exit := sessionMessage{Type: "exit"}
+	if code, ok := this.exitCode(); ok {
+		exit.ExitCode = &code
+	}
+
+	conn.WriteJSON(exit)
+
+	closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+}
+
+/*
+   Looks up the container of the request and checks that the current
+   user may open a session in it. Writes the error on failure.
+*/
+func startSession(w http.ResponseWriter, r *http.Request) (*execSession, bool) {
+	params := mux.Vars(r)
+
+	this := &execSession{
+		user: auth.GetCurrentUser(r),
+		host: resolveHost(params["Host"]),
+	}
+
+	err := dockerJSON(this.user, "GET", this.host, "containers/"+params["Container"]+"/json", nil, &this.container)
+	if err != nil {
+		handlers.WriteError(w, err.StatusCode, err.Cause, err.Message)
+		return nil, false
+	}
+
+	if !canOpenSession(this.user, this.host, this.container) {
+		handlers.WriteError(w, http.StatusForbidden, "sessions", "user not permitted to open a session in container")
+		return nil, false
+	}
+
+	return this, true
+}
+
+func serveSession(w http.ResponseWriter, r *http.Request, this *execSession, stream io.ReadWriteCloser) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already written the error
+		stream.Close()
+		return
+	}
+
+	logging.Info(fmt.Sprintf("%s opened a session in %s on %s", this.user.Email, this.container.Id, this.host))
+
+	this.run(conn, stream)
+}
+
+/*
+   Opens an interactive exec session in the container. The command is
+   given by repeated cmd parameters (defaults to DefaultExecCommand),
+   tty decides whether it gets a TTY (defaults to true), and width and
+   height set its initial size.
+*/
+func handleExecSession(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	tty := true
+	if value := query.Get("tty"); value != "" {
+		var err error
+		if tty, err = strconv.ParseBool(value); err != nil {
+			handlers.WriteError(w, http.StatusBadRequest, "sessions", "invalid tty parameter")
+			return
+		}
+	}
+
+	cmd := query["cmd"]
+	if len(cmd) == 0 {
+		cmd = DefaultExecCommand
+	}
+
+	this, ok := startSession(w, r)
+	if !ok {
+		return
+	}
+
+	this.tty = tty
+
+	create := map[string]interface{}{
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          tty,
+		"Cmd":          cmd,
+	}
+
+	createEndpoint := "containers/" + this.container.Id + "/exec"
+
+	err := CheckPolicy(this.user, "POST", this.host, createEndpoint, create)
+	if err != nil {
+		handlers.WriteError(w, err.StatusCode, err.Cause, err.Message)
+		return
+	}
+
+	var exec struct {
+		Id string
+	}
+
+	err = dockerJSON(this.user, "POST", this.host, createEndpoint, create, &exec)
+	if err != nil {
+		handlers.WriteError(w, err.StatusCode, err.Cause, err.Message)
+		return
+	}
+
+	this.execId = exec.Id
+
+	start := map[string]interface{}{"Detach": false, "Tty": tty}
+
+	stream, err := hijackDockerStream(this.user, this.host, "exec/"+this.execId+"/start", start)
+	if err != nil {
+		handlers.WriteError(w, err.StatusCode, err.Cause, err.Message)
+		return
+	}
+
+	width, _ := strconv.ParseUint(query.Get("width"), 10, 16)
+	height, _ := strconv.ParseUint(query.Get("height"), 10, 16)
+
+	if tty && width > 0 && height > 0 {
+		this.resize(uint(width), uint(height))
+	}
+
+	serveSession(w, r, this, stream)
+}
+
+// Attaches to the container's main process
+func handleAttachSession(w http.ResponseWriter, r *http.Request) {
+	this, ok := startSession(w, r)
+	if !ok {
+		return
+	}
+
+	this.tty = this.container.Config.Tty
+
+	endpoint := "containers/" + this.container.Id + "/attach?stream=1&stdin=1&stdout=1&stderr=1"
+
+	if err := CheckPolicy(this.user, "POST", this.host, endpoint, nil); err != nil {
+		handlers.WriteError(w, err.StatusCode, err.Cause, err.Message)
+		return
+	}
+
+	stream, err := hijackDockerStream(this.user, this.host, endpoint, nil)
+	if err != nil {
+		handlers.WriteError(w, err.StatusCode, err.Cause, err.Message)
+		return
+	}
+
+	serveSession(w, r, this, stream)
+}
+
+/*
+   Sessions mirror Docker's own paths, e.g.
+   /{Host}/containers/{Container}/exec, where Host may be an alias.
+*/
+func HandleSessions(r *mux.Router) {
+	r.HandleFunc("/{Host:.*}/containers/{Container}/exec", handleExecSession).Methods("GET")
+
+	r.HandleFunc("/{Host:.*}/containers/{Container}/attach", handleAttachSession).Methods("GET")
+}
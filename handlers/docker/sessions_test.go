@@ -0,0 +1,253 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func writeFrame(w *strings.Builder, stream byte, data string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+
+	w.Write(header)
+	w.WriteString(data)
+}
+
+/*
+   Emulates Docker for the session tests. Sessions echo back the first
+   line of input, on stdout and stderr when not using a TTY.
+*/
+func setupSessionServer(t *testing.T, tty bool, requests chan string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/containers/ID/json":
+			w.Write([]byte(`{"Id":"ID","Name":"/APP","Config":{"Tty":false},"State":{"Running":false,"ExitCode":7}}`))
+
+		case "/containers/ID/exec":
+			requests <- string(body)
+			w.WriteHeader(201)
+			w.Write([]byte(`{"Id":"EXEC"}`))
+
+		case "/exec/EXEC/json":
+			w.Write([]byte(`{"Running":false,"ExitCode":3}`))
+
+		case "/exec/EXEC/resize":
+			requests <- r.URL.RawQuery
+
+		case "/exec/EXEC/start", "/containers/ID/attach":
+			assert.Equal(t, "tcp", r.Header.Get("Upgrade"))
+
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+
+			rw.WriteString("HTTP/1.1 101 UPGRADED\r\n" +
+				"Content-Type: application/vnd.docker.raw-stream\r\n" +
+				"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			rw.Flush()
+
+			line, _ := rw.ReadString('\n')
+
+			if tty {
+				rw.WriteString("OUT:" + line)
+			} else {
+				var frames strings.Builder
+				writeFrame(&frames, STREAM_STDOUT, "OUT:"+line)
+				writeFrame(&frames, STREAM_STDERR, "ERR")
+				rw.WriteString(frames.String())
+			}
+
+			rw.Flush()
+
+		default:
+			w.WriteHeader(404)
+		}
+	}
+}
+
+func dialSession(t *testing.T, email, path string) (*websocket.Conn, *http.Response, func()) {
+	router := mux.NewRouter()
+	HandleSessions(router.PathPrefix("/ws").Subrouter())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session.SetValue(r, "auth", "email", email)
+		router.ServeHTTP(w, r)
+	}))
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, resp, _ := websocket.DefaultDialer.Dial(url, nil)
+
+	return conn, resp, func() {
+		if conn != nil {
+			conn.Close()
+		}
+		server.Close()
+	}
+}
+
+func readOutput(t *testing.T, conn *websocket.Conn) string {
+	kind, data, err := conn.ReadMessage()
+
+	assert.Nil(t, err)
+	assert.Equal(t, websocket.BinaryMessage, kind)
+
+	return string(data)
+}
+
+func readExit(t *testing.T, conn *websocket.Conn) sessionMessage {
+	var exit sessionMessage
+
+	assert.Nil(t, conn.ReadJSON(&exit))
+	assert.Equal(t, "exit", exit.Type)
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+
+	return exit
+}
+
+func Test_ExecSession(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	requests := make(chan string, 4)
+	h := setupSessionServer(t, false, requests)
+	defer SetupServer(&h).Close()
+
+	user, _ := auth.GetUser(email)
+	auth.SetUserHostAuthLevel(user, "localhost:8080", auth.ModifyAuthLevel)
+
+	conn, _, done := dialSession(t, email, "/ws/localhost:8080/containers/ID/exec?cmd=cat&cmd=-u&tty=0")
+	defer done()
+
+	var create map[string]interface{}
+	json.Unmarshal([]byte(<-requests), &create)
+	assert.Equal(t, []interface{}{"cat", "-u"}, create["Cmd"])
+	assert.Equal(t, false, create["Tty"])
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("hello\n"))
+
+	assert.Equal(t, "\x01OUT:hello\n", readOutput(t, conn))
+	assert.Equal(t, "\x02ERR", readOutput(t, conn))
+
+	exit := readExit(t, conn)
+	assert.Equal(t, 3, *exit.ExitCode)
+}
+
+func Test_ExecSession_TTY(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	requests := make(chan string, 4)
+	h := setupSessionServer(t, true, requests)
+	defer SetupServer(&h).Close()
+
+	user, _ := auth.GetUser(email)
+	auth.SetUserHostAuthLevel(user, "localhost:8080", auth.ModifyAuthLevel)
+
+	conn, _, done := dialSession(t, email, "/ws/localhost:8080/containers/ID/exec?width=80&height=24")
+	defer done()
+
+	var create map[string]interface{}
+	json.Unmarshal([]byte(<-requests), &create)
+	assert.Equal(t, []interface{}{"/bin/sh"}, create["Cmd"])
+	assert.Equal(t, true, create["Tty"])
+
+	assert.Equal(t, "h=24&w=80", <-requests)
+
+	conn.WriteJSON(sessionMessage{Type: "stdin", Data: "ls\n"})
+
+	assert.Equal(t, "\x01OUT:ls\n", readOutput(t, conn))
+
+	readExit(t, conn)
+}
+
+func Test_AttachSession(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	h := setupSessionServer(t, false, nil)
+	defer SetupServer(&h).Close()
+
+	user, _ := auth.GetUser(email)
+	auth.SetUserHostAuthLevel(user, "localhost:8080", auth.ModifyAuthLevel)
+
+	conn, _, done := dialSession(t, email, "/ws/localhost:8080/containers/ID/attach")
+	defer done()
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("input\n"))
+
+	assert.Equal(t, "\x01OUT:input\n", readOutput(t, conn))
+	assert.Equal(t, "\x02ERR", readOutput(t, conn))
+
+	exit := readExit(t, conn)
+	assert.Equal(t, 7, *exit.ExitCode)
+}
+
+func Test_Session_Permissions(t *testing.T) {
+	email := setup()
+	defer teardown()
+	defer SetApplicationFunc(defaultApplicationFunc)
+
+	h := setupSessionServer(t, false, make(chan string, 4))
+	defer SetupServer(&h).Close()
+
+	user, _ := auth.GetUser(email)
+	auth.SetUserHostAuthLevel(user, "localhost:8080", auth.AccessAuthLevel)
+
+	_, resp, done := dialSession(t, email, "/ws/localhost:8080/containers/ID/attach")
+	done()
+
+	assert.Equal(t, 403, resp.StatusCode)
+
+	_, resp, done = dialSession(t, email, "/ws/localhost:8080/containers/OTHER/attach")
+	done()
+
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Users who may modify the container's application may open sessions in it
+	SetApplicationFunc(func(instance, container string) (string, bool) {
+		return container, instance == "localhost:8080"
+	})
+
+	auth.SetUserApplicationAuthLevel(user, "APP", auth.ModifyAuthLevel)
+
+	conn, resp, done := dialSession(t, email, "/ws/localhost:8080/containers/ID/attach")
+	defer done()
+
+	assert.Equal(t, 101, resp.StatusCode)
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("input\n"))
+	assert.Equal(t, "\x01OUT:input\n", readOutput(t, conn))
+	assert.Equal(t, "\x02ERR", readOutput(t, conn))
+
+	readExit(t, conn)
+}
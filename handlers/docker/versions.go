@@ -0,0 +1,310 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lighthouse/lighthouse/auth"
+)
+
+// How long probing the API versions of an instance may take
+var VersionProbeTimeout = 5 * time.Second
+
+// How long the API versions of an instance are trusted before probing again
+var VersionCacheTTL = 10 * time.Minute
+
+/*
+   How long to wait before probing an instance which did not report
+   its API versions again. Requests to it are sent as they are given
+   in the meantime.
+*/
+var VersionRetryInterval = time.Minute
+
+// Daemons before API 1.25 do not report their minimum version
+var defaultMinAPIVersion = apiVersion{1, 12}
+
+/*
+   Endpoints which old daemons do not have, by the API version which
+   added them. Matched against the endpoint without its version prefix
+   or query.
+*/
+var endpointVersions = []struct {
+	endpoint *regexp.Regexp
+	version  apiVersion
+}{
+	{regexp.MustCompile(`^containers/[^/]+/stats$`), apiVersion{1, 17}},
+	{regexp.MustCompile(`^containers/[^/]+/archive$`), apiVersion{1, 20}},
+	{regexp.MustCompile(`^(networks|volumes)(/|$)`), apiVersion{1, 21}},
+	{regexp.MustCompile(`^containers/[^/]+/update$`), apiVersion{1, 22}},
+	{regexp.MustCompile(`^(swarm|nodes|services|tasks)(/|$)`), apiVersion{1, 24}},
+	{regexp.MustCompile(`^(containers|images|networks|volumes)/prune$`), apiVersion{1, 25}},
+	{regexp.MustCompile(`^(secrets|plugins)(/|$)`), apiVersion{1, 25}},
+	{regexp.MustCompile(`^system/df$`), apiVersion{1, 25}},
+	{regexp.MustCompile(`^(configs|distribution)(/|$)`), apiVersion{1, 30}},
+}
+
+var addressVersion = regexp.MustCompile(`/v[0-9.]+$`)
+
+/*
+   A request the instance cannot serve. Endpoint is set when the
+   endpoint needs a newer API than Version, otherwise Version was
+   asked for explicitly and is outside of the instance's versions.
+*/
+type IncompatibleVersionError struct {
+	Endpoint string
+	Required string
+	Version  string
+	Min      string
+	Max      string
+}
+
+func (this IncompatibleVersionError) Error() string {
+	if this.Endpoint != "" {
+		return fmt.Sprintf("docker: %s requires API %s, not %s (the instance supports %s to %s)",
+			this.Endpoint, this.Required, this.Version, this.Min, this.Max)
+	}
+
+	return fmt.Sprintf("docker: API %s is not supported, the instance supports %s to %s",
+		this.Version, this.Min, this.Max)
+}
+
+type apiVersion struct {
+	Major int
+	Minor int
+}
+
+// Accepts versions with or without a leading "v", e.g. "v1.41" or "1.41"
+func parseAPIVersion(version string) (apiVersion, bool) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) != 2 {
+		return apiVersion{}, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return apiVersion{}, false
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return apiVersion{}, false
+	}
+
+	return apiVersion{major, minor}, true
+}
+
+func (this apiVersion) String() string {
+	return fmt.Sprintf("v%d.%d", this.Major, this.Minor)
+}
+
+func (this apiVersion) Less(other apiVersion) bool {
+	if this.Major == other.Major {
+		return this.Minor < other.Minor
+	}
+	return this.Major < other.Major
+}
+
+// The API versions an instance's daemon supports, inclusive
+type versionRange struct {
+	Min apiVersion
+	Max apiVersion
+}
+
+func (this versionRange) contains(version apiVersion) bool {
+	return !version.Less(this.Min) && !this.Max.Less(version)
+}
+
+/*
+   Known is false for instances which could not be probed, whose
+   requests are sent as given until the entry expires.
+*/
+type versionCacheEntry struct {
+	versions versionRange
+	known    bool
+	expires  time.Time
+}
+
+var (
+	versionCache     = make(map[string]versionCacheEntry)
+	versionCacheLock = sync.RWMutex{}
+)
+
+func getCachedVersions(host string) (versionCacheEntry, bool) {
+	versionCacheLock.RLock()
+	defer versionCacheLock.RUnlock()
+
+	entry, ok := versionCache[host]
+	if !ok || time.Now().After(entry.expires) {
+		return versionCacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+func cacheVersions(host string, versions versionRange, known bool) {
+	ttl := VersionCacheTTL
+	if !known {
+		ttl = VersionRetryInterval
+	}
+
+	versionCacheLock.Lock()
+	defer versionCacheLock.Unlock()
+
+	versionCache[host] = versionCacheEntry{versions, known, time.Now().Add(ttl)}
+}
+
+func clearVersionCache() {
+	versionCacheLock.Lock()
+	defer versionCacheLock.Unlock()
+
+	versionCache = make(map[string]versionCacheEntry)
+}
+
+// Fields of Docker's /version which describe its API
+type dockerVersion struct {
+	ApiVersion    string
+	MinAPIVersion string
+}
+
+/*
+   Asks the instance's daemon which API versions it supports, through
+   the address of the instance as it was given.
+*/
+func probeVersions(req *http.Request) (versionRange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), VersionProbeTimeout)
+	defer cancel()
+
+	resp, err := SendDockerRequest(req.WithContext(ctx))
+	if err != nil {
+		return versionRange{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return versionRange{}, fmt.Errorf("%s: %s", resp.Status, readDockerError(resp))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return versionRange{}, err
+	}
+
+	var reported dockerVersion
+	if err = json.Unmarshal(body, &reported); err != nil {
+		return versionRange{}, err
+	}
+
+	versions := versionRange{Min: defaultMinAPIVersion}
+
+	var ok bool
+	if versions.Max, ok = parseAPIVersion(reported.ApiVersion); !ok {
+		return versionRange{}, fmt.Errorf("invalid API version %q", reported.ApiVersion)
+	}
+
+	if min, ok := parseAPIVersion(reported.MinAPIVersion); ok {
+		versions.Min = min
+	}
+
+	if versions.Max.Less(versions.Min) {
+		versions.Min = versions.Max
+	}
+
+	return versions, nil
+}
+
+/*
+   Looks up the API versions of the instance or direct host, probing
+   it if they are not cached. Failing to build the probe, e.g. for a
+   host the user may not use, is returned rather than cached so that
+   it cannot affect other users.
+
+   RETURN: The versions, and whether they are known
+*/
+func getVersions(user *auth.User, host string) (versionRange, bool, error) {
+	if entry, ok := getCachedVersions(host); ok {
+		return entry.versions, entry.known, nil
+	}
+
+	req, err := newDockerRequest(user, "GET", host, host, "version", nil)
+	if err != nil {
+		return versionRange{}, false, err
+	}
+
+	versions, err := probeVersions(req)
+	cacheVersions(host, versions, err == nil)
+
+	return versions, err == nil, nil
+}
+
+/*
+   Routes the endpoint to the highest API version the instance
+   supports, or to the version it starts with if any. Instances
+   registered with a version in their address, e.g. "host:2375/v1.12",
+   are addressed without it. The endpoint is sent as given when the
+   versions of the instance are unknown.
+
+   RETURN: The address to send the request to and its endpoint
+*/
+func negotiateEndpoint(user *auth.User, host, endpoint string) (string, string, error) {
+	versions, known, err := getVersions(user, host)
+	if err != nil || !known {
+		return host, endpoint, err
+	}
+
+	version := versions.Max
+
+	if prefix := versionPrefix.FindString(endpoint); prefix != "" {
+		requested, ok := parseAPIVersion(strings.TrimSuffix(prefix, "/"))
+		if !ok || !versions.contains(requested) {
+			return "", "", IncompatibleVersionError{
+				Version: strings.TrimSuffix(prefix, "/"),
+				Min:     versions.Min.String(),
+				Max:     versions.Max.String(),
+			}
+		}
+
+		version = requested
+		endpoint = strings.TrimPrefix(endpoint, prefix)
+	}
+
+	path, _ := normalizeEndpoint(endpoint)
+
+	for _, required := range endpointVersions {
+		if required.endpoint.MatchString(path) && version.Less(required.version) {
+			return "", "", IncompatibleVersionError{
+				Endpoint: path,
+				Required: required.version.String(),
+				Version:  version.String(),
+				Min:      versions.Min.String(),
+				Max:      versions.Max.String(),
+			}
+		}
+	}
+
+	target := addressVersion.ReplaceAllString(host, "")
+
+	return target, version.String() + "/" + endpoint, nil
+}
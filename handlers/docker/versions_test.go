@@ -0,0 +1,185 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/beacons"
+	"github.com/lighthouse/lighthouse/handlers"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func Test_ParseAPIVersion(t *testing.T) {
+	version, ok := parseAPIVersion("v1.41")
+	assert.True(t, ok)
+	assert.Equal(t, apiVersion{1, 41}, version)
+
+	version, ok = parseAPIVersion("1.9")
+	assert.True(t, ok)
+	assert.Equal(t, "v1.9", version.String())
+
+	for _, invalid := range []string{"", "v1", "1.x", "v1.2.3", "-1.2"} {
+		_, ok = parseAPIVersion(invalid)
+		assert.False(t, ok, invalid)
+	}
+
+	assert.True(t, apiVersion{1, 9}.Less(apiVersion{1, 12}))
+	assert.True(t, apiVersion{1, 41}.Less(apiVersion{2, 0}))
+	assert.False(t, apiVersion{1, 12}.Less(apiVersion{1, 12}))
+
+	versions := versionRange{apiVersion{1, 12}, apiVersion{1, 24}}
+	assert.True(t, versions.contains(apiVersion{1, 12}))
+	assert.True(t, versions.contains(apiVersion{1, 24}))
+	assert.False(t, versions.contains(apiVersion{1, 11}))
+	assert.False(t, versions.contains(apiVersion{1, 25}))
+}
+
+// Starts a daemon which reports the given /version body
+func setupVersionServer(version string, probes *int32) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/version") {
+			atomic.AddInt32(probes, 1)
+			w.Write([]byte(version))
+		}
+	}))
+
+	return server, strings.TrimPrefix(server.URL, "http://")
+}
+
+func Test_NegotiateEndpoint(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	user, _ := auth.GetUser(email)
+
+	var probes int32
+	server, address := setupVersionServer(`{"ApiVersion":"1.24","MinAPIVersion":"1.18"}`, &probes)
+	defer server.Close()
+
+	beacons.AddTestingHost(address)
+	auth.SetUserHostAuthLevel(user, address, auth.AccessAuthLevel)
+
+	req, err := MakeDockerRequest(user, "GET", address, "containers/json?all=1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1.24/containers/json", req.URL.Path)
+	assert.Equal(t, "all=1", req.URL.RawQuery)
+
+	// Explicit versions are kept if the daemon supports them
+	req, err = MakeDockerRequest(user, "GET", address, "v1.20/containers/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1.20/containers/json", req.URL.Path)
+
+	_, err = MakeDockerRequest(user, "GET", address, "v1.12/containers/json", nil)
+	assert.Equal(t, IncompatibleVersionError{Version: "v1.12", Min: "v1.18", Max: "v1.24"}, err)
+
+	_, err = MakeDockerRequest(user, "POST", address, "containers/prune", nil)
+	assert.Equal(t, IncompatibleVersionError{
+		Endpoint: "containers/prune",
+		Required: "v1.25",
+		Version:  "v1.24",
+		Min:      "v1.18",
+		Max:      "v1.24",
+	}, err)
+
+	_, err = MakeDockerRequest(user, "GET", address, "v1.20/networks", nil)
+	assert.Equal(t, "v1.21", err.(IncompatibleVersionError).Required)
+
+	// The daemon was only asked once
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+}
+
+func Test_NegotiateEndpoint_Beacon(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	user, _ := auth.GetUser(email)
+
+	var probes int32
+	server, address := setupVersionServer(`{"ApiVersion":"1.19"}`, &probes)
+	defer server.Close()
+
+	beacons.AddTestingBeacon(address, "TOKEN", "")
+	beacons.AddTestingInstance("INST:2375/v1.12", address, nil)
+	auth.SetUserBeaconAuthLevel(user, address, auth.AccessAuthLevel)
+
+	// The version the instance was registered with is replaced
+	req, err := MakeDockerRequest(user, "GET", "INST:2375/v1.12", "containers/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/d/INST:2375/v1.19/containers/json", req.URL.Path)
+
+	// Daemons which do not report a minimum support v1.12 and up
+	req, err = MakeDockerRequest(user, "GET", "INST:2375/v1.12", "v1.12/containers/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/d/INST:2375/v1.12/containers/json", req.URL.Path)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+}
+
+func Test_NegotiateEndpoint_Unknown(t *testing.T) {
+	email := setup()
+	defer teardown()
+	defer SetupServer(nil).Close()
+
+	user, _ := auth.GetUser(email)
+
+	req, err := MakeDockerRequest(user, "POST", "localhost:8080", "containers/prune", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/containers/prune", req.URL.Path)
+
+	entry, ok := getCachedVersions("localhost:8080")
+	assert.True(t, ok)
+	assert.False(t, entry.known)
+
+	// Hosts which may not be used are not probed
+	_, err = MakeDockerRequest(user, "GET", "UNKNOWN", "containers/json", nil)
+	assert.Equal(t, beacons.UnknownHostError, err)
+
+	_, ok = getCachedVersions("UNKNOWN")
+	assert.False(t, ok)
+}
+
+func Test_DockerRequestHandler_IncompatibleVersion(t *testing.T) {
+	email := setup()
+	defer teardown()
+
+	user, _ := auth.GetUser(email)
+
+	var probes int32
+	server, address := setupVersionServer(`{"ApiVersion":"1.18"}`, &probes)
+	defer server.Close()
+
+	beacons.AddTestingHost(address)
+	auth.SetUserHostAuthLevel(user, address, auth.AccessAuthLevel)
+
+	r, _ := http.NewRequest("GET", "/d/"+address+"/system/df", nil)
+	session.SetValue(r, "auth", "email", email)
+
+	info := handlers.HandlerInfo{DockerEndpoint: "system/df", Host: address, Request: r}
+
+	w := httptest.NewRecorder()
+	err := DockerRequestHandler(w, info)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+	assert.Equal(t, "docker: system/df requires API v1.25, not v1.18 (the instance supports v1.12 to v1.18)", err.Message)
+}
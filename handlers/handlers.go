@@ -15,12 +15,18 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -147,16 +153,35 @@ func WriteError(w http.ResponseWriter, code int, cause, message string) {
 }
 
 /*
-   Retrieves the body of the request as a *ReqestBody
+   Writes a 429 error along with a Retry-After header telling the
+   client how long to wait, rounded up to whole seconds.
+*/
+func WriteTooManyRequests(w http.ResponseWriter, cause, message string, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	WriteError(w, http.StatusTooManyRequests, cause, message)
+}
+
+/*
+   Retrieves the body of the request as a *ReqestBody. The request's
+   body is left in place so that it can still be read as it was sent.
+   Bodies which are not JSON, e.g. tar archives, are not read at all.
 
-   RETURN: nil if no body or on error. A *ReqestBody otherwise.
+   RETURN: nil if no body, a non-JSON body or on error. A *ReqestBody otherwise.
 */
 func GetRequestBody(r *http.Request) *RequestBody {
-	if r.Body == nil {
+	if r.Body == nil || !isJSONContent(r.Header.Get("Content-Type")) {
 		return nil
 	}
 
 	reqBody, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+
 	if err != nil {
 		return nil
 	}
@@ -167,6 +192,16 @@ func GetRequestBody(r *http.Request) *RequestBody {
 	return &body
 }
 
+// Requests without a Content-Type are assumed to be JSON
+func isJSONContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
 /*
    Retrieves the parameters of a generic endpoint scheme.  The endpoint to
    extract MUST be given as `mux.Vars(r)["Endpoint"]`. A map keyed by fields in the
@@ -200,3 +235,34 @@ func GetEndpointParams(r *http.Request, names []string) (map[string]string, bool
 
 	return params, true
 }
+
+/*
+   Calls work with every item number from 0 to total, running at most
+   parallel of them at once, or all of them if parallel is 0.
+*/
+func ForEach(total, parallel int, work func(item int)) {
+	if parallel <= 0 || parallel > total {
+		parallel = total
+	}
+
+	items := make(chan int)
+	workers := sync.WaitGroup{}
+
+	workers.Add(parallel)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			defer workers.Done()
+
+			for item := range items {
+				work(item)
+			}
+		}()
+	}
+
+	for i := 0; i < total; i++ {
+		items <- i
+	}
+
+	close(items)
+	workers.Wait()
+}
@@ -22,6 +22,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -127,6 +128,20 @@ func Test_WriteError(t *testing.T) {
 		"WriteError did not add message to the output")
 }
 
+func Test_WriteTooManyRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteTooManyRequests(w, "TestCause", "TestMessage", 1500*time.Millisecond)
+
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.True(t, strings.Contains(w.Body.String(), "TestMessage"))
+
+	// Clients are never told to retry immediately
+	w = httptest.NewRecorder()
+	WriteTooManyRequests(w, "TestCause", "TestMessage", time.Millisecond)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
 /*
    Validates custom handler calling.
    Purpose: Ensuring custom handlers are called
@@ -318,3 +333,21 @@ func Test_GetEndpointParams_TooMany(t *testing.T) {
 	w := httptest.NewRecorder()
 	m.ServeHTTP(w, r)
 }
+
+func Test_GetRequestBody_Preserved(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{"Payload":{"Key":"Value"}}`))
+
+	body := GetRequestBody(r)
+	assert.Equal(t, map[string]interface{}{"Key": "Value"}, body.Payload)
+
+	raw, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, `{"Payload":{"Key":"Value"}}`, string(raw))
+
+	r, _ = http.NewRequest("POST", "/", bytes.NewBufferString("TARBALL"))
+	r.Header.Set("Content-Type", "application/x-tar")
+
+	assert.Nil(t, GetRequestBody(r))
+
+	raw, _ = ioutil.ReadAll(r.Body)
+	assert.Equal(t, "TARBALL", string(raw))
+}
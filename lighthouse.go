@@ -20,6 +20,7 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/lighthouse/lighthouse/auth"
 	"github.com/lighthouse/lighthouse/beacons"
@@ -28,7 +29,10 @@ import (
 	"github.com/lighthouse/lighthouse/databases/postgres"
 	"github.com/lighthouse/lighthouse/handlers"
 	"github.com/lighthouse/lighthouse/handlers/applications"
+	"github.com/lighthouse/lighthouse/handlers/batch"
 	"github.com/lighthouse/lighthouse/handlers/docker"
+	"github.com/lighthouse/lighthouse/ratelimit"
+	"github.com/lighthouse/lighthouse/registries"
 	"github.com/lighthouse/lighthouse/session"
 
 	"github.com/lighthouse/lighthouse/logging"
@@ -42,6 +46,17 @@ const (
 
 var databasesReload = flag.Bool("databases-reload", false, "Start all databases from empty if true")
 var databasesDriver = flag.String("databases-driver", "postgres", "Type of database to connect to")
+var beaconsPollInterval = flag.Duration("beacons-poll-interval", 30*time.Second, "How often to check beacon health, 0 to disable")
+var beaconsRefreshInterval = flag.Duration("beacons-refresh-interval", 5*time.Minute, "How often to refresh beacon instances, 0 to disable")
+var beaconsStaleGrace = flag.Duration("beacons-stale-grace", time.Hour, "How long a missing instance is kept before it is removed")
+var beaconsRefreshWorkers = flag.Int("beacons-refresh-workers", 8, "How many beacons are refreshed at once")
+var beaconsCacheTTL = flag.Duration("beacons-cache-ttl", beacons.DefaultListingCacheTTL, "How long instance listings are cached, 0 to disable")
+var batchUserOperations = flag.Int("batch-user-operations", 4, "How many batch operations a user may run at once, 0 for no limit")
+var batchParallel = flag.Int("batch-parallel", batch.DefaultOptions.MaxParallel, "How many instances a batch operation sends requests to at once, 0 for all")
+var batchRequestTimeout = flag.Duration("batch-request-timeout", batch.DefaultOptions.RequestTimeout, "How long one request of a batch operation may take, 0 to disable")
+var batchDeadline = flag.Duration("batch-deadline", batch.DefaultOptions.Deadline, "How long one step of a batch operation may take, 0 to disable")
+var batchRetries = flag.Int("batch-retries", batch.DefaultOptions.Retries, "How many times transient batch request failures are retried")
+var batchRetryBackoff = flag.Duration("batch-retry-backoff", batch.DefaultOptions.Backoff, "How long to wait before the first retry, doubling for each further one")
 
 func ServeIndex(w http.ResponseWriter, r *http.Request) {
 	authData := struct {
@@ -87,11 +102,36 @@ func init() {
 	beacons.Init(*databasesReload)
 	aliases.Init(*databasesReload)
 	applications.Init(*databasesReload)
+	registries.Init(*databasesReload)
+
+	if err := docker.LoadPolicy(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid Docker policy: %s\n", err)
+		os.Exit(-1)
+	}
+
+	if err := ratelimit.LoadLimits(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid rate limits: %s\n", err)
+		os.Exit(-1)
+	}
 }
 
 func main() {
 	logging.Info("Starting...")
 
+	beacons.RefreshWorkers = *beaconsRefreshWorkers
+	beacons.ListingCacheTTL = *beaconsCacheTTL
+	batch.MaxUserOperations = *batchUserOperations
+
+	batch.DefaultOptions.MaxParallel = *batchParallel
+	docker.ContainerListWorkers = *batchParallel
+	batch.DefaultOptions.RequestTimeout = *batchRequestTimeout
+	batch.DefaultOptions.Deadline = *batchDeadline
+	batch.DefaultOptions.Retries = *batchRetries
+	batch.DefaultOptions.Backoff = *batchRetryBackoff
+
+	beacons.StartMonitor(*beaconsPollInterval)
+	beacons.StartRefresher(*beaconsRefreshInterval, *beaconsStaleGrace)
+
 	baseRouter := mux.NewRouter()
 
 	baseRouter.HandleFunc("/", ServeIndex).Methods("GET")
@@ -104,9 +144,13 @@ func main() {
 	versionRouter := baseRouter.PathPrefix(API_VERSION_0_2).Subrouter()
 
 	docker.Handle(versionRouter.PathPrefix("/d").Subrouter())
+	docker.HandleSessions(versionRouter.PathPrefix("/ws").Subrouter())
 	beacons.Handle(versionRouter.PathPrefix("/beacons").Subrouter())
 	aliases.Handle(versionRouter.PathPrefix("/aliases").Subrouter())
 	applications.Handle(versionRouter.PathPrefix("/applications").Subrouter())
+	registries.Handle(versionRouter.PathPrefix("/registries").Subrouter())
+	beacons.HandleInstances(versionRouter)
+	docker.HandleContainers(versionRouter)
 	auth.Handle(versionRouter)
 
 	ignoreURLs := []string{
@@ -114,9 +158,12 @@ func main() {
 		"/login",
 		fmt.Sprintf("%s/login", API_VERSION_0_2),
 		fmt.Sprintf("%s/logout", API_VERSION_0_2),
+		fmt.Sprintf("%s/beacons/register", API_VERSION_0_2),
 	}
 
-	app := auth.AuthMiddleware(baseRouter, ignoreURLs)
+	app := ratelimit.Middleware(baseRouter, API_VERSION_0_2)
+	app = auth.AuthMiddleware(app, ignoreURLs)
+	app = ratelimit.AuthenticationMiddleware(app, API_VERSION_0_2)
 	app = logging.Middleware(app)
 
 	http.Handle("/", app)
@@ -0,0 +1,361 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lighthouse/lighthouse/handlers"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+// How often buckets which have refilled are forgotten
+var SweepInterval = time.Minute
+
+/*
+   A group of routes sharing a limit. Requests whose path, below the
+   API version, is Prefix or lies under it, e.g. "/d" for every Docker
+   request, are counted against the group. Every user, service account
+   and, for requests made without logging in, address gets its own
+   bucket which holds up to Burst requests and refills with Rate
+   requests per second.
+
+   The first group a request matches is the one applied.
+*/
+type Group struct {
+	Name   string
+	Prefix string
+	Rate   float64
+	Burst  int
+
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+/*
+   Requests which fail to authenticate, e.g. with a wrong service key,
+   are also counted against Authentication by the client's address,
+   whichever route they are for. Its Prefix is not used.
+
+   Clients are told apart by the address they connect from. Behind a
+   reverse proxy, ClientAddressHeader names the header in which the
+   proxy gives the client's address, e.g. X-Forwarded-For. The last
+   address in it is used, since the proxy appends the one it saw to
+   whatever the client sent. It must only be set when every request
+   comes through the proxy.
+*/
+type Limits struct {
+	Groups              []*Group
+	Authentication      *Group
+	ClientAddressHeader string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	limits     Limits
+	limitsLock sync.RWMutex
+)
+
+/*
+   Replaces the current limits, checking all of their groups first.
+   Buckets start over full.
+
+   RETURN: nil on success, a description of the first invalid group otherwise
+*/
+func SetLimits(newLimits Limits) error {
+	for _, group := range newLimits.Groups {
+		if group.Name == "" {
+			group.Name = group.Prefix
+		}
+
+		if !strings.HasPrefix(group.Prefix, "/") {
+			return fmt.Errorf("rate limit group %q: prefix must start with '/'", group.Name)
+		}
+
+		if err := group.reset(); err != nil {
+			return err
+		}
+
+		group.Prefix = strings.TrimSuffix(group.Prefix, "/")
+	}
+
+	if group := newLimits.Authentication; group != nil {
+		if group.Name == "" {
+			group.Name = "authentication"
+		}
+
+		if err := group.reset(); err != nil {
+			return err
+		}
+	}
+
+	limitsLock.Lock()
+	limits = newLimits
+	limitsLock.Unlock()
+
+	return nil
+}
+
+// Checks the group's rate and burst and empties its buckets
+func (this *Group) reset() error {
+	if this.Rate <= 0 || this.Burst < 1 {
+		return fmt.Errorf("rate limit group %q: needs a positive rate and a burst of at least 1", this.Name)
+	}
+
+	this.buckets = make(map[string]*bucket)
+	return nil
+}
+
+/*
+   Loads the limits from rate_limits.json in the config directory.
+   Without a limits file no request is limited.
+*/
+func LoadLimits() error {
+	var fileName string
+	if _, err := os.Stat("./config/rate_limits.json.dev"); !os.IsNotExist(err) {
+		fileName = "./config/rate_limits.json.dev"
+	} else if _, err := os.Stat("./config/rate_limits.json"); !os.IsNotExist(err) {
+		fileName = "./config/rate_limits.json"
+	} else {
+		fileName = "/config/rate_limits.json"
+	}
+
+	configFile, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return SetLimits(Limits{})
+	} else if err != nil {
+		return err
+	}
+
+	var newLimits Limits
+	if err = json.Unmarshal(configFile, &newLimits); err != nil {
+		return fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	return SetLimits(newLimits)
+}
+
+func findGroup(path string) *Group {
+	limitsLock.RLock()
+	defer limitsLock.RUnlock()
+
+	for _, group := range limits.Groups {
+		if path == group.Prefix || strings.HasPrefix(path, group.Prefix+"/") {
+			return group
+		}
+	}
+
+	return nil
+}
+
+func authenticationGroup() *Group {
+	limitsLock.RLock()
+	defer limitsLock.RUnlock()
+
+	return limits.Authentication
+}
+
+/*
+   Takes a request from the key's bucket.
+
+   RETURN: 0 if the request may go ahead, how long until it would
+           be allowed otherwise
+*/
+func (this *Group) take(key string, now time.Time) time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	b := this.refill(key, now)
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / this.Rate * float64(time.Second))
+	}
+
+	b.tokens -= 1
+	return 0
+}
+
+// Like take, without taking the request from the bucket
+func (this *Group) wait(key string, now time.Time) time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	b := this.refill(key, now)
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / this.Rate * float64(time.Second))
+	}
+
+	return 0
+}
+
+// Finds the key's bucket, adding the requests it regained since it was last used
+func (this *Group) refill(key string, now time.Time) *bucket {
+	if now.Sub(this.swept) >= SweepInterval {
+		this.sweep(now)
+	}
+
+	b, ok := this.buckets[key]
+	if !ok {
+		b = &bucket{float64(this.Burst), now}
+		this.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * this.Rate
+	if b.tokens > float64(this.Burst) {
+		b.tokens = float64(this.Burst)
+	}
+	b.last = now
+
+	return b
+}
+
+// Forgets the buckets which would be full by now
+func (this *Group) sweep(now time.Time) {
+	for key, b := range this.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*this.Rate >= float64(this.Burst) {
+			delete(this.buckets, key)
+		}
+	}
+
+	this.swept = now
+}
+
+/*
+   Identifies who a request counts against: the logged in user or
+   service account, or the client's address if neither.
+*/
+func requestKey(r *http.Request) string {
+	if session.GetValueOrDefault(r, "auth", "logged_in", false).(bool) {
+		email := session.GetValueOrDefault(r, "auth", "email", "").(string)
+
+		if session.GetValueOrDefault(r, "auth", "service", false).(bool) {
+			return "service:" + email
+		}
+		return "user:" + email
+	}
+
+	return "addr:" + clientAddress(r)
+}
+
+func clientAddress(r *http.Request) string {
+	limitsLock.RLock()
+	header := limits.ClientAddressHeader
+	limitsLock.RUnlock()
+
+	if header != "" {
+		values := r.Header[http.CanonicalHeaderKey(header)]
+		if len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if address := strings.TrimSpace(addresses[len(addresses)-1]); address != "" {
+				return address
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return host
+}
+
+type contextKey int
+
+// Marks the requests which got past the auth middleware
+const authenticatedKey contextKey = 0
+
+/*
+   Limits the requests below apiPrefix, e.g. "/api/v0.2", which fail
+   to authenticate by the Authentication group. Must run before the
+   auth middleware, and Middleware after it: requests which never
+   reach Middleware were turned away by the auth middleware. While an
+   address is at its limit, requests from it which are not logged in
+   are turned away without being checked.
+*/
+func AuthenticationMiddleware(h http.Handler, apiPrefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := authenticationGroup()
+		if group == nil || !strings.HasPrefix(r.URL.Path, apiPrefix+"/") ||
+			session.GetValueOrDefault(r, "auth", "logged_in", false).(bool) {
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := "addr:" + clientAddress(r)
+
+		if wait := group.wait(key, time.Now()); wait > 0 {
+			handlers.WriteTooManyRequests(w, "ratelimit", "too many failed authentications", wait)
+			return
+		}
+
+		authenticated := new(bool)
+		r = r.WithContext(context.WithValue(r.Context(), authenticatedKey, authenticated))
+
+		h.ServeHTTP(w, r)
+
+		if !*authenticated {
+			group.take(key, time.Now())
+		}
+	})
+}
+
+/*
+   Limits the requests below apiPrefix, e.g. "/api/v0.2", by the
+   current limits. Must run after the auth middleware so that service
+   accounts have been identified.
+*/
+func Middleware(h http.Handler, apiPrefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticated, ok := r.Context().Value(authenticatedKey).(*bool); ok {
+			*authenticated = true
+		}
+
+		if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		group := findGroup(strings.TrimPrefix(r.URL.Path, apiPrefix))
+		if group == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if wait := group.take(requestKey(r), time.Now()); wait > 0 {
+			message := fmt.Sprintf("too many requests to %s", group.Name)
+			handlers.WriteTooManyRequests(w, "ratelimit", message, wait)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
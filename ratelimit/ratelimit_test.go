@@ -0,0 +1,199 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func newGroup(rate float64, burst int) *Group {
+	group := &Group{Prefix: "/test", Rate: rate, Burst: burst}
+	SetLimits(Limits{Groups: []*Group{group}})
+	return group
+}
+
+func Test_SetLimits(t *testing.T) {
+	defer SetLimits(Limits{})
+
+	assert.NotNil(t, SetLimits(Limits{Groups: []*Group{{Prefix: "d", Rate: 1, Burst: 1}}}))
+	assert.NotNil(t, SetLimits(Limits{Groups: []*Group{{Prefix: "/d", Rate: 0, Burst: 1}}}))
+	assert.NotNil(t, SetLimits(Limits{Groups: []*Group{{Prefix: "/d", Rate: 1, Burst: 0}}}))
+
+	docker := &Group{Prefix: "/d/", Rate: 1, Burst: 1}
+	apps := &Group{Name: "applications", Prefix: "/applications", Rate: 1, Burst: 1}
+	assert.Nil(t, SetLimits(Limits{Groups: []*Group{docker, apps}}))
+
+	assert.Equal(t, "/d/", docker.Name)
+	assert.Equal(t, docker, findGroup("/d"))
+	assert.Equal(t, docker, findGroup("/d/localhost/containers/json"))
+	assert.Equal(t, apps, findGroup("/applications/start/1"))
+	assert.Nil(t, findGroup("/docker"))
+	assert.Nil(t, findGroup("/beacons/list"))
+}
+
+func Test_Take(t *testing.T) {
+	defer SetLimits(Limits{})
+
+	group := newGroup(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), group.take("USER", now))
+	}
+
+	assert.Equal(t, 500*time.Millisecond, group.take("USER", now))
+	assert.Equal(t, time.Duration(0), group.take("OTHER", now))
+
+	// Buckets refill at the group's rate, up to the burst
+	assert.Equal(t, time.Duration(0), group.take("USER", now.Add(500*time.Millisecond)))
+	assert.Equal(t, 500*time.Millisecond, group.take("USER", now.Add(500*time.Millisecond)))
+
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), group.take("USER", later))
+	}
+	assert.NotEqual(t, time.Duration(0), group.take("USER", later))
+}
+
+func Test_Sweep(t *testing.T) {
+	defer SetLimits(Limits{})
+
+	group := newGroup(1, 2)
+	now := time.Now()
+
+	group.take("USER", now)
+	group.take("OTHER", now)
+	group.take("OTHER", now)
+
+	group.sweep(now.Add(500 * time.Millisecond))
+	assert.Equal(t, 2, len(group.buckets))
+
+	// Only the buckets which have refilled are forgotten
+	group.sweep(now.Add(time.Second))
+	assert.Equal(t, 1, len(group.buckets))
+	assert.NotNil(t, group.buckets["OTHER"])
+}
+
+func Test_Middleware(t *testing.T) {
+	defer SetLimits(Limits{})
+	newGroup(0.5, 1)
+
+	handled := 0
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled += 1
+	}), "/api")
+
+	serve := func(path, email string, service bool) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+
+		if email != "" {
+			session.SetValue(r, "auth", "logged_in", true)
+			session.SetValue(r, "auth", "email", email)
+			session.SetValue(r, "auth", "service", service)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, 200, serve("/api/test", "USER", false).Code)
+
+	w := serve("/api/test/more", "USER", false)
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// Users, service accounts of the same name and addresses are
+	// limited separately
+	assert.Equal(t, 200, serve("/api/test", "USER", true).Code)
+	assert.Equal(t, 429, serve("/api/test", "USER", true).Code)
+	assert.Equal(t, 200, serve("/api/test", "", false).Code)
+	assert.Equal(t, 429, serve("/api/test", "", false).Code)
+
+	// Other routes are not limited
+	assert.Equal(t, 200, serve("/api/other", "USER", false).Code)
+	assert.Equal(t, 200, serve("/test", "USER", false).Code)
+
+	assert.Equal(t, 5, handled)
+}
+
+func Test_AuthenticationMiddleware(t *testing.T) {
+	defer SetLimits(Limits{})
+	SetLimits(Limits{Authentication: &Group{Rate: 0.5, Burst: 2}})
+
+	// Stands in for the auth middleware
+	limited := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/api")
+	h := AuthenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loggedIn := session.GetValueOrDefault(r, "auth", "logged_in", false).(bool)
+		if !loggedIn && r.Header.Get("Service-Key") != "KEY" && r.URL.Path != "/api/login" {
+			w.WriteHeader(401)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	}), "/api")
+
+	serve := func(path, key string, loggedIn bool) int {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("Service-Key", key)
+		session.SetValue(r, "auth", "logged_in", loggedIn)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Only failures are counted
+	assert.Equal(t, 200, serve("/api/test", "KEY", false))
+	assert.Equal(t, 200, serve("/api/login", "", false))
+	assert.Equal(t, 401, serve("/api/test", "GUESS", false))
+	assert.Equal(t, 401, serve("/api/other", "GUESS", false))
+
+	// Whatever the route or key, the address is then turned away
+	assert.Equal(t, 429, serve("/api/other", "GUESS", false))
+	assert.Equal(t, 429, serve("/api/test", "KEY", false))
+
+	// Except for logged in users and routes outside the API
+	assert.Equal(t, 200, serve("/api/test", "", true))
+	assert.Equal(t, 401, serve("/test", "GUESS", false))
+
+	assert.NotNil(t, SetLimits(Limits{Authentication: &Group{Rate: 0, Burst: 1}}))
+}
+
+func Test_ClientAddress(t *testing.T) {
+	defer SetLimits(Limits{})
+
+	r, _ := http.NewRequest("GET", "/api/login", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Add("X-Forwarded-For", "1.2.3.4, 192.168.0.1")
+	r.Header.Add("X-Forwarded-For", "192.168.0.2")
+
+	assert.Equal(t, "addr:10.0.0.1", requestKey(r))
+
+	// Only the address added by the proxy can be trusted
+	SetLimits(Limits{ClientAddressHeader: "x-forwarded-for"})
+	assert.Equal(t, "addr:192.168.0.2", requestKey(r))
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "addr:10.0.0.1", requestKey(r))
+}
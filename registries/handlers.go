@@ -0,0 +1,112 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registries
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"encoding/json"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/handlers"
+)
+
+func Handle(r *mux.Router) {
+	r.HandleFunc("", handleListCredentials).Methods("GET")
+
+	r.HandleFunc("/{Name}", handleUpdateCredential).Methods("PUT")
+
+	r.HandleFunc("/{Name}", handleRemoveCredential).Methods("DELETE")
+}
+
+func writeResponse(w http.ResponseWriter, err error) {
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+
+	case InvalidCredentialError, RegistryMismatchError, PasswordRequiredError:
+		handlers.WriteError(w, http.StatusBadRequest, "registries", err.Error())
+
+	case CredentialPermissionError:
+		handlers.WriteError(w, http.StatusForbidden, "registries", err.Error())
+
+	case UnknownCredentialError:
+		handlers.WriteError(w, http.StatusNotFound, "registries", err.Error())
+
+	case CredentialInUseError:
+		handlers.WriteError(w, http.StatusConflict, "registries", err.Error())
+
+	default:
+		handlers.WriteError(w, http.StatusInternalServerError, "registries", err.Error())
+	}
+}
+
+// Lists the credentials the user can use, without their passwords
+func handleListCredentials(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+
+	all, err := getCredentials()
+	if err != nil {
+		writeResponse(w, err)
+		return
+	}
+
+	list := make([]Credential, 0)
+	for _, credential := range all {
+		if user.CanAccessRegistry(credential.Name) {
+			list = append(list, credential)
+		}
+	}
+
+	output, _ := json.Marshal(list)
+	fmt.Fprint(w, string(output))
+}
+
+/*
+   Creates or updates the named credential from a body of the form
+   {"Registry": "registry.example.com", "Username": "...", "Password": "..."}.
+*/
+func handleUpdateCredential(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, err)
+		return
+	}
+
+	var request struct {
+		Registry string
+		Username string
+		Password string
+	}
+
+	if json.Unmarshal(body, &request) != nil {
+		writeResponse(w, InvalidCredentialError)
+		return
+	}
+
+	user := auth.GetCurrentUser(r)
+	name := mux.Vars(r)["Name"]
+
+	writeResponse(w, updateCredential(user, name, request.Registry, request.Username, request.Password))
+}
+
+func handleRemoveCredential(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetCurrentUser(r)
+	writeResponse(w, removeCredential(user, mux.Vars(r)["Name"]))
+}
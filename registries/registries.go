@@ -0,0 +1,398 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registries
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+)
+
+var (
+	UnknownCredentialError    = errors.New("registries: unknown registry credential")
+	CredentialPermissionError = errors.New("registries: user not permitted to use registry credential")
+	InvalidCredentialError    = errors.New("registries: credentials need a name, a registry host and a username")
+	RegistryMismatchError     = errors.New("registries: credential belongs to a different registry than the image")
+	DecryptionError           = errors.New("registries: stored password could not be decrypted")
+	PasswordRequiredError     = errors.New("registries: changing the registry or username of a credential needs its password")
+	CredentialInUseError      = errors.New("registries: credential is still used by applications")
+)
+
+// The registry which images without a registry host are pulled from
+const DEFAULT_REGISTRY = "docker.io"
+
+// How Docker names the default registry in X-Registry-Auth
+const DEFAULT_REGISTRY_SERVER = "https://index.docker.io/v1/"
+
+/*
+   Credentials are named, so that applications can refer to one, and
+   permissioned by that name like beacons are by their address. A
+   registry may have any number of them.
+*/
+var credentials databases.TableInterface
+
+var schema = databases.Schema{
+	"Name":     "text UNIQUE PRIMARY KEY",
+	"Registry": "text",
+	"Username": "text",
+	"Password": "text",
+	"Creator":  "text",
+}
+
+/*
+   A stored credential. Password holds the encrypted password and is
+   never sent to clients.
+*/
+type Credential struct {
+	Name     string
+	Registry string
+	Username string
+	Password string `json:"-"`
+	Creator  string
+}
+
+/*
+   Finds the names of anything, e.g. applications, which pulls with the
+   named credential. Credentials in use are not removed.
+*/
+type CredentialReferenceFunc func(name string) []string
+
+var credentialReferenceFuncs = []CredentialReferenceFunc{}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+var validRegistry = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?(:[0-9]+)?$`)
+
+// Other names Docker uses for its default registry
+var defaultRegistryAliases = map[string]bool{
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"registry.hub.docker.com": true,
+}
+
+func Init(reload bool) {
+	if credentials == nil {
+		credentials = databases.NewTable(nil, "registry_credentials", schema)
+	}
+
+	if reload {
+		credentials.Reload()
+	}
+}
+
+func AddCredentialReferenceFunc(f CredentialReferenceFunc) {
+	credentialReferenceFuncs = append(credentialReferenceFuncs, f)
+}
+
+/*
+   Reduces the ways a registry may be written, e.g.
+   "https://Registry.example.com:5000/v2/", to its host.
+*/
+func normalizeRegistry(registry string) (string, bool) {
+	registry = strings.ToLower(strings.TrimSpace(registry))
+
+	if i := strings.Index(registry, "://"); i >= 0 {
+		registry = registry[i+3:]
+	}
+
+	if i := strings.Index(registry, "/"); i >= 0 {
+		registry = registry[:i]
+	}
+
+	if defaultRegistryAliases[registry] {
+		registry = DEFAULT_REGISTRY
+	}
+
+	return registry, validRegistry.MatchString(registry)
+}
+
+/*
+   Finds the registry an image is pulled from the way Docker does: the
+   first component of the name is a registry host only if it contains
+   a '.' or ':', or is "localhost".
+*/
+func RegistryOf(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return DEFAULT_REGISTRY
+	}
+
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return DEFAULT_REGISTRY
+	}
+
+	registry, _ := normalizeRegistry(host)
+	return registry
+}
+
+/*
+   Passwords are encrypted with AES-GCM under a key derived from the
+   SecretKey of the auth config, and bound to the credential's name and
+   registry so they cannot be moved to another credential or sent to
+   another registry.
+*/
+func newCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("registries:" + auth.SECRET_HASH_KEY))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func additionalData(name, registry string) []byte {
+	return []byte(name + "\x00" + registry)
+}
+
+func encryptPassword(name, registry, password string) (string, error) {
+	gcm, err := newCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(password), additionalData(name, registry))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptPassword(name, registry, encrypted string) (string, error) {
+	gcm, err := newCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", DecryptionError
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	password, err := gcm.Open(nil, nonce, sealed, additionalData(name, registry))
+	if err != nil {
+		return "", DecryptionError
+	}
+
+	return string(password), nil
+}
+
+func getCredential(name string) (Credential, error) {
+	var credential Credential
+	where := databases.Filter{"Name": name}
+
+	err := credentials.SelectRow(nil, where, nil, &credential)
+	if err == databases.NoRowsError {
+		err = UnknownCredentialError
+	}
+
+	return credential, err
+}
+
+func getCredentials() ([]Credential, error) {
+	opts := databases.SelectOptions{OrderBy: []string{"Name"}}
+
+	scanner, err := credentials.Select(nil, nil, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer scanner.Close()
+
+	list := make([]Credential, 0)
+
+	for scanner.Next() {
+		var credential Credential
+		scanner.Scan(&credential)
+		list = append(list, credential)
+	}
+
+	return list, nil
+}
+
+/*
+   Creates the credential, making the user its owner, or updates it if
+   the user may modify it. An empty password keeps the stored one, but
+   only while the registry and username stay the same, so that it is
+   never sent anywhere its owner did not choose.
+*/
+func updateCredential(user *auth.User, name, registry, username, password string) error {
+	registry, ok := normalizeRegistry(registry)
+	if !ok || !validName.MatchString(name) || username == "" {
+		return InvalidCredentialError
+	}
+
+	existing, err := getCredential(name)
+	if err != nil && err != UnknownCredentialError {
+		return err
+	}
+
+	exists := err == nil
+
+	if exists && !user.CanModifyRegistry(name) {
+		return CredentialPermissionError
+	}
+
+	if !exists && password == "" {
+		return InvalidCredentialError
+	}
+
+	if exists && password == "" && (existing.Registry != registry || existing.Username != username) {
+		return PasswordRequiredError
+	}
+
+	values := map[string]interface{}{
+		"Name":     name,
+		"Registry": registry,
+		"Username": username,
+		"Password": existing.Password,
+	}
+
+	if password != "" {
+		if values["Password"], err = encryptPassword(name, registry, password); err != nil {
+			return err
+		}
+	}
+
+	if exists {
+		return credentials.Update(values, databases.Filter{"Name": name})
+	}
+
+	values["Creator"] = user.Email
+
+	if err = credentials.Insert(values); err != nil {
+		return err
+	}
+
+	return auth.SetUserRegistryAuthLevel(user, name, auth.OwnerAuthLevel)
+}
+
+func removeCredential(user *auth.User, name string) error {
+	if _, err := getCredential(name); err != nil {
+		return err
+	}
+
+	if user.GetAuthLevel("Registries", name) < auth.OwnerAuthLevel {
+		return CredentialPermissionError
+	}
+
+	for _, f := range credentialReferenceFuncs {
+		if len(f(name)) > 0 {
+			return CredentialInUseError
+		}
+	}
+
+	if err := credentials.Delete(databases.Filter{"Name": name}); err != nil {
+		return err
+	}
+
+	return auth.RemovePermissionFromAll("Registries", name)
+}
+
+/*
+   Checks that the user may have something, e.g. an application, use
+   the named credential on their behalf.
+*/
+func CheckCredential(user *auth.User, name string) error {
+	if _, err := getCredential(name); err != nil {
+		return err
+	}
+
+	if !user.CanAccessRegistry(name) {
+		return CredentialPermissionError
+	}
+
+	return nil
+}
+
+func encodeAuth(credential Credential) (string, error) {
+	password, err := decryptPassword(credential.Name, credential.Registry, credential.Password)
+	if err != nil {
+		return "", err
+	}
+
+	server := credential.Registry
+	if server == DEFAULT_REGISTRY {
+		server = DEFAULT_REGISTRY_SERVER
+	}
+
+	config, _ := json.Marshal(map[string]string{
+		"username":      credential.Username,
+		"password":      password,
+		"serveraddress": server,
+	})
+
+	return base64.URLEncoding.EncodeToString(config), nil
+}
+
+/*
+   Builds the X-Registry-Auth header for the user to pull the image.
+   The named credential is used if one is given, it must belong to the
+   image's registry and the user must still be able to access it, since
+   whoever deploys decides where the header is sent. Otherwise the first
+   credential of that registry the user can access is used, if any.
+
+   RETURN: The header's value, empty if the pull needs no credential
+*/
+func AuthHeaderFor(user *auth.User, image, name string) (string, error) {
+	registry := RegistryOf(image)
+
+	if name != "" {
+		credential, err := getCredential(name)
+		if err != nil {
+			return "", err
+		}
+
+		if !user.CanAccessRegistry(credential.Name) {
+			return "", CredentialPermissionError
+		}
+
+		if credential.Registry != registry {
+			return "", RegistryMismatchError
+		}
+
+		return encodeAuth(credential)
+	}
+
+	list, err := getCredentials()
+	if err != nil {
+		return "", err
+	}
+
+	for _, credential := range list {
+		if credential.Registry == registry && user.CanAccessRegistry(credential.Name) {
+			return encodeAuth(credential)
+		}
+	}
+
+	return "", nil
+}
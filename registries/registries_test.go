@@ -0,0 +1,293 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registries
+
+import (
+	"testing"
+
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/session"
+)
+
+func setup() {
+	SetupTestingTable()
+	auth.SetupTestingTable()
+}
+
+func teardown() {
+	TeardownTestingTable()
+	auth.TeardownTestingTable()
+}
+
+func createUser(email string) *auth.User {
+	auth.CreateUser(email, "", "")
+	user, _ := auth.GetUser(email)
+	return user
+}
+
+func runHandlerTest(email, method, endpoint string, body interface{}) *httptest.ResponseRecorder {
+	jsonBuff, _ := json.Marshal(body)
+
+	r, _ := http.NewRequest(method, endpoint, bytes.NewBuffer(jsonBuff))
+	session.SetValue(r, "auth", "email", email)
+
+	m := mux.NewRouter()
+	Handle(m.PathPrefix("/registries").Subrouter())
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	return w
+}
+
+// RETURN: The decoded X-Registry-Auth header
+func decodeAuth(header string) map[string]string {
+	data, _ := base64.URLEncoding.DecodeString(header)
+
+	var config map[string]string
+	json.Unmarshal(data, &config)
+	return config
+}
+
+func Test_RegistryOf(t *testing.T) {
+	tests := map[string]string{
+		"ubuntu":                                 "docker.io",
+		"ubuntu:14.04":                           "docker.io",
+		"library/ubuntu":                         "docker.io",
+		"docker.io/library/ubuntu":               "docker.io",
+		"index.docker.io/user/app":               "docker.io",
+		"registry.example.com/team/app:1.0":      "registry.example.com",
+		"Registry.Example.com:5000/app@sha256:0": "registry.example.com:5000",
+		"localhost/app":                          "localhost",
+		"localhost:5000/app":                     "localhost:5000",
+	}
+
+	for image, registry := range tests {
+		assert.Equal(t, registry, RegistryOf(image), image)
+	}
+}
+
+func Test_NormalizeRegistry(t *testing.T) {
+	tests := map[string]string{
+		"registry.example.com":               "registry.example.com",
+		"https://Registry.example.com:5000/": "registry.example.com:5000",
+		"https://index.docker.io/v1/":        "docker.io",
+		" quay.io ":                          "quay.io",
+	}
+
+	for input, registry := range tests {
+		normalized, ok := normalizeRegistry(input)
+		assert.True(t, ok, input)
+		assert.Equal(t, registry, normalized, input)
+	}
+
+	for _, invalid := range []string{"", "https://", "bad host", "-host", "host:port"} {
+		_, ok := normalizeRegistry(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func Test_EncryptPassword(t *testing.T) {
+	encrypted, err := encryptPassword("NAME", "quay.io", "hunter2")
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(encrypted, "hunter2"))
+
+	again, _ := encryptPassword("NAME", "quay.io", "hunter2")
+	assert.NotEqual(t, encrypted, again)
+
+	password, err := decryptPassword("NAME", "quay.io", encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", password)
+
+	// Passwords are bound to their credential and its registry
+	_, err = decryptPassword("OTHER", "quay.io", encrypted)
+	assert.Equal(t, DecryptionError, err)
+
+	_, err = decryptPassword("NAME", "evil.example.com", encrypted)
+	assert.Equal(t, DecryptionError, err)
+
+	_, err = decryptPassword("NAME", "quay.io", "garbage")
+	assert.Equal(t, DecryptionError, err)
+
+	// And to the secret key
+	defer func(key string) { auth.SECRET_HASH_KEY = key }(auth.SECRET_HASH_KEY)
+	auth.SECRET_HASH_KEY = "another key"
+
+	_, err = decryptPassword("NAME", "quay.io", encrypted)
+	assert.Equal(t, DecryptionError, err)
+}
+
+func Test_UpdateCredential(t *testing.T) {
+	setup()
+	defer teardown()
+
+	owner := createUser("OWNER")
+	other := createUser("OTHER")
+
+	assert.Equal(t, InvalidCredentialError, updateCredential(owner, "bad/name", "quay.io", "user", "pass"))
+	assert.Equal(t, InvalidCredentialError, updateCredential(owner, "CRED", "", "user", "pass"))
+	assert.Equal(t, InvalidCredentialError, updateCredential(owner, "CRED", "quay.io", "", "pass"))
+	assert.Equal(t, InvalidCredentialError, updateCredential(owner, "CRED", "quay.io", "user", ""))
+
+	assert.Nil(t, updateCredential(owner, "CRED", "https://quay.io/v2/", "user", "pass"))
+
+	owner, _ = auth.GetUser("OWNER")
+	assert.Equal(t, auth.OwnerAuthLevel, owner.GetAuthLevel("Registries", "CRED"))
+
+	credential, _ := getCredential("CRED")
+	assert.Equal(t, "quay.io", credential.Registry)
+	assert.Equal(t, "OWNER", credential.Creator)
+	assert.NotEqual(t, "pass", credential.Password)
+
+	assert.Equal(t, CredentialPermissionError, updateCredential(other, "CRED", "quay.io", "mine", "pass"))
+
+	// Updates without a password keep the stored one, but it may not
+	// be sent anywhere else
+	auth.SetUserRegistryAuthLevel(other, "CRED", auth.ModifyAuthLevel)
+	assert.Equal(t, PasswordRequiredError, updateCredential(other, "CRED", "evil.example.com", "user", ""))
+	assert.Equal(t, PasswordRequiredError, updateCredential(other, "CRED", "quay.io", "robot", ""))
+	assert.Nil(t, updateCredential(other, "CRED", "https://quay.io/", "user", ""))
+
+	header, _ := AuthHeaderFor(other, "quay.io/app", "CRED")
+	assert.Equal(t, map[string]string{
+		"username":      "user",
+		"password":      "pass",
+		"serveraddress": "quay.io",
+	}, decodeAuth(header))
+
+	assert.Nil(t, updateCredential(other, "CRED", "quay.io", "robot", "new"))
+
+	header, _ = AuthHeaderFor(other, "quay.io/app", "CRED")
+	assert.Equal(t, "robot", decodeAuth(header)["username"])
+	assert.Equal(t, "new", decodeAuth(header)["password"])
+
+	// Only owners may remove credentials
+	assert.Equal(t, CredentialPermissionError, removeCredential(other, "CRED"))
+	assert.Nil(t, removeCredential(owner, "CRED"))
+	assert.Equal(t, UnknownCredentialError, removeCredential(owner, "CRED"))
+
+	other, _ = auth.GetUser("OTHER")
+	assert.Equal(t, -1, other.GetAuthLevel("Registries", "CRED"))
+}
+
+func Test_AuthHeaderFor(t *testing.T) {
+	setup()
+	defer teardown()
+
+	owner := createUser("OWNER")
+	user := createUser("USER")
+
+	AddTestingCredential(owner, "A", "registry.example.com", "a", "pass-a")
+	AddTestingCredential(owner, "B", "registry.example.com", "b", "pass-b")
+	AddTestingCredential(owner, "HUB", "docker.io", "hub", "pass-hub")
+
+	// Credentials the user cannot access are not picked
+	header, err := AuthHeaderFor(user, "registry.example.com/app", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", header)
+
+	auth.SetUserRegistryAuthLevel(user, "B", auth.AccessAuthLevel)
+
+	header, _ = AuthHeaderFor(user, "registry.example.com/app:1.0", "")
+	assert.Equal(t, "b", decodeAuth(header)["username"])
+
+	// Named credentials are checked on every pull
+	_, err = AuthHeaderFor(user, "registry.example.com/app", "A")
+	assert.Equal(t, CredentialPermissionError, err)
+
+	auth.SetUserRegistryAuthLevel(user, "A", auth.AccessAuthLevel)
+
+	header, _ = AuthHeaderFor(user, "registry.example.com/app", "A")
+	assert.Equal(t, "pass-a", decodeAuth(header)["password"])
+
+	header, _ = AuthHeaderFor(owner, "ubuntu", "")
+	assert.Equal(t, DEFAULT_REGISTRY_SERVER, decodeAuth(header)["serveraddress"])
+
+	_, err = AuthHeaderFor(user, "ubuntu", "A")
+	assert.Equal(t, RegistryMismatchError, err)
+
+	_, err = AuthHeaderFor(user, "ubuntu", "MISSING")
+	assert.Equal(t, UnknownCredentialError, err)
+
+	assert.Equal(t, CredentialPermissionError, CheckCredential(user, "HUB"))
+	assert.Equal(t, UnknownCredentialError, CheckCredential(user, "MISSING"))
+	assert.Nil(t, CheckCredential(user, "B"))
+}
+
+func Test_Handle(t *testing.T) {
+	setup()
+	defer teardown()
+
+	createUser("OWNER")
+	createUser("USER")
+
+	body := map[string]string{"Registry": "quay.io", "Username": "user", "Password": "secret"}
+
+	w := runHandlerTest("OWNER", "PUT", "/registries/CRED", body)
+	assert.Equal(t, 200, w.Code)
+
+	w = runHandlerTest("OWNER", "PUT", "/registries/BAD", map[string]string{"Registry": "quay.io"})
+	assert.Equal(t, 400, w.Code)
+
+	w = runHandlerTest("USER", "PUT", "/registries/CRED", body)
+	assert.Equal(t, 403, w.Code)
+
+	w = runHandlerTest("OWNER", "GET", "/registries", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.False(t, strings.Contains(w.Body.String(), "Password"))
+
+	var list []Credential
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, []Credential{{Name: "CRED", Registry: "quay.io", Username: "user", Creator: "OWNER"}}, list)
+
+	w = runHandlerTest("USER", "GET", "/registries", nil)
+	assert.Equal(t, "[]", w.Body.String())
+
+	w = runHandlerTest("USER", "DELETE", "/registries/CRED", nil)
+	assert.Equal(t, 403, w.Code)
+
+	// Credentials which are still pulled with are kept
+	defer func(funcs []CredentialReferenceFunc) { credentialReferenceFuncs = funcs }(credentialReferenceFuncs)
+
+	users := []string{"APP"}
+	AddCredentialReferenceFunc(func(name string) []string {
+		if name == "CRED" {
+			return users
+		}
+		return nil
+	})
+
+	w = runHandlerTest("OWNER", "DELETE", "/registries/CRED", nil)
+	assert.Equal(t, 409, w.Code)
+
+	users = nil
+
+	w = runHandlerTest("OWNER", "DELETE", "/registries/CRED", nil)
+	assert.Equal(t, 200, w.Code)
+
+	w = runHandlerTest("OWNER", "DELETE", "/registries/CRED", nil)
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registries
+
+import (
+	"github.com/lighthouse/lighthouse/auth"
+	"github.com/lighthouse/lighthouse/databases"
+)
+
+func SetupTestingTable() {
+	credentials = databases.CommonTestingTable(schema)
+}
+
+func TeardownTestingTable() {
+	credentials = nil
+}
+
+// Stores a credential owned by the user. The auth tables must be set up.
+func AddTestingCredential(user *auth.User, name, registry, username, password string) error {
+	return updateCredential(user, name, registry, username, password)
+}
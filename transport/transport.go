@@ -0,0 +1,191 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	InvalidCACertError     = errors.New("transport: CA bundle contains no valid PEM certificates")
+	InvalidClientCertError = errors.New("transport: client certificate and key must both be valid PEM")
+)
+
+/*
+   How to reach a beacon or Docker host. Without UseTLS plain http is
+   used and the rest is ignored. CACert (a PEM bundle) replaces the
+   system roots when given, and ClientCert/ClientKey (PEM) are
+   presented to hosts which verify clients, such as Docker daemons
+   run with --tlsverify. ServerName overrides the name checked
+   against the host's certificate.
+*/
+type TLSSettings struct {
+	UseTLS     bool
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+}
+
+/*
+   Opens the connections of guarded clients, e.g. refusing addresses
+   which are not allowed. Given the address as requested, before it is
+   resolved.
+*/
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+/*
+   A DialFunc which guarded clients can share. Functions cannot be
+   compared, so clients are shared by the Dialer they were made with.
+*/
+type Dialer struct {
+	Dial DialFunc
+}
+
+func NewDialer(dial DialFunc) *Dialer {
+	return &Dialer{dial}
+}
+
+type clientKey struct {
+	settings TLSSettings
+	timeout  time.Duration
+	dialer   *Dialer
+}
+
+var (
+	clients     = make(map[clientKey]*http.Client)
+	clientsLock = sync.Mutex{}
+)
+
+func (this TLSSettings) Scheme() string {
+	if this.UseTLS {
+		return "https"
+	}
+	return "http"
+}
+
+/*
+   Checks that any certificates given can actually be loaded.
+*/
+func (this TLSSettings) Validate() error {
+	_, err := this.tlsConfig()
+	return err
+}
+
+func (this TLSSettings) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: this.ServerName}
+
+	if this.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(this.CACert)) {
+			return nil, InvalidCACertError
+		}
+		config.RootCAs = pool
+	}
+
+	if this.ClientCert != "" || this.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(this.ClientCert), []byte(this.ClientKey))
+		if err != nil {
+			return nil, InvalidClientCertError
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+/*
+   Gets an HTTP client for the given settings. Clients are shared
+   between callers with identical settings so that connections to
+   the same host are reused. A timeout of 0 means no timeout.
+
+   RETURN: The client, or an error if the settings are invalid
+*/
+func NewClient(settings TLSSettings, timeout time.Duration) (*http.Client, error) {
+	return getClient(clientKey{settings, timeout, nil})
+}
+
+/*
+   Like NewClient, but all connections are opened by the dialer.
+   Guarded clients are shared by callers giving the same Dialer.
+*/
+func NewGuardedClient(settings TLSSettings, timeout time.Duration, dialer *Dialer) (*http.Client, error) {
+	return getClient(clientKey{settings, timeout, dialer})
+}
+
+func getClient(key clientKey) (*http.Client, error) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	if client, ok := clients[key]; ok {
+		return client, nil
+	}
+
+	// Plain unguarded http shares the default transport and its connections
+	var roundTripper http.RoundTripper = http.DefaultTransport
+
+	if key.settings.UseTLS || key.dialer != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+
+		if key.settings.UseTLS {
+			config, err := key.settings.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = config
+		}
+
+		if key.dialer != nil {
+			transport.DialContext = key.dialer.Dial
+			transport.Proxy = nil
+		}
+
+		roundTripper = transport
+	}
+
+	client := &http.Client{
+		Transport: roundTripper,
+		Timeout:   key.timeout,
+
+		// Beacons and Docker hosts never redirect, and following one
+		// would reach wherever the host asks without any guard
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	clients[key] = client
+
+	return client, nil
+}
+
+/*
+   Closes the idle connections of every client, which would otherwise
+   be reused after a host restarts.
+*/
+func CloseIdleConnections() {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	for _, client := range clients {
+		client.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Caleb Brose, Chris Fogerty, Rob Sheehy, Zach Taylor, Nick Miller
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"testing"
+
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func certificatePEM(s *httptest.Server) string {
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}
+	return string(pem.EncodeToMemory(block))
+}
+
+func Test_Scheme(t *testing.T) {
+	assert.Equal(t, "http", TLSSettings{}.Scheme())
+	assert.Equal(t, "https", TLSSettings{UseTLS: true}.Scheme())
+}
+
+func Test_Validate(t *testing.T) {
+	assert.Nil(t, TLSSettings{}.Validate())
+	assert.Nil(t, TLSSettings{UseTLS: true, ServerName: "NAME"}.Validate())
+
+	bad := TLSSettings{UseTLS: true, CACert: "NOT PEM"}
+	assert.Equal(t, InvalidCACertError, bad.Validate())
+
+	bad = TLSSettings{UseTLS: true, ClientCert: "NOT PEM"}
+	assert.Equal(t, InvalidClientCertError, bad.Validate())
+}
+
+// Forgets the shared clients so that each test makes its own
+func resetClients() {
+	CloseIdleConnections()
+
+	clientsLock.Lock()
+	clients = make(map[clientKey]*http.Client)
+	clientsLock.Unlock()
+}
+
+func Test_NewClient_Shared(t *testing.T) {
+	settings := TLSSettings{UseTLS: true, ServerName: "NAME"}
+
+	first, err := NewClient(settings, time.Second)
+	assert.Nil(t, err)
+
+	second, _ := NewClient(settings, time.Second)
+	assert.True(t, first == second, "identical settings should share a client")
+
+	other, _ := NewClient(settings, 0)
+	assert.False(t, first == other)
+
+	plain, _ := NewClient(TLSSettings{}, 0)
+	assert.Equal(t, http.DefaultTransport, plain.Transport)
+}
+
+func Test_NewClient_Invalid(t *testing.T) {
+	client, err := NewClient(TLSSettings{UseTLS: true, CACert: "NOT PEM"}, 0)
+
+	assert.Nil(t, client)
+	assert.Equal(t, InvalidCACertError, err)
+}
+
+func Test_NewClient_CACert(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	// Unknown authority
+	client, _ := NewClient(TLSSettings{UseTLS: true}, time.Second)
+	_, err := client.Get(s.URL)
+	assert.NotNil(t, err)
+
+	client, _ = NewClient(TLSSettings{UseTLS: true, CACert: certificatePEM(s)}, time.Second)
+	resp, err := client.Get(s.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func Test_NewGuardedClient(t *testing.T) {
+	defer resetClients()
+
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	refused := errors.New("refused")
+	dialed := make(chan string, 1)
+
+	dialer := NewDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed <- address
+		return nil, refused
+	})
+
+	client, err := NewGuardedClient(TLSSettings{}, time.Second, dialer)
+	assert.Nil(t, err)
+	assert.False(t, client.Transport == http.DefaultTransport)
+
+	same, _ := NewGuardedClient(TLSSettings{}, time.Second, dialer)
+	assert.True(t, client == same)
+
+	// Even a dialer doing the same gets its own client
+	other, _ := NewGuardedClient(TLSSettings{}, time.Second, NewDialer(dialer.Dial))
+	assert.False(t, client == other)
+
+	plain, _ := NewClient(TLSSettings{}, time.Second)
+	assert.False(t, client == plain)
+
+	_, err = client.Get(s.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, strings.TrimPrefix(s.URL, "http://"), <-dialed)
+}
+
+func Test_NewClient_Redirects(t *testing.T) {
+	followed := false
+
+	internal := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { followed = true }))
+	defer internal.Close()
+
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, internal.URL, http.StatusFound)
+		}))
+	defer s.Close()
+
+	client, _ := NewClient(TLSSettings{}, time.Second)
+
+	resp, err := client.Get(s.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.False(t, followed)
+}